@@ -52,7 +52,7 @@ func main() {
 	}
 	defer conn.Close()
 
-	// Raw public key bytes (65)
+	// Raw public key bytes (33, compressed)
 	pubBytes := wallet.PublicKey
 
 	// Create a test transaction first (without signature)
@@ -62,33 +62,25 @@ func main() {
 			{
 				PrevTx:    []byte("prev_tx_hash_123"),
 				PrevIndex: 0,
-				Signature: []byte{}, // Will be filled after signing
-				PubKey:    pubBytes,
-				Net:       "testnet",
+				Net:       "testnet", // Unlocking script is filled after signing
 			},
 		},
 		TxOuts: []core.TxOut{
 			{
 				Amount: 1000000,
-				// Locking script es hash SHA3-256 de la clave pública del destinatario (en este demo, nos enviamos a nosotros mismos)
-				LockingScript: core.HashSHA3(pubBytes),
+				// Locking script P2PKH del destinatario (en este demo, nos enviamos a nosotros mismos)
+				LockingScript: core.PayToPubKeyHashScript(core.HashSHA3(pubBytes)),
 			},
 		},
 	}
 
-	// Get the transaction hash for signing
-	txHash := tx.GetHashForSigning()
-
-	// Sign the transaction hash with ECDSA
-	signature, err := wallet.SignECDSA(txHash)
-	if err != nil {
+	// Sign the input; the signature commits to the output it spends (demo values)
+	prevOut := core.TxOut{Amount: 1000000, LockingScript: wallet.GetLockingScript()}
+	if err := wallet.SignInput(&tx, 0, prevOut, core.SigHashAll); err != nil {
 		fmt.Printf("Error signing transaction: %v\n", err)
 		return
 	}
 
-	// Add the signature to the transaction
-	tx.TxIns[0].Signature = signature
-
 	// Create the transaction message (PublicKeys field deprecated, left empty)
 	txMsg := core.TransactionMessage{
 		Transaction: tx,
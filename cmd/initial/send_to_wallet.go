@@ -9,9 +9,9 @@ import (
 	"github.com/xkal1bur/blockchain/pkg/core"
 )
 
-// walllets to send:
-// ar: c8c3a919c9ca981291263d9ccdc2b04e9432bbf57ff0a84dcd53c7b74fc79aa7
-// an: e4cf9ec444babdf51e5783162ba14efb5210447f40f5d842ab23c945c7dfc643
+// walllets to send (key hash in parentheses):
+// ar: blk1qerp6jxwfe2vp9yfx8kwvms4sf62r9wl40lc2snwd20rmwn78n2nstwfurq (c8c3a919c9ca981291263d9ccdc2b04e9432bbf57ff0a84dcd53c7b74fc79aa7)
+// an: blk1qun8ea3zyh27l28jhsvtzhg2wldfpq3rlgr6ass4ty0y5t37lcepsr0q0jp (e4cf9ec444babdf51e5783162ba14efb5210447f40f5d842ab23c945c7dfc643)
 
 func main() {
 	// 1) Cargar o crear wallet local
@@ -35,27 +35,35 @@ func main() {
 	fmt.Println("⚙️  Creando bloque génesis para el wallet…")
 	amountGenesis := uint64(1_000_000)
 
-	targets := []string{"c8c3a919c9ca981291263d9ccdc2b04e9432bbf57ff0a84dcd53c7b74fc79aa7", "e4cf9ec444babdf51e5783162ba14efb5210447f40f5d842ab23c945c7dfc643"}
+	// Direcciones con checksum: un error de tipeo se rechaza en vez de perder los fondos
+	targets := []string{"blk1qerp6jxwfe2vp9yfx8kwvms4sf62r9wl40lc2snwd20rmwn78n2nstwfurq", "blk1qun8ea3zyh27l28jhsvtzhg2wldfpq3rlgr6ass4ty0y5t37lcepsr0q0jp"}
 
 	// Única coinbase con 3 salidas por destino
 	var outputs []core.TxOut
 	for _, addr := range targets {
+		script, err := core.PayToAddressScript(addr)
+		if err != nil {
+			fmt.Printf("❌ Error: Dirección inválida %s: %v\n", addr, err)
+			return
+		}
 		for i := 0; i < 3; i++ {
 			outputs = append(outputs, core.TxOut{
 				Amount:        amountGenesis,
-				LockingScript: []byte(addr),
+				LockingScript: script,
 			})
 		}
 	}
 
-	coinbaseTx := core.Tx{Version: 1, TxOuts: outputs}
+	coinbaseTx := core.NewCoinbaseTx(0, outputs)
 
 	genesis := core.Block{
-		Version:      1,
-		PrevBlock:    make([]byte, 32),
-		Timestamp:    uint64(time.Now().Unix()),
-		Nonce:        0,
-		Bits:         1,
+		BlockHeader: core.BlockHeader{
+			Version:   1,
+			PrevBlock: make([]byte, 32),
+			Timestamp: uint64(time.Now().Unix()),
+			Nonce:     0,
+			Bits:      core.DefaultChainParams.InitialBits,
+		},
 		Transactions: []core.Tx{coinbaseTx},
 	}
 
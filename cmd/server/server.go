@@ -3,29 +3,92 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/xkal1bur/blockchain/pkg/core"
 )
 
+// listenAddr is the address the server listens on
+const listenAddr = ":8081"
+
 func main() {
+	// Address announced to peers so they can request missing blocks from us
+	advertise := flag.String("addr", "", "address (host:port) peers can reach this node at")
+	walletFile := flag.String("wallet", "wallet.json", "wallet that receives the rewards of mined blocks")
+	mempoolDump := flag.Duration("mempool-dump", time.Minute, "interval between saves of the mempool to disk")
+
+	// Consensus parameters, must match the rest of the network
+	params := core.DefaultChainParams
+	flag.Uint64Var(&params.RetargetInterval, "retarget-interval", params.RetargetInterval, "blocks between difficulty adjustments")
+	flag.DurationVar(&params.TargetBlockTime, "block-time", params.TargetBlockTime, "target time between blocks")
+	flag.Uint64Var(&params.MaxBitsAdjustment, "max-bits-adjustment", params.MaxBitsAdjustment, "maximum difficulty change (bits) per retarget")
+	flag.DurationVar(&params.MaxFutureBlockTime, "max-future-time", params.MaxFutureBlockTime, "how far ahead of network time a block timestamp may be")
+	flag.Uint64Var(&params.InitialSubsidy, "subsidy", params.InitialSubsidy, "block reward before the first halving")
+	flag.Uint64Var(&params.HalvingInterval, "halving-interval", params.HalvingInterval, "blocks between reward halvings")
+	flag.Parse()
+
 	fmt.Println("🚀 Starting Blockchain TCP Server...")
 
-	server := core.NewBlockchainServer()
+	server := core.NewBlockchainServerWithParams(params)
+	// Peers identify us by the IP we connect from and the port we announce,
+	// so announce at least the port
+	if *advertise == "" {
+		*advertise = listenAddr
+	}
+	server.SetListenAddress(*advertise)
 
-	// Configure peer servers from command line arguments or environment
-	if len(os.Args) > 1 {
-		for _, peer := range os.Args[1:] {
-			server.AddPeer(peer)
+	// Load or create the wallet that collects mining rewards
+	var minerWallet *core.Wallet
+	var err error
+	if core.WalletExists(*walletFile) {
+		minerWallet, err = core.LoadWallet(*walletFile)
+	} else {
+		minerWallet, err = core.NewWallet()
+		if err == nil {
+			minerWallet.WalletFile = *walletFile
+			err = minerWallet.SaveToDisk()
 		}
 	}
+	if err != nil {
+		log.Fatal("Error loading miner wallet:", err)
+	}
+	server.SetMinerWallet(minerWallet)
+
+	// Configure peer servers from command line arguments or environment
+	for _, peer := range flag.Args() {
+		server.AddPeer(peer)
+	}
+
+	// Keep the pending transactions across restarts: save the mempool
+	// periodically, and on shutdown
+	go func() {
+		for range time.Tick(*mempoolDump) {
+			if err := server.SaveMempool(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Println("\n🛑 Shutting down...")
+		if err := server.SaveMempool(); err != nil {
+			log.Printf("%v", err)
+		}
+		os.Exit(0)
+	}()
 
 	// Listen on TCP port 8081
-	listener, err := net.Listen("tcp", ":8081")
+	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		log.Fatal("Error starting server:", err)
 	}
@@ -35,6 +98,8 @@ func main() {
 	fmt.Println("Protocol Messages:")
 	fmt.Println("  TRANSACTION:<json> - Submit transaction with public keys")
 	fmt.Println("  BLOCK:<json>       - Receive validated block from peer")
+	fmt.Println("  GETBLOCK:<hash>    - Request a block by hash")
+	fmt.Println("  INV:<json>         - Announce transactions by ID, answered with GETDATA:<json>")
 
 	// Accept connections
 	for {
@@ -68,7 +133,7 @@ func main() {
 				}
 
 				// Process the message using the blockchain server
-				response := processMessage(server, message)
+				response := processMessage(server, c.RemoteAddr(), message)
 
 				// Send response back to client
 				_, writeErr := c.Write([]byte(response + "\n"))
@@ -81,22 +146,10 @@ func main() {
 	}
 }
 
-// processMessage processes a single message and returns the response
-func processMessage(server *core.BlockchainServer, message string) string {
-	var response string
-
-	// Parse different message types
-	if strings.HasPrefix(message, "TRANSACTION:") {
-		txJSON := strings.TrimPrefix(message, "TRANSACTION:")
-		response = server.ProcessTransactionMessage(txJSON)
-	} else if strings.HasPrefix(message, "BLOCK:") {
-		blockJSON := strings.TrimPrefix(message, "BLOCK:")
-		response = server.ProcessBlockMessage(blockJSON)
-	} else {
-		response = "ERROR: Unknown message format. Use TRANSACTION:<json> or BLOCK:<json>"
-	}
-
-	return response
+// processMessage processes a single message received from remote and returns
+// the response
+func processMessage(server *core.BlockchainServer, remote net.Addr, message string) string {
+	return server.ProcessMessageFrom(remote, message)
 }
 
 // printTransaction prints the transaction details in a human-readable format
@@ -121,14 +174,15 @@ func printTransaction(message string) {
 		fmt.Printf("    Previous Tx: %x\n", input.PrevTx)
 		fmt.Printf("    Index: %d\n", input.PrevIndex)
 		fmt.Printf("    Network: %s\n", input.Net)
-		fmt.Printf("    Signature: %x\n", input.Signature)
+		fmt.Printf("    Unlocking Script: %s\n", core.DisasmScript(input.UnlockingScript))
 	}
 
 	fmt.Printf("\nOutputs (%d):\n", len(tx.TxOuts))
 	for i, output := range tx.TxOuts {
 		fmt.Printf("  Output %d:\n", i+1)
 		fmt.Printf("    Amount: %d\n", output.Amount)
-		fmt.Printf("    Locking Script: %x\n", output.LockingScript)
+		fmt.Printf("    Locking Script: %s\n", core.DisasmScript(output.LockingScript))
 	}
-	fmt.Println("=============================\n")
+	fmt.Println("=============================")
+	fmt.Println()
 }
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// Atomic swap between two chains using hash time-locked contracts (HTLC).
+//
+//  1. The initiator creates a secret and locks coins on chain A to the
+//     participant, refundable after a long timeout.
+//  2. The participant locks coins on chain B to the initiator with the same
+//     secret hash and a shorter timeout.
+//  3. The initiator redeems on chain B, revealing the secret.
+//  4. The participant extracts the secret and redeems on chain A.
+//
+// If either side stops, the coins go back to their owners after the timeouts.
+
+// contract is the file the parties exchange: the HTLC and the output funding it
+type contract struct {
+	HTLC    core.HTLC  `json:"htlc"`
+	UTXOKey string     `json:"utxo_key"` // "txid:index" of the contract output
+	Output  core.TxOut `json:"output"`
+}
+
+var (
+	walletFile = flag.String("wallet", "wallet.json", "wallet of this party")
+	nodeAddr   = flag.String("node", "localhost:8081", "node of the chain the command acts on")
+	utxoFile   = flag.String("utxos", "utxos.dat", "UTXO set file of that node")
+	chainFile  = flag.String("chain", "blockchain.dat", "blockchain file of that node")
+	timeout    = flag.Uint64("timeout", 0, "blocks until the contract can be refunded (default 48 to initiate, 24 to participate)")
+	fee        = flag.Uint64("fee", 1000, "fee of the transactions spending a contract")
+	feeRate    = flag.Uint64("feerate", core.DefaultMempoolConfig.MinRelayFeeRate, "fee per byte of the transaction funding a contract")
+	theirUTXOs = flag.String("their-utxos", "", "UTXO set file of a node of the initiator's chain (participate)")
+	theirChain = flag.String("their-chain", "", "blockchain file of that node (participate)")
+)
+
+// refundMargin is how many blocks the initiator's contract must stay locked
+// after the participant's one can be refunded, so that the participant has
+// time to redeem it once the secret is revealed
+const refundMargin = 12
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: swap [flags] <command> [args]")
+		fmt.Fprintln(os.Stderr, "Commands:")
+		fmt.Fprintln(os.Stderr, "  initiate <participant address> <amount>  lock coins with a new secret")
+		fmt.Fprintln(os.Stderr, "  participate <contract.json> <their amount> <amount>")
+		fmt.Fprintln(os.Stderr, "                                           check the initiator's contract on its chain and")
+		fmt.Fprintln(os.Stderr, "                                           lock coins against its secret hash")
+		fmt.Fprintln(os.Stderr, "  redeem <contract.json> <secret>          claim a contract paying to us")
+		fmt.Fprintln(os.Stderr, "  refund <contract.json>                   take back our expired contract")
+		fmt.Fprintln(os.Stderr, "  extractsecret <contract.json>            find the secret revealed by the redeem of a contract")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := args[0]; {
+	case cmd == "initiate" && len(args) == 3:
+		err = initiate(args[1], args[2])
+	case cmd == "participate" && len(args) == 4:
+		err = participate(args[1], args[2], args[3])
+	case cmd == "redeem" && len(args) == 3:
+		err = redeem(args[1], args[2])
+	case cmd == "refund" && len(args) == 2:
+		err = refund(args[1])
+	case cmd == "extractsecret" && len(args) == 2:
+		err = extractSecret(args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func initiate(participantAddr, amountStr string) error {
+	secret, err := core.NewHTLCSecret()
+	if err != nil {
+		return err
+	}
+	addr, err := core.DecodeAddress(participantAddr)
+	if err != nil {
+		return err
+	}
+	if addr.Version != core.AddressPubKeyHash {
+		return fmt.Errorf("participant address %s is not a wallet address", participantAddr)
+	}
+	c, err := lockCoins(core.HashSHA3(secret), addr.Hash, amountStr, timeoutBlocks(48))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🔑 Secret: %x\n", secret)
+	fmt.Println("   Keep it private until the participant has locked its coins, then redeem their contract with it")
+	return saveContract(c)
+}
+
+func participate(contractFile, theirAmountStr, amountStr string) error {
+	theirs, err := loadContract(contractFile)
+	if err != nil {
+		return err
+	}
+	theirAmount, err := strconv.ParseUint(theirAmountStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", theirAmountStr)
+	}
+	wallet, err := core.LoadWallet(*walletFile)
+	if err != nil {
+		return fmt.Errorf("error loading wallet: %v", err)
+	}
+	if hex.EncodeToString(theirs.HTLC.ReceiverPubKeyHash) != wallet.GetAddressHex() {
+		return fmt.Errorf("contract %s does not pay to this wallet", contractFile)
+	}
+
+	// Pay back to the initiator, with a shorter timeout than theirs
+	blocks := timeoutBlocks(24)
+	if err := checkTheirContract(theirs, theirAmount, blocks); err != nil {
+		return err
+	}
+	c, err := lockCoins(theirs.HTLC.SecretHash, theirs.HTLC.SenderPubKeyHash, amountStr, blocks)
+	if err != nil {
+		return err
+	}
+	return saveContract(c)
+}
+
+// checkTheirContract makes sure the initiator's contract is what the file
+// says it is and is confirmed on its chain (-their-utxos, -their-chain)
+// paying at least amount, and that it cannot be refunded until refundMargin
+// blocks after a contract of ours locked for blocks from now
+func checkTheirContract(theirs *contract, amount, blocks uint64) error {
+	if *theirUTXOs == "" || *theirChain == "" {
+		return fmt.Errorf("-their-utxos and -their-chain are needed to check the initiator's contract")
+	}
+	h := theirs.HTLC
+	if h.LockTime >= core.LockTimeThreshold {
+		return fmt.Errorf("initiator contract refund is not locked by height")
+	}
+	expected, err := core.NewHTLC(h.SecretHash, h.ReceiverPubKeyHash, h.SenderPubKeyHash, h.LockTime)
+	if err != nil {
+		return fmt.Errorf("invalid initiator contract: %v", err)
+	}
+	if !bytes.Equal(h.RedeemScript, expected.RedeemScript) {
+		return fmt.Errorf("initiator redeem script does not match the contract terms")
+	}
+	script := expected.LockingScript()
+	if !bytes.Equal(theirs.Output.LockingScript, script) {
+		return fmt.Errorf("initiator contract output does not pay to the contract")
+	}
+
+	chain, err := loadChainFile(*theirChain)
+	if err != nil {
+		return err
+	}
+	utxos, tipHash, err := loadUTXOFile(*theirUTXOs)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 || tipHash != hex.EncodeToString(chain[len(chain)-1].BlockHeader.Hash()) {
+		return fmt.Errorf("%s is not the UTXO set of the tip of %s", *theirUTXOs, *theirChain)
+	}
+	out, ok := utxos[theirs.UTXOKey]
+	if !ok {
+		return fmt.Errorf("initiator contract %s is not an unspent output of its chain", theirs.UTXOKey)
+	}
+	if !bytes.Equal(out.LockingScript, script) {
+		return fmt.Errorf("output %s on the initiator's chain does not pay to the contract", theirs.UTXOKey)
+	}
+	if out.Amount < amount {
+		return fmt.Errorf("initiator contract locks %d coins, expected %d", out.Amount, amount)
+	}
+
+	height := uint64(len(chain))
+	if uint64(h.LockTime) < height+blocks+refundMargin {
+		return fmt.Errorf("initiator contract is refundable at height %d, before %d blocks from height %d", h.LockTime, blocks+refundMargin, height)
+	}
+	fmt.Printf("📜 Initiator contract: %d coins confirmed, refundable after height %d of its chain (now %d)\n",
+		out.Amount, h.LockTime, height)
+	return nil
+}
+
+// timeoutBlocks returns the -timeout flag, or defaultTimeout if not given
+func timeoutBlocks(defaultTimeout uint64) uint64 {
+	if *timeout == 0 {
+		return defaultTimeout
+	}
+	return *timeout
+}
+
+// lockCoins funds an HTLC paying amount to the owner of the public key hash
+// receiver against secretHash, refundable after blocks more blocks
+func lockCoins(secretHash, receiver []byte, amountStr string, blocks uint64) (*contract, error) {
+	wallet, err := core.LoadWallet(*walletFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading wallet: %v", err)
+	}
+	amount, err := strconv.ParseUint(amountStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", amountStr)
+	}
+	chain, err := loadChain()
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := loadUTXOs()
+	if err != nil {
+		return nil, err
+	}
+
+	lockTime := uint64(len(chain)) + blocks
+	if lockTime >= uint64(core.LockTimeThreshold) {
+		return nil, fmt.Errorf("timeout of %d blocks is too long", blocks)
+	}
+	htlc, err := core.NewHTLC(secretHash, receiver, core.HashSHA3(wallet.PublicKey), uint32(lockTime))
+	if err != nil {
+		return nil, err
+	}
+
+	fund, _, err := wallet.BuildTransaction(htlc.LockingScript(), amount, *feeRate, utxos)
+	if err != nil {
+		return nil, err
+	}
+	if err := broadcast(fund); err != nil {
+		return nil, err
+	}
+	fmt.Printf("🔒 Locked %d coins until height %d\n", amount, lockTime)
+	fmt.Printf("   Redeem script: %s\n", core.DisasmScript(htlc.RedeemScript))
+
+	return &contract{
+		HTLC:    *htlc,
+		UTXOKey: fmt.Sprintf("%s:0", fund.ID()),
+		Output:  fund.TxOuts[0],
+	}, nil
+}
+
+func redeem(contractFile, secretHex string) error {
+	c, err := loadContract(contractFile)
+	if err != nil {
+		return err
+	}
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil {
+		return fmt.Errorf("invalid secret: %v", err)
+	}
+	wallet, err := core.LoadWallet(*walletFile)
+	if err != nil {
+		return fmt.Errorf("error loading wallet: %v", err)
+	}
+	tx, err := wallet.RedeemHTLC(&c.HTLC, c.UTXOKey, c.Output, secret, *fee)
+	if err != nil {
+		return err
+	}
+	if err := broadcast(tx); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Contract redeemed, %d coins sent to %s\n", tx.TxOuts[0].Amount, wallet.GetAddress())
+	return nil
+}
+
+func refund(contractFile string) error {
+	c, err := loadContract(contractFile)
+	if err != nil {
+		return err
+	}
+	wallet, err := core.LoadWallet(*walletFile)
+	if err != nil {
+		return fmt.Errorf("error loading wallet: %v", err)
+	}
+	tx, err := wallet.RefundHTLC(&c.HTLC, c.UTXOKey, c.Output, *fee)
+	if err != nil {
+		return err
+	}
+	if err := broadcast(tx); err != nil {
+		return fmt.Errorf("%v (the contract can be refunded after height %d)", err, c.HTLC.LockTime)
+	}
+	fmt.Printf("↩️  Contract refunded, %d coins back to %s\n", tx.TxOuts[0].Amount, wallet.GetAddress())
+	return nil
+}
+
+// extractSecret looks in the chain for the transaction spending the contract
+// and prints the secret it revealed
+func extractSecret(contractFile string) error {
+	c, err := loadContract(contractFile)
+	if err != nil {
+		return err
+	}
+	chain, err := loadChain()
+	if err != nil {
+		return err
+	}
+	for _, block := range chain {
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			for _, in := range tx.TxIns {
+				if fmt.Sprintf("%x:%d", in.PrevTx, in.PrevIndex) != c.UTXOKey {
+					continue
+				}
+				secret, err := core.ExtractHTLCSecret(tx, &c.HTLC)
+				if err != nil {
+					return fmt.Errorf("contract was spent by %s without revealing the secret (refunded?)", tx.ID())
+				}
+				fmt.Printf("🔑 Secret: %x\n", secret)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("contract %s has not been redeemed yet", c.UTXOKey)
+}
+
+// broadcast sends tx to the node and waits for its answer
+func broadcast(tx core.Tx) error {
+	txJSON, err := json.Marshal(core.TransactionMessage{Transaction: tx})
+	if err != nil {
+		return fmt.Errorf("error marshaling transaction: %v", err)
+	}
+	conn, err := net.Dial("tcp", *nodeAddr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", *nodeAddr, err)
+	}
+	defer conn.Close()
+
+	fmt.Printf("📤 Sending transaction %s to %s\n", tx.ID(), *nodeAddr)
+	if _, err := fmt.Fprintf(conn, "TRANSACTION:%s\n", txJSON); err != nil {
+		return fmt.Errorf("error sending transaction: %v", err)
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	response = strings.TrimSpace(response)
+	fmt.Println("📡 Server response:", response)
+	if !strings.HasPrefix(response, "SUCCESS") {
+		return fmt.Errorf("transaction rejected")
+	}
+	return nil
+}
+
+func loadContract(path string) (*contract, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c contract
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid contract file %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+func saveContract(c *contract) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("contract_%s.json", c.UTXOKey[:8])
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("💾 Contract saved to %s, send it to the other party\n", path)
+	return nil
+}
+
+func loadChain() ([]core.Block, error) {
+	return loadChainFile(*chainFile)
+}
+
+func loadChainFile(path string) ([]core.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading blockchain: %v", err)
+	}
+	chain, err := core.DecodeBlocks(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blockchain file %s: %v", path, err)
+	}
+	return chain, nil
+}
+
+func loadUTXOs() (map[string]core.TxOut, error) {
+	utxos, _, err := loadUTXOFile(*utxoFile)
+	return utxos, err
+}
+
+// loadUTXOFile returns the UTXO set stored in path and the hash of the block
+// it is the state after
+func loadUTXOFile(path string) (map[string]core.TxOut, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading UTXO set: %v", err)
+	}
+	utxos, tipHash, err := core.DecodeUTXOSet(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid UTXO set file %s: %v", path, err)
+	}
+	return utxos, tipHash, nil
+}
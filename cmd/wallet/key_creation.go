@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +11,10 @@ import (
 
 func main() {
 	walletFile := "wallet.json"
+	curve := flag.String("curve", "p256", "curve of the new keys (p256 or secp256k1)")
+	sigScheme := flag.String("sig", "ecdsa", "signature scheme of the new wallet (ecdsa or schnorr)")
+	network := flag.String("net", "mainnet", "network of the new wallet's addresses (mainnet or testnet)")
+	flag.Parse()
 
 	fmt.Println("🏦 Blockchain Wallet Generator")
 	fmt.Println("==============================")
@@ -31,11 +36,24 @@ func main() {
 	}
 
 	// Create new wallet
-	fmt.Println("🔐 Generating new cryptographic keys...")
-	wallet, err := core.NewWallet()
+	keyType, err := core.ParseKeyType(*curve)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	sigType, err := core.ParseSigType(*sigScheme)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := core.CheckNetwork(*network); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Printf("🔐 Generating new %s cryptographic keys...\n", keyType)
+	wallet, err := core.NewWalletWithKeyType(keyType)
 	if err != nil {
 		log.Fatalf("❌ Error creating wallet: %v", err)
 	}
+	wallet.SigType = sigType
+	wallet.Net = *network
 
 	// Set wallet file path
 	wallet.WalletFile = walletFile
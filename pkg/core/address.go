@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/xkal1bur/blockchain/pkg/crypto"
+)
+
+// AddressVersion says what the hash of an address commits to
+type AddressVersion byte
+
+const (
+	AddressPubKeyHash AddressVersion = 0 // SHA3-256 of a public key (P2PKH)
+	AddressScriptHash AddressVersion = 1 // SHA3-256 of a redeem script (P2SH)
+)
+
+// Human readable prefixes of the addresses of each network
+var addressPrefixes = map[string]string{
+	"mainnet": "blk",
+	"testnet": "tblk",
+}
+
+// CheckNetwork returns an error if net is not a network with addresses
+func CheckNetwork(net string) error {
+	if _, ok := addressPrefixes[net]; !ok {
+		return fmt.Errorf("unknown network %q", net)
+	}
+	return nil
+}
+
+// Address is a checksummed destination: Bech32m of a network prefix, a
+// version and the 32-byte hash, for example blk1q... for a mainnet P2PKH.
+// A mistyped character makes the address invalid instead of sending the
+// funds to a key nobody has.
+type Address struct {
+	Net     string
+	Version AddressVersion
+	Hash    []byte
+}
+
+// NewAddress returns the P2PKH address of pubKey on net
+func NewAddress(net string, pubKey []byte) Address {
+	return Address{Net: net, Version: AddressPubKeyHash, Hash: HashSHA3(pubKey)}
+}
+
+// NewScriptAddress returns the P2SH address of redeemScript on net
+func NewScriptAddress(net string, redeemScript []byte) Address {
+	return Address{Net: net, Version: AddressScriptHash, Hash: HashSHA3(redeemScript)}
+}
+
+// String encodes the address, or describes why it cannot be encoded (see
+// Encode)
+func (a Address) String() string {
+	s, err := a.Encode()
+	if err != nil {
+		return fmt.Sprintf("<invalid address: %v>", err)
+	}
+	return s
+}
+
+// Encode returns the Bech32m form of the address. It fails on an unknown
+// network or a hash that is not 32 bytes.
+func (a Address) Encode() (string, error) {
+	if err := CheckNetwork(a.Net); err != nil {
+		return "", err
+	}
+	prefix := addressPrefixes[a.Net]
+	if len(a.Hash) != 32 {
+		return "", fmt.Errorf("address hash must be 32 bytes, got %d", len(a.Hash))
+	}
+	data, err := crypto.ConvertBits(a.Hash, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncodeBech32m(prefix, append([]byte{byte(a.Version)}, data...))
+}
+
+// DecodeAddress parses and checks an address
+func DecodeAddress(s string) (Address, error) {
+	prefix, data, err := crypto.DecodeBech32m(s)
+	if err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	var a Address
+	for net, p := range addressPrefixes {
+		if p == prefix {
+			a.Net = net
+		}
+	}
+	if a.Net == "" {
+		return Address{}, fmt.Errorf("invalid address %q: unknown prefix %q", s, prefix)
+	}
+	if len(data) == 0 {
+		return Address{}, fmt.Errorf("invalid address %q: missing version", s)
+	}
+	a.Version = AddressVersion(data[0])
+	if a.Version != AddressPubKeyHash && a.Version != AddressScriptHash {
+		return Address{}, fmt.Errorf("invalid address %q: unknown version %d", s, a.Version)
+	}
+	if a.Hash, err = crypto.ConvertBits(data[1:], 5, 8, false); err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %v", s, err)
+	}
+	if len(a.Hash) != 32 {
+		return Address{}, fmt.Errorf("invalid address %q: hash is %d bytes, expected 32", s, len(a.Hash))
+	}
+	return a, nil
+}
+
+// LockingScript returns the script paying to the address
+func (a Address) LockingScript() []byte {
+	if a.Version == AddressScriptHash {
+		return PayToScriptHashScript(a.Hash)
+	}
+	return PayToPubKeyHashScript(a.Hash)
+}
+
+// ScriptAddress returns the address a P2PKH or P2SH locking script pays to
+func ScriptAddress(net string, script []byte) (Address, bool) {
+	if hash := ExtractPubKeyHash(script); hash != nil {
+		return Address{Net: net, Version: AddressPubKeyHash, Hash: hash}, true
+	}
+	if IsPayToScriptHash(script) {
+		return Address{Net: net, Version: AddressScriptHash, Hash: script[2:34]}, true
+	}
+	return Address{}, false
+}
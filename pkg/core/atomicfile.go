@@ -0,0 +1,34 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces filename with data. The data is written to a
+// temporary file in the same directory, which is then renamed over filename:
+// a crash halfway through leaves the previous version instead of a truncated
+// file.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Nothing left to remove once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
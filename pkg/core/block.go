@@ -1,42 +1,109 @@
 package core
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"encoding/json"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"math/big"
 	"math/bits"
 
 	"github.com/xkal1bur/blockchain/pkg/crypto"
 )
 
+// BlockHeader is the part of a block covered by the Proof of Work. It commits
+// to the transactions through MerkleRoot, so mining cost does not depend on
+// the size of the block.
+type BlockHeader struct {
+	Version     uint64 `json:"version"`
+	PrevBlock   []byte `json:"prev_block"`   // 32 bytes
+	MerkleRoot  []byte `json:"merkle_root"`  // 32 bytes, root of the transaction IDs
+	WitnessRoot []byte `json:"witness_root"` // 32 bytes, root of the witness hashes (IDs leave out unlocking scripts)
+	Timestamp   uint64 `json:"timestamp"`
+	Bits        uint64 `json:"bits"` // Difficulty: leading zero bits of the hash
+	Nonce       uint64 `json:"nonce"`
+}
+
 type Block struct {
-	Version      uint64 `json:"version"`
-	PrevBlock    []byte `json:"prev_block"` // 32 bytes, ojalá
-	Timestamp    uint64 `json:"timestamp"`
-	Nonce        uint64 `json:"nonce"`
-	Bits         uint64 `json:"bits"`         //  difficulty?
-	Transactions []Tx   `json:"transactions"` // Transactions in the block
+	BlockHeader
+	Transactions []Tx `json:"transactions"` // Transactions in the block
+}
+
+// BlockHeaderSize is the length of the fixed-width encoding of a header: the
+// codec version, four integers of 8 bytes and three hashes of 32 bytes
+const BlockHeaderSize = 1 + 8 + 32 + 32 + 32 + 8 + 8 + 8
+
+// nonceOffset is where the nonce lives inside the encoded header
+const nonceOffset = BlockHeaderSize - 8
+
+// Serialize returns the fixed-width encoding of the header (see codec.go)
+func (h *BlockHeader) Serialize() []byte {
+	return encodeObject(h.encode)
+}
+
+// Hash returns the block ID, SHA3 of the serialized header
+func (h *BlockHeader) Hash() []byte {
+	return crypto.Sha3_256(h.Serialize())
 }
 
 // Get block ID
 func (b *Block) Hash() ([]byte, error) {
-	blockBytes, err := json.Marshal(b)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to serialize (marshal) block: %v", err)
+	return b.BlockHeader.Hash(), nil
+}
+
+// txLeaves returns the transaction IDs used as Merkle leaves
+func (b *Block) txLeaves() [][]byte {
+	leaves := make([][]byte, len(b.Transactions))
+	for i := range b.Transactions {
+		leaves[i], _ = hex.DecodeString(b.Transactions[i].ID())
+	}
+	return leaves
+}
+
+// ComputeMerkleRoot returns the Merkle root of the block's transactions
+func (b *Block) ComputeMerkleRoot() []byte {
+	return crypto.MerkleRoot(b.txLeaves())
+}
+
+// HasValidMerkleRoot checks the header commits to the block's transactions
+func (b *Block) HasValidMerkleRoot() bool {
+	return bytes.Equal(b.MerkleRoot, b.ComputeMerkleRoot())
+}
+
+// ComputeWitnessRoot returns the Merkle root of the witness hashes of the
+// block's transactions
+func (b *Block) ComputeWitnessRoot() []byte {
+	leaves := make([][]byte, len(b.Transactions))
+	for i := range b.Transactions {
+		leaves[i], _ = hex.DecodeString(b.Transactions[i].WitnessHash())
 	}
-	return crypto.Sha3_256(blockBytes), nil
+	return crypto.MerkleRoot(leaves)
+}
+
+// HasValidWitnessRoot checks the header commits to the unlocking scripts of
+// the block's transactions
+func (b *Block) HasValidWitnessRoot() bool {
+	return bytes.Equal(b.WitnessRoot, b.ComputeWitnessRoot())
+}
+
+// TxInclusionProof returns a Merkle proof that transaction index is part of
+// the block, verifiable against the header with crypto.VerifyMerkleProof
+func (b *Block) TxInclusionProof(index int) ([]crypto.MerkleStep, error) {
+	return crypto.MerkleProof(b.txLeaves(), index)
 }
 
-// Validate the block's hash against its difficulty target
+// Validate the block's hash against its difficulty target.
+// The Merkle roots are computed and the header encoded once; the nonce is the
+// last field, so each attempt only rewrites the tail of the encoding.
 func (b *Block) CalculateValidHash() bool {
+	b.MerkleRoot = b.ComputeMerkleRoot()
+	b.WitnessRoot = b.ComputeWitnessRoot()
+	header := b.BlockHeader.Serialize()
 	for nonce := uint64(0); nonce < ^uint64(0); nonce++ {
-		b.Nonce = nonce
-		hash, err := b.Hash()
-		if err != nil {
-			fmt.Println("Error hashing block:", err)
-			return false
-		}
-		if countLeadingZeroBits(hash) >= int(b.Bits) {
+		binary.LittleEndian.PutUint64(header[nonceOffset:], nonce)
+		if countLeadingZeroBits(crypto.Sha3_256(header)) >= int(b.Bits) {
+			b.Nonce = nonce
 			return true
 		}
 	}
@@ -54,6 +121,15 @@ func (b *Block) ValidateBlock(publicKeyMap map[int][]*ecdsa.PublicKey) bool {
 		return false
 	}
 
+	if !b.HasValidMerkleRoot() {
+		fmt.Println("Block Merkle root does not match its transactions.")
+		return false
+	}
+	if !b.HasValidWitnessRoot() {
+		fmt.Println("Block witness root does not match its transactions.")
+		return false
+	}
+
 	// Check if the hash meets the difficulty target
 	isHashValid := countLeadingZeroBits(hash) >= int(b.Bits)
 	if !isHashValid {
@@ -84,6 +160,12 @@ func countLeadingZeroBits(hash []byte) int {
 	return count
 }
 
+// Work returns the expected number of hashes needed to mine the block, 2^Bits.
+// The fork choice rule prefers the branch with the largest sum of Work.
+func (b *Block) Work() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(b.Bits))
+}
+
 // isValidHash checks if the given hash meets the difficulty target
 func (b *Block) isValidHash(hash []byte) bool {
 	return countLeadingZeroBits(hash) >= int(b.Bits)
@@ -2,7 +2,6 @@ package core
 
 import (
 	"bufio"
-	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
@@ -17,20 +16,43 @@ import (
 )
 
 type BlockchainServer struct {
-	pendingTransactions []Tx
-	blockchain          []Block
-	isMining            bool
-	mu                  sync.Mutex
-	blockchainFile      string
-	peerServers         []string // List of peer server addresses
+	params         ChainParams
+	mempool        *Mempool // Transactions waiting to be mined
+	blockchain     []Block
+	isMining       bool
+	mu             sync.Mutex
+	blockchainFile string
+	peerServers    []string          // List of peer server addresses
+	peerIDs        map[string]string // peerID of each peer server (see relay.go)
+	listenAddr     string            // Address peers can reach us at, sent along with our blocks
 
-	utxoSet map[string]TxOut // Unspent transaction outputs
+	minerScript []byte // Locking script paid by the coinbase of our blocks
+
+	utxoSet   map[string]TxOut     // Unspent transaction outputs
+	undoData  map[string]BlockUndo // Spent outputs of each connected block, by block hash
+	txHeights map[string]uint64    // Height of each transaction of the active chain, by ID
+
+	blockIndex    map[string]*blockNode // Every known block (main chain and side branches) by hash
+	invalidBlocks map[string]bool       // Blocks that failed validation, never to be reconsidered
+	tip           *blockNode            // Tip of the active (most cumulative work) chain
+
+	orphanBlocks  map[string]*orphanBlock // Blocks waiting for their parent, by hash
+	orphansByPrev map[string][]string     // Orphan hashes keyed by the PrevBlock they wait for
+
+	orphanTxs       map[string]*orphanTx // Transactions waiting for their parents, by ID
+	orphanTxsByPrev map[string][]string  // Orphan transaction IDs keyed by the parents they wait for
+
+	peerInventory map[string]*knownInventory // Transactions each peer knows about, by peerID
+
+	timeSamples map[string]int64 // Clock offset (seconds) of each peer host
+	timeOffset  time.Duration    // Median peer offset applied to our clock
 }
 
 // TransactionMessage represents a transaction with its public key for validation
 type TransactionMessage struct {
-	Transaction Tx              `json:"transaction"`
+	Transaction Tx              `json:"transaction"` // Hex of the binary encoding (see codec.go)
 	PublicKeys  []PublicKeyData `json:"public_keys"`
+	From        string          `json:"from,omitempty"` // Listening address of the peer relaying it
 }
 
 // PublicKeyData represents a serialized public key
@@ -41,31 +63,71 @@ type PublicKeyData struct {
 
 // BlockMessage represents a validated block from another server
 type BlockMessage struct {
-	Block      Block             `json:"block"`
-	PublicKeys [][]PublicKeyData `json:"public_keys"` // Public keys for each transaction
+	Block      Block             `json:"block"`          // Hex of the binary encoding (see codec.go)
+	PublicKeys [][]PublicKeyData `json:"public_keys"`    // Public keys for each transaction
+	From       string            `json:"from,omitempty"` // Listening address of the sender, used to fetch missing parents
+	Time       int64             `json:"time,omitempty"` // Sender's clock (Unix) when the message was sent
 }
 
-const utxoFile = "utxos.json"
+const utxoFile = "utxos.dat"
 
+// NewBlockchainServer creates a server using DefaultChainParams
 func NewBlockchainServer() *BlockchainServer {
+	return NewBlockchainServerWithParams(DefaultChainParams)
+}
+
+// NewBlockchainServerWithParams creates a server for a network with the given
+// consensus parameters
+func NewBlockchainServerWithParams(params ChainParams) *BlockchainServer {
 	server := &BlockchainServer{
-		pendingTransactions: make([]Tx, 0),
-		blockchain:          make([]Block, 0),
-		isMining:            false,
-		blockchainFile:      "blockchain.json",
-		peerServers:         []string{}, // Will be configured later
+		params:         params,
+		mempool:        NewMempool(DefaultMempoolConfig),
+		blockchain:     make([]Block, 0),
+		isMining:       false,
+		blockchainFile: "blockchain.dat",
+		peerServers:    []string{}, // Will be configured later
+		peerIDs:        make(map[string]string),
+
+		utxoSet:   make(map[string]TxOut),
+		undoData:  make(map[string]BlockUndo),
+		txHeights: make(map[string]uint64),
+
+		blockIndex:    make(map[string]*blockNode),
+		invalidBlocks: make(map[string]bool),
 
-		utxoSet: make(map[string]TxOut),
+		orphanBlocks:  make(map[string]*orphanBlock),
+		orphansByPrev: make(map[string][]string),
+
+		orphanTxs:       make(map[string]*orphanTx),
+		orphanTxsByPrev: make(map[string][]string),
+
+		peerInventory: make(map[string]*knownInventory),
+
+		timeSamples: make(map[string]int64),
 	}
 
 	// Load existing blockchain from disk
 	server.loadBlockchain()
+	server.loadBlockIndex()
 
-	// Try to load persisted UTXO set
+	// Try to load persisted UTXO set and undo records
 	if err := server.loadUTXOSet(); err != nil {
-		fmt.Println("🗄️  No UTXO set file found, rebuilding from blockchain…")
+		fmt.Printf("🗄️  UTXO set file missing or stale (%v), rebuilding from blockchain…\n", err)
+		server.rebuildUTXOSet()
+		server.saveUTXOSet()
+		server.saveUndoData()
+	} else if err := server.loadUndoData(); err != nil {
+		fmt.Println("🗄️  Undo data missing or incomplete, rebuilding from blockchain…")
 		server.rebuildUTXOSet()
 		server.saveUTXOSet()
+		server.saveUndoData()
+	}
+
+	// Pending transactions saved before the last shutdown
+	if kept, dropped, err := server.loadMempool(); err == nil {
+		fmt.Printf("🔄 Mempool loaded (%d transactions, %d dropped as expired or no longer valid)\n", kept, dropped)
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error loading mempool: %v", err)
 	}
 
 	return server
@@ -74,12 +136,22 @@ func NewBlockchainServer() *BlockchainServer {
 
 // AddPeer adds a peer server address for block broadcasting
 func (bs *BlockchainServer) AddPeer(peerAddress string) {
+	id := configuredPeerID(peerAddress)
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
 	bs.peerServers = append(bs.peerServers, peerAddress)
+	bs.peerIDs[peerAddress] = id
 	fmt.Printf("🔗 Added peer server: %s\n", peerAddress)
 }
 
+// SetListenAddress sets the address (host:port) peers can use to reach this
+// server. It is announced with our blocks so peers can ask for missing parents.
+func (bs *BlockchainServer) SetListenAddress(addr string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.listenAddr = addr
+}
+
 func (bs *BlockchainServer) HandleConnection(conn net.Conn) {
 	defer conn.Close()
 
@@ -96,25 +168,65 @@ func (bs *BlockchainServer) HandleConnection(conn net.Conn) {
 		message = strings.TrimSpace(message)
 		fmt.Printf("Received: %s\n", message)
 
-		var response string
-
-		// Parse different message types
-		if strings.HasPrefix(message, "TRANSACTION:") {
-			txJSON := strings.TrimPrefix(message, "TRANSACTION:")
-			response = bs.ProcessTransactionMessage(txJSON)
-		} else if strings.HasPrefix(message, "BLOCK:") {
-			blockJSON := strings.TrimPrefix(message, "BLOCK:")
-			response = bs.ProcessBlockMessage(blockJSON)
-		} else {
-			response = "ERROR: Unknown message format. Use TRANSACTION:<json> or BLOCK:<json>"
-		}
+		response := bs.ProcessMessageFrom(conn.RemoteAddr(), message)
 
 		// Send response back to client
 		conn.Write([]byte(response + "\n"))
 	}
 }
 
+// remoteHost returns the host (IP) of a connection's remote address, or ""
+// for local clients
+func remoteHost(remote net.Addr) string {
+	if remote == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return remote.String()
+	}
+	return host
+}
+
+// ProcessMessage dispatches a single protocol message from a local client and
+// returns the response
+func (bs *BlockchainServer) ProcessMessage(message string) string {
+	return bs.ProcessMessageFrom(nil, message)
+}
+
+// ProcessMessageFrom dispatches a message received on a connection from
+// remote. Unlike the From field of the messages, which the sender fills in,
+// the remote address identifies the peer reliably.
+func (bs *BlockchainServer) ProcessMessageFrom(remote net.Addr, message string) string {
+	var response string
+
+	// Parse different message types
+	if strings.HasPrefix(message, "TRANSACTION:") {
+		txJSON := strings.TrimPrefix(message, "TRANSACTION:")
+		response = bs.processTransactionMessage(remote, txJSON)
+	} else if strings.HasPrefix(message, "BLOCK:") {
+		blockJSON := strings.TrimPrefix(message, "BLOCK:")
+		response = bs.processBlockMessage(remote, blockJSON)
+	} else if strings.HasPrefix(message, "GETBLOCK:") {
+		hashHex := strings.TrimPrefix(message, "GETBLOCK:")
+		response = bs.ProcessGetBlockMessage(hashHex)
+	} else if strings.HasPrefix(message, "INV:") {
+		invJSON := strings.TrimPrefix(message, "INV:")
+		response = bs.processInvMessage(remote, invJSON)
+	} else {
+		response = "ERROR: Unknown message format. Use TRANSACTION:<json>, BLOCK:<json>, GETBLOCK:<hash> or INV:<json>"
+	}
+
+	return response
+}
+
 func (bs *BlockchainServer) ProcessTransactionMessage(txJSON string) string {
+	return bs.processTransactionMessage(nil, txJSON)
+}
+
+// processTransactionMessage handles a transaction received from remote (nil
+// for local clients), relaying it to our peers once accepted
+func (bs *BlockchainServer) processTransactionMessage(remote net.Addr, txJSON string) string {
 	// Parse the transaction message (we ignore PublicKeys field now)
 	var txMsg TransactionMessage
 	if err := json.Unmarshal([]byte(txJSON), &txMsg); err != nil {
@@ -123,119 +235,181 @@ func (bs *BlockchainServer) ProcessTransactionMessage(txJSON string) string {
 
 	fmt.Printf("Processing transaction: %s\n", txMsg.Transaction.ID())
 
-	prevMap := bs.buildPrevTxMap()
-
-	if !txMsg.Transaction.Validate(prevMap) {
-		return "ERROR: Transaction validation failed"
-	}
-
+	id := txMsg.Transaction.ID()
 	bs.mu.Lock()
-	bs.pendingTransactions = append(bs.pendingTransactions, txMsg.Transaction)
+	if peer := senderID(remote, txMsg.From); peer != "" {
+		// Don't announce it back to the peer that relayed it
+		bs.peerKnows(peer, id)
+	}
+	fee, err := bs.acceptToMempool(txMsg.Transaction)
+	if missing, ok := err.(*missingParentsError); ok {
+		// Keep it until its parents arrive
+		err = bs.addOrphanTx(txMsg.Transaction, missing.parents)
+		bs.mu.Unlock()
+		if err != nil {
+			return fmt.Sprintf("ERROR: %v", err)
+		}
+		fmt.Printf("🧩 Transaction %s stored as orphan, %v\n", id, missing)
+		return fmt.Sprintf("ORPHAN: Transaction %s stored until its parents arrive", id)
+	}
+	relay := []string{id}
+	if err == nil {
+		relay = append(relay, bs.processOrphanTxs([]string{id})...)
+	}
 	bs.mu.Unlock()
+	if err != nil {
+		return fmt.Sprintf("ERROR: %v", err)
+	}
 
-	fmt.Printf("Transaction added to mempool: %s\n", txMsg.Transaction.ID())
+	fmt.Printf("Transaction added to mempool: %s (fee %d)\n", txMsg.Transaction.ID(), fee)
 
+	go bs.relayTransactions(relay)
 	go bs.startMining()
 
 	return fmt.Sprintf("SUCCESS: Transaction %s added to mempool", txMsg.Transaction.ID())
 }
 
 func (bs *BlockchainServer) ProcessBlockMessage(blockJSON string) string {
+	return bs.processBlockMessage(nil, blockJSON)
+}
+
+func (bs *BlockchainServer) processBlockMessage(remote net.Addr, blockJSON string) string {
 	// Parse block message
 	var blockMsg BlockMessage
 	if err := json.Unmarshal([]byte(blockJSON), &blockMsg); err != nil {
 		return fmt.Sprintf("ERROR: Invalid block message JSON: %v", err)
 	}
 
-	fmt.Printf("Received block with %d transactions\n", len(blockMsg.Block.Transactions))
+	return bs.processBlock(blockMsg, remote)
+}
 
-	// Validate the received block
-	if !bs.validateReceivedBlock(blockMsg.Block) {
-		return "ERROR: Block validation failed"
-	}
+// processBlock validates a block received from remote (nil for local
+// clients), runs fork choice and connects any orphans waiting for it. Blocks
+// whose parent is unknown are kept in the orphan pool while the parent is
+// requested from the sender.
+func (bs *BlockchainServer) processBlock(blockMsg BlockMessage, remote net.Addr) string {
+	block := blockMsg.Block
+	fmt.Printf("Received block with %d transactions\n", len(block.Transactions))
 
-	// Update UTXO set with the new block before adding
-	bs.updateUTXOSetWithBlock(blockMsg.Block)
+	hash, err := block.Hash()
+	if err != nil {
+		return fmt.Sprintf("ERROR: Failed to hash block: %v", err)
+	}
+	hashHex := hex.EncodeToString(hash)
 
-	// Add block to blockchain
 	bs.mu.Lock()
-	bs.blockchain = append(bs.blockchain, blockMsg.Block)
-	bs.mu.Unlock()
+	bs.addTimeSample(remoteHost(remote), blockMsg.Time)
+	if !isGenesisPrev(block.PrevBlock) {
+		prevHex := hex.EncodeToString(block.PrevBlock)
+		if _, ok := bs.blockIndex[prevHex]; !ok && !bs.invalidBlocks[prevHex] {
+			// Only keep orphans that carry real Proof of Work: Bits is chosen
+			// by the sender, so it must be close to the difficulty of our tip
+			if minBits := bs.minOrphanBits(); block.Bits < minBits || !block.isValidHash(hash) {
+				bs.mu.Unlock()
+				fmt.Printf("❌ Orphan block rejected: insufficient Proof of Work\n")
+				return fmt.Sprintf("ERROR: Block validation failed [%s]: orphan Proof of Work below %d bits", RejectHighHash, minBits)
+			}
+			if bs.addOrphan(block, hashHex, blockMsg.From) {
+				go bs.requestBlock(blockMsg.From, bs.orphanRoot(prevHex))
+			}
+			bs.mu.Unlock()
+			fmt.Printf("🧩 Block %s stored as orphan, waiting for parent %s\n", hashHex, prevHex)
+			return fmt.Sprintf("ORPHAN: Block %s stored until parent %s arrives", hashHex, prevHex)
+		}
+	}
 
-	// Save to disk
-	bs.saveBlockchain()
+	// Validate the received block and run fork choice
+	err = bs.acceptBlock(block)
+	if err == nil {
+		bs.processOrphans(hashHex)
+	}
+	bs.mu.Unlock()
+	if err != nil {
+		fmt.Printf("❌ Block rejected: %v\n", err)
+		return fmt.Sprintf("ERROR: Block validation failed [%s]: %v", rejectCodeOf(err), err)
+	}
 
-	hash, _ := blockMsg.Block.Hash()
-	fmt.Printf("✅ Block accepted and added to blockchain! Hash: %x\n", hash)
+	fmt.Printf("✅ Block accepted! Hash: %x\n", hash)
 
 	return fmt.Sprintf("SUCCESS: Block accepted and added to blockchain")
 }
 
-// buildPrevTxMap construye mapa txID → *Tx recorriendo toda la blockchain actual.
-func (bs *BlockchainServer) buildPrevTxMap() map[string]*Tx {
-	prevMap := make(map[string]*Tx)
-	for _, blk := range bs.blockchain {
-		for idx := range blk.Transactions {
-			tx := &blk.Transactions[idx]
-			prevMap[tx.ID()] = tx
-		}
-	}
-	return prevMap
-}
-
-func (bs *BlockchainServer) validateReceivedBlock(block Block) bool {
-	// Check if block connects to our chain
-	bs.mu.Lock()
-	defer bs.mu.Unlock()
-
-	if len(bs.blockchain) > 0 {
-		lastBlockHash, err := bs.blockchain[len(bs.blockchain)-1].Hash()
-		if err != nil {
-			fmt.Printf("Error getting last block hash: %v\n", err)
-			return false
-		}
-
-		if !bytes.Equal(block.PrevBlock, lastBlockHash) {
-			fmt.Printf("Block doesn't connect to our chain\n")
-			return false
-		}
-	} else {
-		// Genesis block: PrevBlock should be all zeros
-		allZeros := make([]byte, 32)
-		if !bytes.Equal(block.PrevBlock, allZeros) {
-			fmt.Printf("Genesis block must have all-zero PrevBlock\n")
-			return false
-		}
-		fmt.Printf("Validating genesis block\n")
+// validateReceivedBlock checks a block header in the context of its parent
+// (nil for a genesis block). The caller must hold bs.mu.
+func (bs *BlockchainServer) validateReceivedBlock(block Block, hash []byte, parent *blockNode) error {
+	// The difficulty must follow the retargeting rule for this height
+	if expected := bs.params.nextBits(parent); block.Bits != expected {
+		return rejectBlock(RejectBadDiffBits, "incorrect difficulty: got %d bits, expected %d", block.Bits, expected)
 	}
 
 	// Validate Proof of Work
-	blockHash, err := block.Hash()
-	if err != nil {
-		fmt.Printf("Error getting block hash: %v\n", err)
-		return false
+	if !block.isValidHash(hash) {
+		return rejectBlock(RejectHighHash, "invalid Proof of Work")
 	}
 
-	if !block.isValidHash(blockHash) {
-		fmt.Printf("Invalid Proof of Work\n")
-		return false
+	// The header must commit to exactly these transactions
+	if !block.HasValidMerkleRoot() {
+		return rejectBlock(RejectBadMerkleRoot, "Merkle root does not match the block's transactions")
+	}
+	if !block.HasValidWitnessRoot() {
+		return rejectBlock(RejectBadWitnessRoot, "witness root does not match the block's unlocking scripts")
 	}
 
-	// Prepare map of previous tx for validation, including chain so far
-	prevMap := bs.buildPrevTxMap()
+	// Timestamp must be after median-time-past and not too far in the future
+	return bs.checkBlockTime(block, parent)
+}
+
+// NextRequiredBits returns the difficulty the next block on top of the active
+// tip must have
+func (bs *BlockchainServer) NextRequiredBits() uint64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.params.nextBits(bs.tip)
+}
 
+// validateBlockTransactions validates the transactions of a block that is
+// about to be connected on top of the active chain, including the coinbase
+// rules. The caller must hold bs.mu.
+func (bs *BlockchainServer) validateBlockTransactions(node *blockNode) error {
+	block := node.block
+
+	// Validate against the UTXO set of the parent. Every transaction is applied
+	// to the view once validated, so later transactions can spend its outputs
+	// but no output can be spent twice within the block.
+	view := bs.chainView(node.parent)
+
+	// The Schnorr signatures of every transaction are checked at the end, all
+	// at once. This cannot happen earlier, in validateReceivedBlock: the
+	// signature hashes commit to the outputs being spent, which are only
+	// known once the parent's UTXO set is.
+	batch := newSigBatch()
+
+	var fees uint64
+	var ok bool
 	for i := 0; i < len(block.Transactions); i++ {
 		tx := &block.Transactions[i]
-		if !tx.Validate(prevMap) {
-			fmt.Printf("Transaction %d validation failed\n", i)
-			return false
+		// The coinbase is checked by checkCoinbase once the fees are known
+		if i > 0 || !tx.IsCoinbase() {
+			fee, err := tx.validate(view, batch)
+			if err != nil {
+				return rejectBlock(RejectBadTxns, "transaction %d: %v", i, err)
+			}
+			if fees, ok = addMoney(fees, fee); !ok {
+				return rejectBlock(RejectBadTxns, "block fees exceed MaxMoney")
+			}
 		}
-		// After validation add tx to map to allow intra-block spending
-		prevMap[tx.ID()] = tx
+		view.ApplyTx(tx)
+	}
+
+	if err := bs.checkCoinbase(block, node.height, fees); err != nil {
+		return err
+	}
+	if err := batch.verify(); err != nil {
+		return rejectBlock(RejectBadTxns, "%v", err)
 	}
 
 	fmt.Printf("Block validation successful\n")
-	return true
+	return nil
 }
 
 func (bs *BlockchainServer) parsePublicKeys(publicKeyData []PublicKeyData) ([]*ecdsa.PublicKey, error) {
@@ -265,72 +439,104 @@ func (bs *BlockchainServer) parsePublicKeys(publicKeyData []PublicKeyData) ([]*e
 	return publicKeys, nil
 }
 
+// BlockTemplate returns the block the miner would work on next, with its
+// coinbase paying to payTo. The nonce is not searched for.
+func (bs *BlockchainServer) BlockTemplate(payTo []byte) Block {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	block, _ := bs.blockTemplate(payTo)
+	return block
+}
+
+// blockTemplate builds a block on top of the active tip with the mempool
+// transactions paying the most per byte, and returns it with the number of
+// transactions taken. The caller must hold bs.mu.
+func (bs *BlockchainServer) blockTemplate(payTo []byte) (Block, int) {
+	prevBlockHash := make([]byte, 32)
+	var height uint64
+	if bs.tip != nil {
+		prevBlockHash = bs.tip.hash
+		height = bs.tip.height + 1
+	}
+	timestamp := uint64(bs.adjustedTime().Unix())
+	if mtp := medianTimePast(bs.tip); timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+
+	// The coinbase claims the subsidy plus the fees of the mined transactions
+	transactions, fees := bs.mempool.selectTransactions(bs.chainView(bs.tip), blockTemplateMaxSize)
+	// (nothing once they are below the dust limit)
+	var outputs []TxOut
+	if reward := bs.params.BlockSubsidy(height) + fees; reward >= DustLimit {
+		outputs = []TxOut{{Amount: reward, LockingScript: payTo}}
+	}
+	coinbase := NewCoinbaseTx(height, outputs)
+
+	return Block{
+		BlockHeader: BlockHeader{
+			Version:   1,
+			PrevBlock: prevBlockHash,
+			Timestamp: timestamp,
+			Nonce:     0,
+			Bits:      bs.params.nextBits(bs.tip), // Difficulty required by the retargeting rule
+		},
+		Transactions: append([]Tx{coinbase}, transactions...),
+	}, len(transactions)
+}
+
 func (bs *BlockchainServer) startMining() {
 	bs.mu.Lock()
-	if bs.isMining || len(bs.pendingTransactions) == 0 {
+	if bs.isMining || bs.mempool.Count() == 0 {
+		bs.mu.Unlock()
+		return
+	}
+	if bs.minerScript == nil {
 		bs.mu.Unlock()
+		fmt.Println("⚠️  No miner wallet configured, not mining")
 		return
 	}
 	bs.isMining = true
-	transactions := make([]Tx, len(bs.pendingTransactions))
-	copy(transactions, bs.pendingTransactions)
-	bs.pendingTransactions = bs.pendingTransactions[:0] // Clear pending transactions
+	// The transactions stay in the mempool until the block is connected
+	block, count := bs.blockTemplate(bs.minerScript)
 	bs.mu.Unlock()
 
-	fmt.Printf("Starting mining with %d transactions...\n", len(transactions))
-
-	// Create new block
-	var prevBlockHash []byte
-	if len(bs.blockchain) > 0 {
-		hash, err := bs.blockchain[len(bs.blockchain)-1].Hash()
-		if err != nil {
-			log.Printf("Error getting previous block hash: %v", err)
-			bs.mu.Lock()
-			bs.isMining = false
-			bs.mu.Unlock()
-			return
-		}
-		prevBlockHash = hash
-	}
-
-	block := Block{
-		Version:      1,
-		PrevBlock:    prevBlockHash,
-		Timestamp:    uint64(time.Now().Unix()),
-		Nonce:        0,
-		Bits:         12, // Difficulty: 4 leading zero bits
-		Transactions: transactions,
+	var reward uint64
+	for _, out := range block.Transactions[0].TxOuts {
+		reward += out.Amount
 	}
+	fmt.Printf("Starting mining with %d transactions (reward %d)...\n", count, reward)
 
 	// Perform Proof of Work
 	start := time.Now()
-	if block.CalculateValidHash() {
-		duration := time.Since(start)
-		hash, _ := block.Hash()
-
-		fmt.Printf("✅ Block mined! Nonce: %d, Time: %v\n", block.Nonce, duration)
-		fmt.Printf("Block hash: %x\n", hash)
-
-		// Add block to blockchain
+	if !block.CalculateValidHash() {
+		fmt.Println("❌ Failed to mine block")
 		bs.mu.Lock()
-		bs.blockchain = append(bs.blockchain, block)
 		bs.isMining = false
 		bs.mu.Unlock()
+		return
+	}
 
-		// Update UTXO set with mined block
-		bs.updateUTXOSetWithBlock(block)
+	duration := time.Since(start)
+	hash, _ := block.Hash()
 
-		// Save to disk
-		bs.saveBlockchain()
+	fmt.Printf("✅ Block mined! Nonce: %d, Time: %v\n", block.Nonce, duration)
+	fmt.Printf("Block hash: %x\n", hash)
 
-		// Broadcast block to peer servers
-		go bs.broadcastBlock(block)
-	} else {
-		fmt.Println("❌ Failed to mine block")
-		bs.mu.Lock()
-		bs.isMining = false
-		bs.mu.Unlock()
+	// Run the mined block through fork choice: the tip may have moved while
+	// we were mining. If our block lost the race its transactions are still
+	// in the mempool.
+	bs.mu.Lock()
+	err := bs.acceptBlock(block)
+	bs.isMining = false
+	bs.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("❌ Mined block rejected: %v\n", err)
+		return
 	}
+
+	// Broadcast block to peer servers
+	go bs.broadcastBlock(block)
 }
 
 // broadcastBlock sends the mined block to all peer servers
@@ -338,6 +544,7 @@ func (bs *BlockchainServer) broadcastBlock(block Block) {
 	bs.mu.Lock()
 	peers := make([]string, len(bs.peerServers))
 	copy(peers, bs.peerServers)
+	from := bs.listenAddr
 	bs.mu.Unlock()
 
 	if len(peers) == 0 {
@@ -349,6 +556,8 @@ func (bs *BlockchainServer) broadcastBlock(block Block) {
 	blockMsg := BlockMessage{
 		Block:      block,
 		PublicKeys: [][]PublicKeyData{}, // TODO: Include public keys for validation
+		From:       from,
+		Time:       time.Now().Unix(),
 	}
 
 	blockJSON, err := json.Marshal(blockMsg)
@@ -380,15 +589,14 @@ func (bs *BlockchainServer) broadcastBlock(block Block) {
 }
 
 func (bs *BlockchainServer) loadBlockchain() {
-	file, err := os.Open(bs.blockchainFile)
+	data, err := os.ReadFile(bs.blockchainFile)
 	if err != nil {
 		fmt.Printf("No existing blockchain found, starting fresh\n")
 		return
 	}
-	defer file.Close()
 
-	var blockchain []Block
-	if err := json.NewDecoder(file).Decode(&blockchain); err != nil {
+	blockchain, err := DecodeBlocks(data)
+	if err != nil {
 		log.Printf("Error loading blockchain: %v", err)
 		return
 	}
@@ -397,18 +605,10 @@ func (bs *BlockchainServer) loadBlockchain() {
 	fmt.Printf("Loaded blockchain with %d blocks\n", len(blockchain))
 }
 
+// saveBlockchain writes the active chain to disk in the binary encoding of
+// codec.go. The caller must hold bs.mu.
 func (bs *BlockchainServer) saveBlockchain() {
-	bs.mu.Lock()
-	defer bs.mu.Unlock()
-
-	file, err := os.Create(bs.blockchainFile)
-	if err != nil {
-		log.Printf("Error creating blockchain file: %v", err)
-		return
-	}
-	defer file.Close()
-
-	if err := json.NewEncoder(file).Encode(bs.blockchain); err != nil {
+	if err := writeFileAtomic(bs.blockchainFile, encodeBlocks(bs.blockchain)); err != nil {
 		log.Printf("Error saving blockchain: %v", err)
 		return
 	}
@@ -416,23 +616,40 @@ func (bs *BlockchainServer) saveBlockchain() {
 	fmt.Printf("💾 Blockchain saved to disk (%d blocks)\n", len(bs.blockchain))
 }
 
-// rebuildUTXOSet reconstruye todo el conjunto UTXO recorriendo la blockchain
+// rebuildUTXOSet reconstruye todo el conjunto UTXO (y los registros de undo)
+// recorriendo la blockchain
 func (bs *BlockchainServer) rebuildUTXOSet() {
 	bs.utxoSet = make(map[string]TxOut)
+	bs.undoData = make(map[string]BlockUndo)
 
-	for _, block := range bs.blockchain {
-		bs.updateUTXOSetWithBlock(block)
+	var nodes []*blockNode
+	for n := bs.tip; n != nil; n = n.parent {
+		nodes = append([]*blockNode{n}, nodes...)
+	}
+	for _, n := range nodes {
+		undo := bs.updateUTXOSetWithBlock(n.block)
+		undo.BlockHash = n.hashHex
+		bs.undoData[n.hashHex] = undo
 	}
 }
 
-// updateUTXOSetWithBlock actualiza el conjunto UTXO al aceptar un bloque
-func (bs *BlockchainServer) updateUTXOSetWithBlock(block Block) {
-	// Remove spent outputs
-	for _, tx := range block.Transactions {
+// updateUTXOSetWithBlock actualiza el conjunto UTXO al aceptar un bloque y
+// devuelve las salidas gastadas para poder deshacerlo (ver DisconnectBlock).
+// No persiste el conjunto: el llamador debe invocar saveUTXOSet.
+func (bs *BlockchainServer) updateUTXOSetWithBlock(block Block) BlockUndo {
+	undo := BlockUndo{Spent: make([][]SpentOutput, len(block.Transactions))}
+
+	for i, tx := range block.Transactions {
+		// Remove spent outputs, remembering what they were
+		spent := make([]SpentOutput, 0, len(tx.TxIns))
 		for _, in := range tx.TxIns {
 			key := fmt.Sprintf("%x:%d", in.PrevTx, in.PrevIndex)
-			delete(bs.utxoSet, key)
+			if out, ok := bs.utxoSet[key]; ok {
+				spent = append(spent, SpentOutput{Key: key, Out: out})
+				delete(bs.utxoSet, key)
+			}
 		}
+		undo.Spent[i] = spent
 
 		// Add new outputs
 		txID := tx.ID()
@@ -442,34 +659,42 @@ func (bs *BlockchainServer) updateUTXOSetWithBlock(block Block) {
 		}
 	}
 
-	// Persist updated set
-	bs.saveUTXOSet()
+	return undo
+}
+
+// GetUTXOSet returns a copy of the current UTXO set
+func (bs *BlockchainServer) GetUTXOSet() map[string]TxOut {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	utxos := make(map[string]TxOut, len(bs.utxoSet))
+	for k, v := range bs.utxoSet {
+		utxos[k] = v
+	}
+	return utxos
 }
 
-// loadUTXOSet loads UTXOs from disk into memory
+// loadUTXOSet loads UTXOs from disk into memory. A set saved for another tip
+// than that of the loaded chain is refused, so it gets rebuilt.
 func (bs *BlockchainServer) loadUTXOSet() error {
 	data, err := os.ReadFile(utxoFile)
 	if err != nil {
 		return err
 	}
-	var m map[string]TxOut
-	if err := json.Unmarshal(data, &m); err != nil {
+	m, tipHash, err := DecodeUTXOSet(data)
+	if err != nil {
 		return err
 	}
+	if tipHash != bs.tipHash() {
+		return fmt.Errorf("UTXO set is for block %q, not the chain tip %q", tipHash, bs.tipHash())
+	}
 	bs.utxoSet = m
 	fmt.Printf("🔄 UTXO set loaded (%d entries)\n", len(m))
 	return nil
 }
 
+// saveUTXOSet persists the UTXO set. The caller must hold bs.mu.
 func (bs *BlockchainServer) saveUTXOSet() {
-	bs.mu.Lock()
-	defer bs.mu.Unlock()
-	data, err := json.MarshalIndent(bs.utxoSet, "", "  ")
-	if err != nil {
-		log.Printf("Error marshaling UTXO set: %v", err)
-		return
-	}
-	if err := os.WriteFile(utxoFile, data, 0644); err != nil {
+	if err := writeFileAtomic(utxoFile, encodeUTXOSet(bs.tipHash(), bs.utxoSet)); err != nil {
 		log.Printf("Error writing UTXO set: %v", err)
 		return
 	}
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// blockNode is an entry of the block index. Every block we have validated the
+// header of is kept here, whether it belongs to the main chain or to a side
+// branch, so that competing forks can be compared by cumulative work.
+type blockNode struct {
+	block     Block
+	hash      []byte
+	hashHex   string
+	parent    *blockNode
+	height    uint64
+	chainWork *big.Int // Sum of the work of every block from genesis up to this one
+}
+
+func newBlockNode(block Block, hash []byte, parent *blockNode) *blockNode {
+	node := &blockNode{
+		block:     block,
+		hash:      hash,
+		hashHex:   hex.EncodeToString(hash),
+		parent:    parent,
+		chainWork: block.Work(),
+	}
+	if parent != nil {
+		node.height = parent.height + 1
+		node.chainWork.Add(node.chainWork, parent.chainWork)
+	}
+	return node
+}
+
+// findFork returns the last block shared by the branches ending at a and b
+func findFork(a, b *blockNode) *blockNode {
+	for a != nil && b != nil && a != b {
+		if a.height > b.height {
+			a = a.parent
+		} else if b.height > a.height {
+			b = b.parent
+		} else {
+			a = a.parent
+			b = b.parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+	return a
+}
+
+// isGenesisPrev reports whether prev is the all-zero PrevBlock of a genesis block
+func isGenesisPrev(prev []byte) bool {
+	return bytes.Equal(prev, make([]byte, 32))
+}
+
+// acceptBlock runs fork choice for a block received from a peer or produced by
+// our own miner. The caller must hold bs.mu.
+//
+// Blocks extending the active tip are connected directly. Blocks on a side
+// branch are stored in the index and, if their branch accumulates more work
+// than the active chain, the node reorganizes onto it.
+func (bs *BlockchainServer) acceptBlock(block Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return rejectBlock(RejectInternal, "error getting block hash: %v", err)
+	}
+	hashHex := hex.EncodeToString(hash)
+
+	if _, known := bs.blockIndex[hashHex]; known {
+		return rejectBlock(RejectDuplicate, "block %s already known", hashHex)
+	}
+	if bs.invalidBlocks[hashHex] {
+		return rejectBlock(RejectInvalidChain, "block %s was previously marked invalid", hashHex)
+	}
+
+	var parent *blockNode
+	if isGenesisPrev(block.PrevBlock) {
+		if bs.tip != nil {
+			return rejectBlock(RejectBadGenesis, "genesis block already exists")
+		}
+		fmt.Printf("Validating genesis block\n")
+	} else {
+		prevHex := hex.EncodeToString(block.PrevBlock)
+		if bs.invalidBlocks[prevHex] {
+			bs.invalidBlocks[hashHex] = true
+			return rejectBlock(RejectInvalidChain, "block builds on invalid block %s", prevHex)
+		}
+		parent = bs.blockIndex[prevHex]
+		if parent == nil {
+			return rejectBlock(RejectBadPrevBlock, "block doesn't connect to any known block (parent %s)", prevHex)
+		}
+	}
+
+	if err := bs.validateReceivedBlock(block, hash, parent); err != nil {
+		// A block from the future may become valid later, and a body that does
+		// not match the header may be a mutated copy of a valid block with the
+		// same hash: don't blacklist them
+		switch rejectCodeOf(err) {
+		case RejectTimeTooNew, RejectBadMerkleRoot, RejectBadWitnessRoot:
+		default:
+			bs.invalidBlocks[hashHex] = true
+		}
+		return err
+	}
+
+	node := newBlockNode(block, hash, parent)
+
+	// Case 1: the block extends the active chain
+	if parent == bs.tip {
+		if err := bs.validateBlockTransactions(node); err != nil {
+			bs.invalidBlocks[hashHex] = true
+			return err
+		}
+		bs.blockIndex[hashHex] = node
+		bs.connectBlock(node)
+		bs.removeForBlock(block)
+		go bs.relayTransactions(bs.processOrphanTxs(blockTxIDs(block)))
+		bs.saveBlockchain()
+		bs.saveUTXOSet()
+		bs.saveUndoData()
+		return nil
+	}
+
+	bs.blockIndex[hashHex] = node
+
+	// Case 2: side branch that does not (yet) have more work than the active chain
+	if node.chainWork.Cmp(bs.tip.chainWork) <= 0 {
+		fmt.Printf("🌿 Block stored on side branch (height %d, work %s vs active %s)\n",
+			node.height, node.chainWork, bs.tip.chainWork)
+		return nil
+	}
+
+	// Case 3: the side branch is now the heaviest one
+	return bs.reorganize(node)
+}
+
+// reorganize switches the active chain to the branch ending at newTip.
+// The caller must hold bs.mu. If any block of the new branch turns out to be
+// invalid the old chain is restored and the offending blocks are discarded.
+func (bs *BlockchainServer) reorganize(newTip *blockNode) error {
+	oldTip := bs.tip
+	fork := findFork(oldTip, newTip)
+	if fork == nil {
+		return rejectBlock(RejectInternal, "no common ancestor between active chain and new branch")
+	}
+
+	// Blocks to connect, from the fork point towards the new tip
+	var attach []*blockNode
+	for n := newTip; n != fork; n = n.parent {
+		attach = append([]*blockNode{n}, attach...)
+	}
+	// Blocks to disconnect, from the old tip towards the fork point
+	var detach []*blockNode
+	for n := oldTip; n != fork; n = n.parent {
+		detach = append(detach, n)
+	}
+
+	fmt.Printf("🔀 Reorganizing: disconnecting %d block(s), connecting %d block(s) (fork at height %d)\n",
+		len(detach), len(attach), fork.height)
+
+	for range detach {
+		if _, err := bs.disconnectTip(); err != nil {
+			return rejectBlock(RejectInternal, "reorganization failed while disconnecting: %v", err)
+		}
+	}
+
+	for i, n := range attach {
+		if err := bs.validateBlockTransactions(n); err != nil {
+			// Discard the invalid block and everything built on top of it
+			for _, bad := range attach[i:] {
+				bs.invalidBlocks[bad.hashHex] = true
+				delete(bs.blockIndex, bad.hashHex)
+			}
+			// Restore the previous active chain
+			for bs.tip != fork {
+				if _, err := bs.disconnectTip(); err != nil {
+					return rejectBlock(RejectInternal, "reorganization failed while restoring the active chain: %v", err)
+				}
+			}
+			for j := len(detach) - 1; j >= 0; j-- {
+				bs.connectBlock(detach[j])
+			}
+			return rejectBlock(rejectCodeOf(err), "reorganization aborted, block at height %d invalid: %v", n.height, err)
+		}
+		bs.connectBlock(n)
+	}
+
+	// Return transactions of the disconnected blocks to the pending pool
+	for i := len(detach) - 1; i >= 0; i-- {
+		bs.returnToPending(detach[i].block)
+	}
+	for _, n := range attach {
+		bs.removeConfirmedFromPending(n.block)
+	}
+	bs.revalidatePending()
+	for _, n := range attach {
+		go bs.relayTransactions(bs.processOrphanTxs(blockTxIDs(n.block)))
+	}
+
+	bs.saveBlockchain()
+	bs.saveUTXOSet()
+	bs.saveUndoData()
+
+	fmt.Printf("✅ Reorganization complete. New tip %s at height %d\n", newTip.hashHex, newTip.height)
+	return nil
+}
+
+// connectBlock appends an already validated block to the active chain and
+// records its undo data. The caller must hold bs.mu.
+func (bs *BlockchainServer) connectBlock(node *blockNode) {
+	bs.blockchain = append(bs.blockchain, node.block)
+	bs.tip = node
+	undo := bs.updateUTXOSetWithBlock(node.block)
+	undo.BlockHash = node.hashHex
+	bs.undoData[node.hashHex] = undo
+	bs.indexTransactions(node)
+}
+
+// blockTxIDs returns the IDs of the transactions of block
+func blockTxIDs(block Block) []string {
+	ids := make([]string, len(block.Transactions))
+	for i := range block.Transactions {
+		ids[i] = block.Transactions[i].ID()
+	}
+	return ids
+}
+
+// indexTransactions records the height of the transactions of a block of the
+// active chain, needed to check relative timelocks
+func (bs *BlockchainServer) indexTransactions(node *blockNode) {
+	for i := range node.block.Transactions {
+		bs.txHeights[node.block.Transactions[i].ID()] = node.height
+	}
+}
+
+// loadBlockIndex builds the block index from the chain loaded from disk
+func (bs *BlockchainServer) loadBlockIndex() {
+	var parent *blockNode
+	for _, block := range bs.blockchain {
+		hash, err := block.Hash()
+		if err != nil {
+			fmt.Printf("Error hashing stored block: %v\n", err)
+			return
+		}
+		node := newBlockNode(block, hash, parent)
+		bs.blockIndex[node.hashHex] = node
+		bs.indexTransactions(node)
+		parent = node
+	}
+	bs.tip = parent
+}
+
+// BestBlockHash returns the hash (hex) of the tip of the active chain, or an
+// empty string if the node has no blocks yet.
+func (bs *BlockchainServer) BestBlockHash() string {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.tipHash()
+}
+
+// tipHash returns the hash of the active tip, "" without blocks. The caller
+// must hold bs.mu.
+func (bs *BlockchainServer) tipHash() string {
+	if bs.tip == nil {
+		return ""
+	}
+	return bs.tip.hashHex
+}
+
+// ChainHeight returns the number of blocks of the active chain
+func (bs *BlockchainServer) ChainHeight() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.blockchain)
+}
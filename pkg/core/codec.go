@@ -0,0 +1,650 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CodecVersion is the version of the binary encoding, written first in every
+// encoded object so the format can change without misreading old data
+const CodecVersion = 1
+
+// The canonical encoding of the chain objects. Integers are unsigned LEB128
+// varints with no redundant bytes, byte strings and lists are prefixed with
+// their length, and fields are written in declaration order:
+//
+//	TxOut       amount | locking_script
+//	TxIn        prev_tx | prev_index | unlocking_script | net | sequence | key_type | sig_type
+//	Tx          version | txins | txouts | lock_time
+//	BlockHeader version | prev_block | merkle_root | witness_root | timestamp | bits | nonce
+//	Block       header | transactions
+//
+// The files of the server use it too:
+//
+//	blockchain.dat  count | blocks
+//	utxos.dat       tip_hash | count | (key | txout)...  sorted by key
+//	undo.dat        count | (block_hash | count | (count | (key | txout)...)...)...  sorted by hash
+//	mempool.dat     count | (tx | arrival_time)...
+//
+// The header is the exception: it is what gets hashed and mined, so it has a
+// fixed width of BlockHeaderSize bytes. Its integers take 8 bytes, little
+// endian, and its hashes 32 bytes, zero-padded.
+//
+// A top-level object is preceded by CodecVersion. Every value has exactly one
+// encoding: decoding rejects non-minimal varints and trailing bytes, so equal
+// encodings mean equal objects and hashing the encoding is safe.
+//
+// The encoding without witness leaves out the unlocking scripts of the
+// inputs. The transaction ID is computed from it, so changing a signature
+// cannot change the ID (see Tx.ID and Tx.WitnessHash).
+
+var (
+	errTruncated  = errors.New("codec: unexpected end of data")
+	errNonMinimal = errors.New("codec: non-minimal varint")
+	errOverflow   = errors.New("codec: varint overflows uint64")
+)
+
+// encoder appends the canonical encoding of values to buf
+type encoder struct {
+	buf []byte
+}
+
+// newEncoder returns an encoder for a top-level object, with the codec version
+// already written
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.uvarint(CodecVersion)
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// uint64 writes v in 8 bytes, little endian
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+// hash writes a 32 byte hash, zero-padded
+func (e *encoder) hash(h []byte) {
+	var fixed [32]byte
+	copy(fixed[:], h)
+	e.buf = append(e.buf, fixed[:]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads values written by encoder. The first error is kept and every
+// later read returns a zero value, so callers check err once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	switch {
+	case n == 0:
+		d.err = errTruncated
+		return 0
+	case n < 0:
+		d.err = errOverflow
+		return 0
+	case n > 1 && d.data[n-1] == 0:
+		// A trailing zero group adds nothing to the value
+		d.err = errNonMinimal
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	v := d.uvarint()
+	if v > math.MaxUint32 {
+		d.fail(fmt.Errorf("codec: value %d overflows uint32", v))
+		return 0
+	}
+	return uint32(v)
+}
+
+func (d *decoder) uint8() uint8 {
+	v := d.uvarint()
+	if v > math.MaxUint8 {
+		d.fail(fmt.Errorf("codec: value %d overflows uint8", v))
+		return 0
+	}
+	return uint8(v)
+}
+
+// count reads the length of a list or byte string. Each element takes at least
+// one byte, so a length longer than the remaining data is rejected before
+// anything is allocated for it.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)) {
+		d.fail(fmt.Errorf("codec: length %d exceeds the %d bytes left", n, len(d.data)))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+// fixed reads the next n bytes
+func (d *decoder) fixed(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = errTruncated
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.fixed(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *decoder) hash() []byte {
+	return d.fixed(32)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// encodeObject returns the encoding of a top-level object
+func encodeObject(encode func(*encoder)) []byte {
+	e := newEncoder()
+	encode(e)
+	return e.buf
+}
+
+// decodeObject decodes a top-level object, checking its codec version and
+// that nothing follows it
+func decodeObject(data []byte, decode func(*decoder)) error {
+	d := &decoder{data: data}
+	if v := d.uvarint(); d.err == nil && v != CodecVersion {
+		return fmt.Errorf("codec: unsupported version %d", v)
+	}
+	decode(d)
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) != 0 {
+		return fmt.Errorf("codec: %d trailing bytes", len(d.data))
+	}
+	return nil
+}
+
+// decodeHex decodes the text form of an object: its encoding in hex
+func decodeHex(text []byte, decode func(*decoder)) error {
+	data := make([]byte, hex.DecodedLen(len(text)))
+	if _, err := hex.Decode(data, text); err != nil {
+		return fmt.Errorf("codec: %v", err)
+	}
+	return decodeObject(data, decode)
+}
+
+// ------------------------------------------------------
+// TxOut
+
+func (out *TxOut) encode(e *encoder) {
+	e.uvarint(out.Amount)
+	e.bytes(out.LockingScript)
+}
+
+func (out *TxOut) decode(d *decoder) {
+	out.Amount = d.uvarint()
+	out.LockingScript = d.bytes()
+}
+
+// MarshalBinary returns the canonical encoding of the output
+func (out TxOut) MarshalBinary() ([]byte, error) {
+	return encodeObject(out.encode), nil
+}
+
+// UnmarshalBinary decodes an output written by MarshalBinary
+func (out *TxOut) UnmarshalBinary(data []byte) error {
+	var o TxOut
+	if err := decodeObject(data, o.decode); err != nil {
+		return err
+	}
+	*out = o
+	return nil
+}
+
+// MarshalText encodes the output as hex, so JSON files and messages carry
+// the canonical encoding
+func (out TxOut) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(encodeObject(out.encode))), nil
+}
+
+// UnmarshalText decodes an output written by MarshalText
+func (out *TxOut) UnmarshalText(text []byte) error {
+	var o TxOut
+	if err := decodeHex(text, o.decode); err != nil {
+		return err
+	}
+	*out = o
+	return nil
+}
+
+// ------------------------------------------------------
+// TxIn
+
+func (in *TxIn) encode(e *encoder, witness bool) {
+	e.bytes(in.PrevTx)
+	e.uvarint(uint64(in.PrevIndex))
+	if witness {
+		e.bytes(in.UnlockingScript)
+	}
+	e.string(in.Net)
+	e.uvarint(uint64(in.Sequence))
+	e.uvarint(uint64(in.KeyType))
+	e.uvarint(uint64(in.SigType))
+}
+
+func (in *TxIn) decode(d *decoder) {
+	in.PrevTx = d.bytes()
+	in.PrevIndex = d.uint32()
+	in.UnlockingScript = d.bytes()
+	in.Net = d.string()
+	in.Sequence = d.uint32()
+	in.KeyType = KeyType(d.uint8())
+	in.SigType = SigType(d.uint8())
+}
+
+// MarshalBinary returns the canonical encoding of the input
+func (in TxIn) MarshalBinary() ([]byte, error) {
+	return encodeObject(func(e *encoder) { in.encode(e, true) }), nil
+}
+
+// UnmarshalBinary decodes an input written by MarshalBinary
+func (in *TxIn) UnmarshalBinary(data []byte) error {
+	var i TxIn
+	if err := decodeObject(data, i.decode); err != nil {
+		return err
+	}
+	*in = i
+	return nil
+}
+
+// ------------------------------------------------------
+// Tx
+
+func (tx *Tx) encode(e *encoder) {
+	tx.encodeTx(e, true)
+}
+
+func (tx *Tx) encodeWithoutWitness(e *encoder) {
+	tx.encodeTx(e, false)
+}
+
+func (tx *Tx) encodeTx(e *encoder, witness bool) {
+	e.uvarint(uint64(tx.Version))
+	e.uvarint(uint64(len(tx.TxIns)))
+	for i := range tx.TxIns {
+		tx.TxIns[i].encode(e, witness)
+	}
+	e.uvarint(uint64(len(tx.TxOuts)))
+	for i := range tx.TxOuts {
+		tx.TxOuts[i].encode(e)
+	}
+	e.uvarint(uint64(tx.LockTime))
+}
+
+func (tx *Tx) decode(d *decoder) {
+	tx.Version = d.uint32()
+	if n := d.count(); n > 0 {
+		tx.TxIns = make([]TxIn, n)
+		for i := range tx.TxIns {
+			tx.TxIns[i].decode(d)
+		}
+	}
+	if n := d.count(); n > 0 {
+		tx.TxOuts = make([]TxOut, n)
+		for i := range tx.TxOuts {
+			tx.TxOuts[i].decode(d)
+		}
+	}
+	tx.LockTime = d.uint32()
+}
+
+// Serialize returns the canonical encoding of the transaction, the data its
+// witness hash is computed from
+func (tx *Tx) Serialize() []byte {
+	return encodeObject(tx.encode)
+}
+
+// SerializeWithoutWitness returns the encoding of the transaction without
+// its unlocking scripts, the data its ID is computed from
+func (tx *Tx) SerializeWithoutWitness() []byte {
+	return encodeObject(tx.encodeWithoutWitness)
+}
+
+// MarshalBinary returns the canonical encoding of the transaction
+func (tx Tx) MarshalBinary() ([]byte, error) {
+	return tx.Serialize(), nil
+}
+
+// UnmarshalBinary decodes a transaction written by MarshalBinary
+func (tx *Tx) UnmarshalBinary(data []byte) error {
+	var t Tx
+	if err := decodeObject(data, t.decode); err != nil {
+		return err
+	}
+	*tx = t
+	return nil
+}
+
+// MarshalText encodes the transaction as hex, so JSON files and messages
+// carry the canonical encoding
+func (tx Tx) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(tx.Serialize())), nil
+}
+
+// UnmarshalText decodes a transaction written by MarshalText
+func (tx *Tx) UnmarshalText(text []byte) error {
+	var t Tx
+	if err := decodeHex(text, t.decode); err != nil {
+		return err
+	}
+	*tx = t
+	return nil
+}
+
+// ------------------------------------------------------
+// BlockHeader
+
+// The nonce goes last, so a miner can encode the rest of the header once and
+// only rewrite its final 8 bytes (see CalculateValidHash)
+func (h *BlockHeader) encode(e *encoder) {
+	e.uint64(h.Version)
+	e.hash(h.PrevBlock)
+	e.hash(h.MerkleRoot)
+	e.hash(h.WitnessRoot)
+	e.uint64(h.Timestamp)
+	e.uint64(h.Bits)
+	e.uint64(h.Nonce)
+}
+
+func (h *BlockHeader) decode(d *decoder) {
+	h.Version = d.uint64()
+	h.PrevBlock = d.hash()
+	h.MerkleRoot = d.hash()
+	h.WitnessRoot = d.hash()
+	h.Timestamp = d.uint64()
+	h.Bits = d.uint64()
+	h.Nonce = d.uint64()
+}
+
+// MarshalBinary returns the canonical encoding of the header
+func (h BlockHeader) MarshalBinary() ([]byte, error) {
+	return h.Serialize(), nil
+}
+
+// UnmarshalBinary decodes a header written by MarshalBinary
+func (h *BlockHeader) UnmarshalBinary(data []byte) error {
+	var hdr BlockHeader
+	if err := decodeObject(data, hdr.decode); err != nil {
+		return err
+	}
+	*h = hdr
+	return nil
+}
+
+// ------------------------------------------------------
+// Block
+
+func (b *Block) encode(e *encoder) {
+	b.BlockHeader.encode(e)
+	e.uvarint(uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		b.Transactions[i].encode(e)
+	}
+}
+
+func (b *Block) decode(d *decoder) {
+	b.BlockHeader.decode(d)
+	b.Transactions = make([]Tx, d.count())
+	for i := range b.Transactions {
+		b.Transactions[i].decode(d)
+	}
+}
+
+// Serialize returns the canonical encoding of the whole block. The block ID
+// only covers the header (see BlockHeader.Serialize).
+func (b *Block) Serialize() []byte {
+	return encodeObject(b.encode)
+}
+
+// MarshalBinary returns the canonical encoding of the block
+func (b Block) MarshalBinary() ([]byte, error) {
+	return b.Serialize(), nil
+}
+
+// UnmarshalBinary decodes a block written by MarshalBinary
+func (b *Block) UnmarshalBinary(data []byte) error {
+	var blk Block
+	if err := decodeObject(data, blk.decode); err != nil {
+		return err
+	}
+	*b = blk
+	return nil
+}
+
+// MarshalText encodes the block as hex, so messages between nodes carry the
+// canonical encoding
+func (b Block) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b.Serialize())), nil
+}
+
+// UnmarshalText decodes a block written by MarshalText
+func (b *Block) UnmarshalText(text []byte) error {
+	var blk Block
+	if err := decodeHex(text, blk.decode); err != nil {
+		return err
+	}
+	*b = blk
+	return nil
+}
+
+// ------------------------------------------------------
+// Chain files
+
+// encodeBlocks returns the encoding of a chain: its length and its blocks
+func encodeBlocks(blocks []Block) []byte {
+	return encodeObject(func(e *encoder) {
+		e.uvarint(uint64(len(blocks)))
+		for i := range blocks {
+			blocks[i].encode(e)
+		}
+	})
+}
+
+// DecodeBlocks decodes a chain written by the server to its blockchain file
+func DecodeBlocks(data []byte) ([]Block, error) {
+	var blocks []Block
+	err := decodeObject(data, func(d *decoder) {
+		blocks = make([]Block, d.count())
+		for i := range blocks {
+			blocks[i].decode(d)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// encodeUTXOSet returns the encoding of the UTXO set after the block tipHash:
+// the hash, the number of outputs and each "txid:index" key with its output,
+// sorted by key
+func encodeUTXOSet(tipHash string, utxos map[string]TxOut) []byte {
+	keys := make([]string, 0, len(utxos))
+	for k := range utxos {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return encodeObject(func(e *encoder) {
+		e.string(tipHash)
+		e.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			out := utxos[k]
+			e.string(k)
+			out.encode(e)
+		}
+	})
+}
+
+// DecodeUTXOSet decodes the UTXO set written by the server to its UTXO file.
+// It also returns the hash of the block it is the state after.
+func DecodeUTXOSet(data []byte) (map[string]TxOut, string, error) {
+	var tipHash string
+	var utxos map[string]TxOut
+	err := decodeObject(data, func(d *decoder) {
+		tipHash = d.string()
+		n := d.count()
+		utxos = make(map[string]TxOut, n)
+		prev := ""
+		for i := 0; i < n && d.err == nil; i++ {
+			k := d.string()
+			if i > 0 && k <= prev {
+				d.fail(fmt.Errorf("codec: UTXO keys not in increasing order"))
+			}
+			var out TxOut
+			out.decode(d)
+			utxos[k] = out
+			prev = k
+		}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return utxos, tipHash, nil
+}
+
+// encodeUndoData returns the encoding of the undo records: their number and,
+// sorted by block hash, each record's hash and the outputs spent by each
+// transaction of the block
+func encodeUndoData(undo map[string]BlockUndo) []byte {
+	hashes := make([]string, 0, len(undo))
+	for h := range undo {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return encodeObject(func(e *encoder) {
+		e.uvarint(uint64(len(hashes)))
+		for _, h := range hashes {
+			u := undo[h]
+			e.string(h)
+			e.uvarint(uint64(len(u.Spent)))
+			for _, spent := range u.Spent {
+				e.uvarint(uint64(len(spent)))
+				for i := range spent {
+					e.string(spent[i].Key)
+					spent[i].Out.encode(e)
+				}
+			}
+		}
+	})
+}
+
+// decodeUndoData decodes undo records written by encodeUndoData
+func decodeUndoData(data []byte) (map[string]BlockUndo, error) {
+	var undo map[string]BlockUndo
+	err := decodeObject(data, func(d *decoder) {
+		n := d.count()
+		undo = make(map[string]BlockUndo, n)
+		prev := ""
+		for i := 0; i < n && d.err == nil; i++ {
+			u := BlockUndo{BlockHash: d.string()}
+			if i > 0 && u.BlockHash <= prev {
+				d.fail(fmt.Errorf("codec: undo records not in increasing order"))
+			}
+			u.Spent = make([][]SpentOutput, d.count())
+			for j := range u.Spent {
+				u.Spent[j] = make([]SpentOutput, d.count())
+				for k := range u.Spent[j] {
+					u.Spent[j][k].Key = d.string()
+					u.Spent[j][k].Out.decode(d)
+				}
+			}
+			undo[u.BlockHash] = u
+			prev = u.BlockHash
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
+
+// encodeMempool returns the encoding of saved mempool transactions: their
+// number and each transaction followed by the Unix time it arrived
+func encodeMempool(records []mempoolRecord) []byte {
+	return encodeObject(func(e *encoder) {
+		e.uvarint(uint64(len(records)))
+		for i := range records {
+			records[i].Tx.encode(e)
+			e.uvarint(uint64(records[i].Added))
+		}
+	})
+}
+
+// decodeMempool decodes transactions written by encodeMempool
+func decodeMempool(data []byte) ([]mempoolRecord, error) {
+	var records []mempoolRecord
+	err := decodeObject(data, func(d *decoder) {
+		records = make([]mempoolRecord, d.count())
+		for i := range records {
+			records[i].Tx.decode(d)
+			records[i].Added = int64(d.uvarint())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A coinbase transaction has a single input that spends nothing: PrevTx is 32
+// zero bytes and PrevIndex carries the height of the block, which makes the
+// ID of every coinbase unique. It must be the first transaction of a block and
+// may pay at most the block subsidy plus the fees of the other transactions,
+// in outputs of at least DustLimit; it has none if there is less to claim.
+
+// NewCoinbaseTx creates the coinbase transaction for a block at height
+func NewCoinbaseTx(height uint64, outputs []TxOut) Tx {
+	return Tx{
+		Version: 1,
+		TxIns: []TxIn{{
+			PrevTx:          make([]byte, 32),
+			PrevIndex:       uint32(height),
+			UnlockingScript: []byte{},
+			Net:             "mainnet",
+		}},
+		TxOuts: outputs,
+	}
+}
+
+// IsCoinbase reports whether tx is a coinbase transaction
+func (tx *Tx) IsCoinbase() bool {
+	return len(tx.TxIns) == 1 && bytes.Equal(tx.TxIns[0].PrevTx, make([]byte, 32))
+}
+
+// CoinbaseHeight returns the block height committed to by a coinbase
+func (tx *Tx) CoinbaseHeight() uint64 {
+	return uint64(tx.TxIns[0].PrevIndex)
+}
+
+// BlockSubsidy returns the newly created coins a miner may claim at height.
+// The subsidy halves every HalvingInterval blocks until it reaches zero.
+func (p ChainParams) BlockSubsidy(height uint64) uint64 {
+	if p.HalvingInterval == 0 {
+		return p.InitialSubsidy
+	}
+	halvings := height / p.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return p.InitialSubsidy >> halvings
+}
+
+// checkCoinbase verifies the coinbase rules of a block at height whose
+// non-coinbase transactions pay fees in total
+func (bs *BlockchainServer) checkCoinbase(block Block, height uint64, fees uint64) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return rejectBlock(RejectBadCoinbase, "first transaction is not a coinbase")
+	}
+	for i := 1; i < len(block.Transactions); i++ {
+		if block.Transactions[i].IsCoinbase() {
+			return rejectBlock(RejectBadCoinbase, "more than one coinbase (transaction %d)", i)
+		}
+	}
+
+	coinbase := &block.Transactions[0]
+	if coinbase.CoinbaseHeight() != height {
+		return rejectBlock(RejectBadCoinbase, "coinbase commits to height %d, block is at %d", coinbase.CoinbaseHeight(), height)
+	}
+
+	// The genesis coinbase distributes the initial supply and is not capped
+	if height == 0 {
+		var paid uint64
+		for i, out := range coinbase.TxOuts {
+			var ok bool
+			if paid, ok = addMoney(paid, out.Amount); !ok {
+				return rejectBlock(RejectBadCoinbase, "coinbase output %d exceeds MaxMoney", i)
+			}
+		}
+		return nil
+	}
+
+	// The outputs follow the rules of any transaction, so the miner cannot
+	// create dust. With less than DustLimit to claim there is nothing to pay.
+	limit := bs.params.BlockSubsidy(height) + fees
+	if len(coinbase.TxOuts) == 0 && limit < DustLimit {
+		return nil
+	}
+	paid, err := coinbase.checkOutputs()
+	if err != nil {
+		return rejectBlock(RejectBadCoinbase, "coinbase: %v", err)
+	}
+	if paid > limit {
+		return rejectBlock(RejectBadCoinbase, "coinbase pays %d, more than subsidy + fees (%d)", paid, limit)
+	}
+	return nil
+}
+
+// SetMinerWallet sets the wallet that receives the coinbase of the blocks we
+// mine. Without one the node does not mine.
+func (bs *BlockchainServer) SetMinerWallet(w *Wallet) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.minerScript = w.GetLockingScript()
+	fmt.Printf("⛏️  Mining rewards will be paid to %s\n", w.GetAddress())
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HTLCSecretSize is the size of the secret that unlocks a hash time-locked
+// contract. Fixing it keeps a swap from stalling on a secret that is too big
+// to be revealed on the other chain.
+const HTLCSecretSize = 32
+
+// HTLC is a hash time-locked contract: an output the receiver can spend by
+// revealing a secret whose SHA3-256 hash is SecretHash, or the sender can take
+// back once LockTime has passed. It is paid through P2SH, and its redeem script
+// is:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA3 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_SHA3 <receiverPubKeyHash>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_SHA3 <senderPubKeyHash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+type HTLC struct {
+	SecretHash         []byte `json:"secret_hash"`
+	ReceiverPubKeyHash []byte `json:"receiver_pub_key_hash"`
+	SenderPubKeyHash   []byte `json:"sender_pub_key_hash"`
+	LockTime           uint32 `json:"lock_time"` // Height or time after which the sender can refund
+	RedeemScript       []byte `json:"redeem_script"`
+}
+
+// NewHTLCSecret returns a random secret for a new contract
+func NewHTLCSecret() ([]byte, error) {
+	secret := make([]byte, HTLCSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+	return secret, nil
+}
+
+// NewHTLC builds the contract paying to receiverPubKeyHash against the secret
+// of secretHash, refundable to senderPubKeyHash after lockTime
+func NewHTLC(secretHash, receiverPubKeyHash, senderPubKeyHash []byte, lockTime uint32) (*HTLC, error) {
+	for name, h := range map[string][]byte{"secret": secretHash, "receiver": receiverPubKeyHash, "sender": senderPubKeyHash} {
+		if len(h) != 32 {
+			return nil, fmt.Errorf("%s hash must be 32 bytes, got %d", name, len(h))
+		}
+	}
+	if lockTime == 0 {
+		return nil, errors.New("HTLC needs a lock time for the refund")
+	}
+	redeem := NewScriptBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).AddInt64(HTLCSecretSize).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA3).AddData(secretHash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_SHA3).AddData(receiverPubKeyHash).
+		AddOp(OP_ELSE).
+		AddInt64(int64(lockTime)).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_SHA3).AddData(senderPubKeyHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+	return &HTLC{
+		SecretHash:         secretHash,
+		ReceiverPubKeyHash: receiverPubKeyHash,
+		SenderPubKeyHash:   senderPubKeyHash,
+		LockTime:           lockTime,
+		RedeemScript:       redeem,
+	}, nil
+}
+
+// LockingScript returns the P2SH locking script paying to the contract
+func (h *HTLC) LockingScript() []byte {
+	return PayToScriptHashScript(HashSHA3(h.RedeemScript))
+}
+
+// RedeemHTLC spends the contract output stored under utxoKey ("txid:index")
+// to this wallet by revealing secret. fee is left to the miner.
+func (w *Wallet) RedeemHTLC(h *HTLC, utxoKey string, prevOut TxOut, secret []byte, fee uint64) (Tx, error) {
+	if !bytes.Equal(HashSHA3(w.PublicKey), h.ReceiverPubKeyHash) {
+		return Tx{}, errors.New("wallet is not the receiver of the contract")
+	}
+	if len(secret) != HTLCSecretSize || !bytes.Equal(HashSHA3(secret), h.SecretHash) {
+		return Tx{}, errors.New("secret does not match the contract hash")
+	}
+	return w.spendHTLC(h, utxoKey, prevOut, fee, 0, func(b *ScriptBuilder) {
+		b.AddData(secret).AddOp(OP_1)
+	})
+}
+
+// RefundHTLC returns the contract output stored under utxoKey to the sender.
+// The transaction carries the contract lock time, so it can only be mined
+// once the contract has expired.
+func (w *Wallet) RefundHTLC(h *HTLC, utxoKey string, prevOut TxOut, fee uint64) (Tx, error) {
+	if !bytes.Equal(HashSHA3(w.PublicKey), h.SenderPubKeyHash) {
+		return Tx{}, errors.New("wallet is not the sender of the contract")
+	}
+	return w.spendHTLC(h, utxoKey, prevOut, fee, h.LockTime, func(b *ScriptBuilder) {
+		b.AddOp(OP_0)
+	})
+}
+
+// spendHTLC builds a transaction moving the contract output to the wallet.
+// branch pushes the arguments that select the redeem or refund path.
+func (w *Wallet) spendHTLC(h *HTLC, utxoKey string, prevOut TxOut, fee uint64, lockTime uint32, branch func(*ScriptBuilder)) (Tx, error) {
+	if !bytes.Equal(prevOut.LockingScript, h.LockingScript()) {
+		return Tx{}, errors.New("output is not locked by the contract")
+	}
+	if prevOut.Amount < fee || prevOut.Amount-fee < DustLimit {
+		return Tx{}, fmt.Errorf("contract amount %d does not cover a fee of %d", prevOut.Amount, fee)
+	}
+	txIDHex, idxStr, _ := strings.Cut(utxoKey, ":")
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil || len(txID) != 32 {
+		return Tx{}, fmt.Errorf("invalid utxo key %s", utxoKey)
+	}
+	idx, err := strconv.ParseUint(idxStr, 10, 32)
+	if err != nil {
+		return Tx{}, fmt.Errorf("invalid utxo key %s", utxoKey)
+	}
+
+	tx := Tx{
+		Version:  1,
+		TxIns:    []TxIn{{PrevTx: txID, PrevIndex: uint32(idx), Net: w.network(), KeyType: w.KeyType, SigType: w.SigType}},
+		TxOuts:   []TxOut{{Amount: prevOut.Amount - fee, LockingScript: w.GetLockingScript()}},
+		LockTime: lockTime,
+	}
+	hash, err := tx.SignatureHash(0, prevOut, SigHashAll)
+	if err != nil {
+		return Tx{}, err
+	}
+	sig, err := w.sign(hash)
+	if err != nil {
+		return Tx{}, err
+	}
+
+	b := NewScriptBuilder().AddData(append(sig, byte(SigHashAll))).AddData(w.PublicKey)
+	branch(b)
+	tx.TxIns[0].UnlockingScript = b.AddData(h.RedeemScript).Script()
+	return tx, nil
+}
+
+// ExtractHTLCSecret returns the secret revealed by a transaction redeeming the
+// contract, so the other party of a swap can claim its side with it
+func ExtractHTLCSecret(tx *Tx, h *HTLC) ([]byte, error) {
+	for _, in := range tx.TxIns {
+		// <sig> <pubKey> <secret> OP_1 <redeemScript>
+		ops, err := parseScript(in.UnlockingScript)
+		if err != nil || len(ops) != 5 || ops[3].opcode != OP_1 {
+			continue
+		}
+		if !bytes.Equal(ops[4].pushedData(), h.RedeemScript) {
+			continue
+		}
+		secret := ops[2].pushedData()
+		if bytes.Equal(HashSHA3(secret), h.SecretHash) {
+			return secret, nil
+		}
+	}
+	return nil, errors.New("transaction does not redeem the contract")
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/xkal1bur/blockchain/pkg/crypto"
+)
+
+// KeyType selects the curve of the keys and signatures of an input. Every
+// input carries one (TxIn.KeyType), and the signature hash commits to it.
+type KeyType byte
+
+const (
+	KeyTypeP256      KeyType = 0 // NIST P-256, the original curve of the project
+	KeyTypeSecp256k1 KeyType = 1 // secp256k1, the curve of Bitcoin
+)
+
+// Curve returns the elliptic curve of the key type
+func (k KeyType) Curve() (elliptic.Curve, error) {
+	switch k {
+	case KeyTypeP256:
+		return StandardCurve, nil
+	case KeyTypeSecp256k1:
+		return crypto.Secp256k1(), nil
+	}
+	return nil, fmt.Errorf("unknown key type %d", byte(k))
+}
+
+// Valid reports whether k is a supported key type
+func (k KeyType) Valid() bool {
+	_, err := k.Curve()
+	return err == nil
+}
+
+func (k KeyType) String() string {
+	switch k {
+	case KeyTypeP256:
+		return "p256"
+	case KeyTypeSecp256k1:
+		return "secp256k1"
+	}
+	return fmt.Sprintf("KeyType(%d)", byte(k))
+}
+
+// ParseKeyType returns the key type named s ("p256" or "secp256k1"). An
+// empty name is P-256, the type of wallets saved before key types existed.
+func ParseKeyType(s string) (KeyType, error) {
+	switch s {
+	case "", "p256":
+		return KeyTypeP256, nil
+	case "secp256k1":
+		return KeyTypeSecp256k1, nil
+	}
+	return 0, fmt.Errorf("unknown key type %q", s)
+}
+
+// ParsePubKey converts a public key of the given key type, compressed (0x02
+// or 0x03 + x, 33 bytes) or uncompressed (0x04 + x + y, 65 bytes), checking
+// it is a point of its curve
+func ParsePubKey(pub []byte, keyType KeyType) (*ecdsa.PublicKey, error) {
+	curve, err := keyType.Curve()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(pub) == 33 && (pub[0] == 0x02 || pub[0] == 0x03):
+		x, y, err := crypto.DecompressPoint(curve, pub)
+		if err != nil {
+			return nil, fmt.Errorf("public key is not on the %s curve", keyType)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case len(pub) == 65 && pub[0] == 0x04:
+		x := new(big.Int).SetBytes(pub[1:33])
+		y := new(big.Int).SetBytes(pub[33:])
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("public key is not on the %s curve", keyType)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("public key must be compressed (0x02/0x03 + 32 bytes) or uncompressed (0x04 + 64 bytes)")
+}
+
+// isPubKey reports whether pub is a valid public key of any key type
+func isPubKey(pub []byte) bool {
+	for _, k := range []KeyType{KeyTypeP256, KeyTypeSecp256k1} {
+		if _, err := ParsePubKey(pub, k); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// halfOrder returns n/2 for the curve of the key type, the largest S a
+// signature may have (see checkLowS)
+func (k KeyType) halfOrder() *big.Int {
+	curve, err := k.Curve()
+	if err != nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Rsh(curve.Params().N, 1)
+}
+
+// generateKey returns a new private key and its compressed public key
+func generateKey(keyType KeyType) (priv, pub []byte, err error) {
+	if keyType == KeyTypeP256 {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		uncompressed := key.PublicKey().Bytes()
+		x := new(big.Int).SetBytes(uncompressed[1:33])
+		y := new(big.Int).SetBytes(uncompressed[33:])
+		return key.Bytes(), crypto.CompressPoint(x, y), nil
+	}
+
+	curve, err := keyType.Curve()
+	if err != nil {
+		return nil, nil, err
+	}
+	// d uniform in [1, n-1]
+	nMinus1 := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	d, err := rand.Int(rand.Reader, nMinus1)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.Add(d, big.NewInt(1))
+	priv = d.FillBytes(make([]byte, 32))
+	x, y := curve.ScalarBaseMult(priv)
+	return priv, crypto.CompressPoint(x, y), nil
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timelocks, following Bitcoin's BIP65, BIP68, BIP112 and BIP113.
+//
+// A transaction can be locked until an absolute height or time (Tx.LockTime)
+// and each input until the output it spends is old enough (TxIn.Sequence).
+// Scripts can require both with OP_CHECKLOCKTIMEVERIFY and
+// OP_CHECKSEQUENCEVERIFY. Times are always compared with the median time past
+// of the previous block, never with the timestamp of the block being built,
+// so a miner cannot unlock a transaction early by moving its clock forward.
+
+const (
+	// LockTimeThreshold separates heights (below) from Unix times in
+	// Tx.LockTime and in the operand of OP_CHECKLOCKTIMEVERIFY
+	LockTimeThreshold uint32 = 500_000_000
+
+	// SequenceFinal disables both the relative lock of the input and, if every
+	// input uses it, the lock time of the transaction
+	SequenceFinal uint32 = 0xffffffff
+
+	// Layout of a relative lock in TxIn.Sequence
+	SequenceLockTimeDisabled    uint32 = 1 << 31 // The input has no relative lock
+	SequenceLockTimeIsSeconds   uint32 = 1 << 22 // The lock is in units of 512 seconds instead of blocks
+	SequenceLockTimeMask        uint32 = 0x0000ffff
+	SequenceLockTimeGranularity        = 9 // log2(512)
+)
+
+// RelativeLockTime returns the Sequence that locks an input until d has passed
+// since the spent output was confirmed, rounded up to units of 512 seconds
+func RelativeLockTime(d time.Duration) uint32 {
+	units := (uint64(d.Seconds()) + 1<<SequenceLockTimeGranularity - 1) >> SequenceLockTimeGranularity
+	if units > uint64(SequenceLockTimeMask) {
+		units = uint64(SequenceLockTimeMask)
+	}
+	return SequenceLockTimeIsSeconds | uint32(units)
+}
+
+// IsFinal reports whether tx may be included in a block at height whose
+// previous block has the given median time past
+func (tx *Tx) IsFinal(height, medianTime uint64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	limit := medianTime
+	if tx.LockTime < LockTimeThreshold {
+		limit = height
+	}
+	if uint64(tx.LockTime) < limit {
+		return true
+	}
+	// Final inputs opt out of the lock time
+	for _, in := range tx.TxIns {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// lockTimeString describes a lock time for error messages
+func lockTimeString(lockTime uint32) string {
+	if lockTime < LockTimeThreshold {
+		return fmt.Sprintf("la altura %d", lockTime)
+	}
+	return time.Unix(int64(lockTime), 0).UTC().Format(time.RFC3339)
+}
+
+// checkSequenceLock verifies the relative lock of input idx of tx, which
+// spends the output stored under key
+func (v *UTXOView) checkSequenceLock(tx *Tx, idx int, key string) error {
+	seq := tx.TxIns[idx].Sequence
+	if tx.Version < 2 || seq&SequenceLockTimeDisabled != 0 {
+		return nil
+	}
+	coinHeight := v.coinHeight(key)
+	value := uint64(seq & SequenceLockTimeMask)
+
+	if seq&SequenceLockTimeIsSeconds != 0 {
+		// Measured from the median time past of the block before the one that
+		// confirmed the output
+		required := v.medianTimeAt(coinHeight) + value<<SequenceLockTimeGranularity
+		if v.medianTime < required {
+			return fmt.Errorf("bloqueo relativo hasta el tiempo %d (mediana actual %d)", required, v.medianTime)
+		}
+		return nil
+	}
+	if required := coinHeight + value; v.height < required {
+		return fmt.Errorf("bloqueo relativo hasta la altura %d (bloque actual %d)", required, v.height)
+	}
+	return nil
+}
+
+// coinHeight returns the height of the block that confirmed the output stored
+// under key. Outputs created inside the view are confirmed by the block being
+// validated.
+func (v *UTXOView) coinHeight(key string) uint64 {
+	if _, ok := v.added[key]; ok {
+		return v.height
+	}
+	txID, _, _ := strings.Cut(key, ":")
+	if h, ok := v.txHeights[txID]; ok {
+		return h
+	}
+	return v.height
+}
+
+// medianTimeAt returns the median time past of the block before height
+func (v *UTXOView) medianTimeAt(height uint64) uint64 {
+	if height >= v.height {
+		return v.medianTime
+	}
+	if height == 0 {
+		return 0
+	}
+	return medianTimePast(v.tip.ancestor(height - 1))
+}
+
+// ancestor returns the block at height on the branch ending at n
+func (n *blockNode) ancestor(height uint64) *blockNode {
+	for n != nil && n.height > height {
+		n = n.parent
+	}
+	return n
+}
+
+// ------------------------------------------------------
+// Script checks
+
+// checkLockTime implements OP_CHECKLOCKTIMEVERIFY: the transaction lock time
+// must be of the same kind (height or time) as lockTime and at least as late,
+// and the input must not be final, otherwise the lock time would be ignored
+func (e *scriptEngine) checkLockTime(lockTime int64) error {
+	if lockTime < 0 {
+		return errors.New("negative lock time")
+	}
+	txLockTime := int64(e.tx.LockTime)
+	threshold := int64(LockTimeThreshold)
+	if (lockTime < threshold) != (txLockTime < threshold) {
+		return errors.New("lock time type mismatch")
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("lock time %d not reached by transaction lock time %d", lockTime, txLockTime)
+	}
+	if e.tx.TxIns[e.idx].Sequence == SequenceFinal {
+		return errors.New("input sequence is final")
+	}
+	return nil
+}
+
+// checkSequence implements OP_CHECKSEQUENCEVERIFY: unless the operand
+// disables it, the input must carry a relative lock of the same kind (blocks or
+// seconds) and at least as long
+func (e *scriptEngine) checkSequence(sequence int64) error {
+	if sequence < 0 {
+		return errors.New("negative sequence")
+	}
+	if sequence&int64(SequenceLockTimeDisabled) != 0 {
+		return nil
+	}
+	if e.tx.Version < 2 {
+		return fmt.Errorf("transaction version %d does not support relative locks", e.tx.Version)
+	}
+	txSequence := e.tx.TxIns[e.idx].Sequence
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return errors.New("input relative lock is disabled")
+	}
+	want := uint32(sequence) & (SequenceLockTimeIsSeconds | SequenceLockTimeMask)
+	have := txSequence & (SequenceLockTimeIsSeconds | SequenceLockTimeMask)
+	if want&SequenceLockTimeIsSeconds != have&SequenceLockTimeIsSeconds {
+		return errors.New("relative lock type mismatch")
+	}
+	if want&SequenceLockTimeMask > have&SequenceLockTimeMask {
+		return fmt.Errorf("relative lock %d not reached by input sequence %d", want&SequenceLockTimeMask, have&SequenceLockTimeMask)
+	}
+	return nil
+}
+
+// ------------------------------------------------------
+
+// chainView returns a view of the UTXO set for validating transactions of a
+// block built on top of parent, which must be the active tip. The caller must
+// hold bs.mu.
+func (bs *BlockchainServer) chainView(parent *blockNode) *UTXOView {
+	view := NewUTXOView(bs.utxoSet)
+	view.tip = parent
+	view.medianTime = medianTimePast(parent)
+	view.txHeights = bs.txHeights
+	if parent != nil {
+		view.height = parent.height + 1
+	}
+	return view
+}
@@ -0,0 +1,659 @@
+package core
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sort"
+	"time"
+)
+
+// blockTemplateMaxSize is the most bytes of transactions our miner puts in a
+// block
+const blockTemplateMaxSize = 1_000_000
+
+// MempoolConfig limits the transactions a node keeps waiting to be mined.
+// Unlike ChainParams these are local policy: nodes may use different values.
+type MempoolConfig struct {
+	MaxSize         int           // Total size (bytes) of the pooled transactions
+	Expiry          time.Duration // Transactions waiting longer than this are dropped
+	MaxAncestors    int           // Longest chain of unconfirmed transactions, counting the last one
+	MaxDescendants  int           // Most unconfirmed transactions depending on one, counting itself
+	MinRelayFeeRate uint64        // Lowest fee per byte of the transactions accepted
+}
+
+// DefaultMempoolConfig is the policy used by NewBlockchainServer
+var DefaultMempoolConfig = MempoolConfig{
+	MaxSize:         50_000_000,
+	Expiry:          72 * time.Hour,
+	MaxAncestors:    25,
+	MaxDescendants:  25,
+	MinRelayFeeRate: 1,
+}
+
+// MempoolEntry is a transaction waiting in the mempool
+type MempoolEntry struct {
+	Tx    Tx
+	ID    string
+	Fee   uint64    // Inputs minus outputs
+	Size  int       // Bytes of the binary encoding, unlocking scripts included
+	Added time.Time // When the transaction entered the mempool
+
+	seq      uint64          // Arrival order
+	parents  map[string]bool // Mempool transactions whose outputs it spends
+	children map[string]bool // Mempool transactions spending its outputs
+
+	// Totals of the entry and its unconfirmed ancestors, the package a miner
+	// has to take to include it. Kept up to date as entries come and go.
+	ancestorFee  uint64
+	ancestorSize int
+}
+
+// FeeRate returns the fee paid per byte
+func (e *MempoolEntry) FeeRate() float64 {
+	return float64(e.Fee) / float64(e.Size)
+}
+
+// betterThan orders entries by decreasing fee-rate, and by arrival among equals
+func (e *MempoolEntry) betterThan(o *MempoolEntry) bool {
+	if c := compareFeeRates(e.Fee, e.Size, o.Fee, o.Size); c != 0 {
+		return c > 0
+	}
+	return e.seq < o.seq
+}
+
+// compareFeeRates compares feeA/sizeA with feeB/sizeB and returns -1, 0 or
+// +1. It cross-multiplies on 128 bits, so the result is exact.
+func compareFeeRates(feeA uint64, sizeA int, feeB uint64, sizeB int) int {
+	aHi, aLo := bits.Mul64(feeA, uint64(sizeB))
+	bHi, bLo := bits.Mul64(feeB, uint64(sizeA))
+	if c := cmp.Compare(aHi, bHi); c != 0 {
+		return c
+	}
+	return cmp.Compare(aLo, bLo)
+}
+
+// Mempool holds the validated transactions that are not in the active chain
+// yet, by ID. It remembers which transaction spends each outpoint, so that a
+// second transaction spending the same output is refused instead of waiting
+// next to the first one until a block picks either.
+//
+// Transactions may spend the outputs of other mempool transactions. Each entry
+// links to its unconfirmed parents and children, and the length of those
+// chains is limited: a long chain is expensive to re-check at every block and
+// has to be evicted as a whole.
+//
+// The mempool has no lock of its own: the server accesses it holding bs.mu.
+type Mempool struct {
+	config  MempoolConfig
+	entries map[string]*MempoolEntry
+	spentBy map[string]string // ID of the transaction spending each outpoint ("txid:index")
+	size    int               // Sum of the Size of the entries
+	nextSeq uint64
+}
+
+// NewMempool returns an empty mempool with the given limits
+func NewMempool(config MempoolConfig) *Mempool {
+	return &Mempool{
+		config:  config,
+		entries: make(map[string]*MempoolEntry),
+		spentBy: make(map[string]string),
+	}
+}
+
+// Count returns the number of transactions in the mempool
+func (mp *Mempool) Count() int {
+	return len(mp.entries)
+}
+
+// Size returns the total size (bytes) of the transactions in the mempool
+func (mp *Mempool) Size() int {
+	return mp.size
+}
+
+// Has reports whether the transaction with the given ID is in the mempool
+func (mp *Mempool) Has(id string) bool {
+	_, ok := mp.entries[id]
+	return ok
+}
+
+// add inserts a validated transaction paying fee. When the mempool grows over
+// MaxSize, the entries with the lowest fee-rate are evicted to make room; if tx
+// pays less per byte than all of them it is refused instead.
+func (mp *Mempool) add(tx Tx, fee uint64) error {
+	entry := &MempoolEntry{
+		Tx:    tx,
+		ID:    tx.ID(),
+		Fee:   fee,
+		Size:  len(tx.Serialize()),
+		Added: time.Now(),
+	}
+	if err := mp.checkChainLimits(&tx); err != nil {
+		return err
+	}
+	if excess := mp.size + entry.Size - mp.config.MaxSize; excess > 0 {
+		evicted, freed := mp.cheapest(excess, entry)
+		if freed < excess {
+			return fmt.Errorf("mempool full: fee-rate %.2f is too low", entry.FeeRate())
+		}
+		// Evicting a parent of tx would leave it spending missing outputs
+		for _, in := range tx.TxIns {
+			if evicted[fmt.Sprintf("%x", in.PrevTx)] {
+				return fmt.Errorf("mempool full: fee-rate %.2f is too low", entry.FeeRate())
+			}
+		}
+		for id := range evicted {
+			mp.remove(id)
+			fmt.Printf("🗑️  Transaction %s evicted from the full mempool\n", id)
+		}
+	}
+	mp.insert(entry)
+	return nil
+}
+
+// cheapest returns the transactions to evict to free at least bytes: the
+// entries with the lowest fee-rate, along with their descendants. Only
+// entries worse than rival are taken (a nil rival takes any). It also returns
+// the size they free.
+func (mp *Mempool) cheapest(bytes int, rival *MempoolEntry) (map[string]bool, int) {
+	sorted := mp.sorted()
+	evicted := make(map[string]bool)
+	freed := 0
+	for i := len(sorted) - 1; i >= 0 && freed < bytes; i-- {
+		if rival != nil && !rival.betterThan(sorted[i]) {
+			break
+		}
+		for id := range mp.descendants(sorted[i].ID) {
+			if !evicted[id] {
+				evicted[id] = true
+				freed += mp.entries[id].Size
+			}
+		}
+	}
+	return evicted, freed
+}
+
+// checkChainLimits refuses tx if, once added, it would have too many
+// unconfirmed ancestors or make one of them have too many descendants
+func (mp *Mempool) checkChainLimits(tx *Tx) error {
+	ancestors := make(map[string]bool)
+	for _, in := range tx.TxIns {
+		if parent := hex.EncodeToString(in.PrevTx); mp.Has(parent) {
+			for id := range mp.ancestors(parent) {
+				ancestors[id] = true
+			}
+		}
+	}
+	if len(ancestors)+1 > mp.config.MaxAncestors {
+		return fmt.Errorf("too many unconfirmed ancestors (%d, limit %d)", len(ancestors)+1, mp.config.MaxAncestors)
+	}
+	for id := range ancestors {
+		if n := len(mp.descendants(id)) + 1; n > mp.config.MaxDescendants {
+			return fmt.Errorf("too many unconfirmed descendants of %s (%d, limit %d)", id, n, mp.config.MaxDescendants)
+		}
+	}
+	return nil
+}
+
+// insert indexes an entry, without checking limits or conflicts. Its parents
+// and children may be inserted before or after it.
+func (mp *Mempool) insert(entry *MempoolEntry) {
+	entry.seq = mp.nextSeq
+	mp.nextSeq++
+	entry.parents = make(map[string]bool)
+	entry.children = make(map[string]bool)
+	for _, in := range entry.Tx.TxIns {
+		if parent, ok := mp.entries[hex.EncodeToString(in.PrevTx)]; ok {
+			entry.parents[parent.ID] = true
+			parent.children[entry.ID] = true
+		}
+		mp.spentBy[outpointKey(in.PrevTx, in.PrevIndex)] = entry.ID
+	}
+	for idx := range entry.Tx.TxOuts {
+		if child, ok := mp.spentBy[fmt.Sprintf("%s:%d", entry.ID, idx)]; ok {
+			entry.children[child] = true
+			mp.entries[child].parents[entry.ID] = true
+		}
+	}
+	mp.entries[entry.ID] = entry
+	mp.size += entry.Size
+
+	// The entry and its descendants have a new ancestor
+	for d := range mp.descendants(entry.ID) {
+		mp.updateAncestorTotals(d)
+	}
+}
+
+// updateAncestorTotals recomputes the ancestor fee and size of an entry
+func (mp *Mempool) updateAncestorTotals(id string) {
+	entry := mp.entries[id]
+	entry.ancestorFee, entry.ancestorSize = 0, 0
+	for a := range mp.ancestors(id) {
+		entry.ancestorFee += mp.entries[a].Fee
+		entry.ancestorSize += mp.entries[a].Size
+	}
+}
+
+// remove drops a single transaction
+func (mp *Mempool) remove(id string) {
+	entry, ok := mp.entries[id]
+	if !ok {
+		return
+	}
+	descendants := mp.descendants(id)
+	delete(mp.entries, id)
+	for parent := range entry.parents {
+		delete(mp.entries[parent].children, id)
+	}
+	for child := range entry.children {
+		delete(mp.entries[child].parents, id)
+	}
+	for _, in := range entry.Tx.TxIns {
+		key := outpointKey(in.PrevTx, in.PrevIndex)
+		if mp.spentBy[key] == id {
+			delete(mp.spentBy, key)
+		}
+	}
+	mp.size -= entry.Size
+
+	// Its descendants no longer have it as an ancestor
+	for d := range descendants {
+		if d != id {
+			mp.updateAncestorTotals(d)
+		}
+	}
+}
+
+// ancestors returns id and the IDs of the mempool transactions it spends
+// outputs of, directly or not. It cannot be mined without them.
+func (mp *Mempool) ancestors(id string) map[string]bool {
+	return mp.walk(id, func(e *MempoolEntry) map[string]bool { return e.parents })
+}
+
+// descendants returns id and the IDs of every mempool transaction spending
+// its outputs, directly or not. They cannot be mined without it.
+func (mp *Mempool) descendants(id string) map[string]bool {
+	return mp.walk(id, func(e *MempoolEntry) map[string]bool { return e.children })
+}
+
+// walk returns the entries reachable from id following next
+func (mp *Mempool) walk(id string, next func(*MempoolEntry) map[string]bool) map[string]bool {
+	found := make(map[string]bool)
+	queue := []string{id}
+	for len(queue) > 0 {
+		id, queue = queue[0], queue[1:]
+		entry, ok := mp.entries[id]
+		if !ok || found[id] {
+			continue
+		}
+		found[id] = true
+		for other := range next(entry) {
+			queue = append(queue, other)
+		}
+	}
+	return found
+}
+
+// removeWithDescendants drops a transaction and its descendants. It returns
+// the IDs removed.
+func (mp *Mempool) removeWithDescendants(id string) []string {
+	var ids []string
+	for d := range mp.descendants(id) {
+		mp.remove(d)
+		ids = append(ids, d)
+	}
+	return ids
+}
+
+// expire drops the transactions that have waited longer than Expiry
+func (mp *Mempool) expire() {
+	for id, entry := range mp.entries {
+		if time.Since(entry.Added) > mp.config.Expiry {
+			for _, removed := range mp.removeWithDescendants(id) {
+				fmt.Printf("🗑️  Transaction %s expired from the mempool\n", removed)
+			}
+		}
+	}
+}
+
+// sorted returns the entries by decreasing fee-rate
+func (mp *Mempool) sorted() []*MempoolEntry {
+	entries := make([]*MempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].betterThan(entries[j]) })
+	return entries
+}
+
+// inArrivalOrder returns the entries in the order they entered the mempool,
+// except that parents always come before their children: the transactions of
+// a disconnected block return to the mempool after their children
+func (mp *Mempool) inArrivalOrder() []*MempoolEntry {
+	entries := make([]*MempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		entries = append(entries, e)
+	}
+	return mp.parentsFirst(entries)
+}
+
+// parentsFirst sorts entries by arrival, moving the parents among them before
+// their children
+func (mp *Mempool) parentsFirst(entries []*MempoolEntry) []*MempoolEntry {
+	bySeq := func(a, b *MempoolEntry) int { return cmp.Compare(a.seq, b.seq) }
+	slices.SortFunc(entries, bySeq)
+	included := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		included[e.ID] = true
+	}
+
+	ordered := make([]*MempoolEntry, 0, len(entries))
+	visited := make(map[string]bool, len(entries))
+	var visit func(e *MempoolEntry)
+	visit = func(e *MempoolEntry) {
+		if visited[e.ID] {
+			return
+		}
+		visited[e.ID] = true
+		parents := make([]*MempoolEntry, 0, len(e.parents))
+		for p := range e.parents {
+			if included[p] {
+				parents = append(parents, mp.entries[p])
+			}
+		}
+		slices.SortFunc(parents, bySeq)
+		for _, p := range parents {
+			visit(p)
+		}
+		ordered = append(ordered, e)
+	}
+	for _, e := range entries {
+		visit(e)
+	}
+	return ordered
+}
+
+// templateCandidate is an entry waiting to be picked for a block template,
+// with the fee and size of its package: itself and its ancestors not picked
+// yet
+type templateCandidate struct {
+	entry *MempoolEntry
+	fee   uint64
+	size  int
+}
+
+// templateHeap orders candidates by decreasing package fee-rate
+type templateHeap []templateCandidate
+
+func (h templateHeap) Len() int { return len(h) }
+func (h templateHeap) Less(i, j int) bool {
+	if c := compareFeeRates(h[i].fee, h[i].size, h[j].fee, h[j].size); c != 0 {
+		return c > 0
+	}
+	return h[i].entry.seq < h[j].entry.seq
+}
+func (h templateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *templateHeap) Push(x any)   { *h = append(*h, x.(templateCandidate)) }
+func (h *templateHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// selectTransactions picks the transactions of a block template on top of
+// view, up to maxSize bytes. Transactions are taken with their unconfirmed
+// ancestors as a package, best package fee-rate first: a parent paying too
+// little is mined along with a child paying enough for both
+// (child-pays-for-parent). It returns the transactions in a valid block order
+// and their total fee.
+//
+// Packages start from the ancestor totals the mempool keeps, in a heap. Once
+// a transaction is taken its descendants no longer pay for it: their package
+// shrinks and they are pushed again, and the outdated copies are skipped.
+func (mp *Mempool) selectTransactions(view *UTXOView, maxSize int) ([]Tx, uint64) {
+	var selected []Tx
+	var fees uint64
+	size := 0
+
+	packages := make(map[string]templateCandidate, len(mp.entries))
+	candidates := make(templateHeap, 0, len(mp.entries))
+	for id, e := range mp.entries {
+		packages[id] = templateCandidate{entry: e, fee: e.ancestorFee, size: e.ancestorSize}
+		candidates = append(candidates, packages[id])
+	}
+	heap.Init(&candidates)
+
+	taken := make(map[string]bool)
+	skipped := make(map[string]bool) // Invalid on top of the template, so are their descendants
+	for candidates.Len() > 0 {
+		c := heap.Pop(&candidates).(templateCandidate)
+		id := c.entry.ID
+		if taken[id] || skipped[id] || c != packages[id] || size+c.size > maxSize {
+			continue
+		}
+		for _, e := range mp.ancestorPackage(id, taken) {
+			if _, err := e.Tx.Validate(view); err != nil {
+				for d := range mp.descendants(e.ID) {
+					skipped[d] = true
+				}
+				break
+			}
+			view.ApplyTx(&e.Tx)
+			taken[e.ID] = true
+			selected = append(selected, e.Tx)
+			fees += e.Fee
+			size += e.Size
+
+			for d := range mp.descendants(e.ID) {
+				if p := packages[d]; !taken[d] {
+					p.fee -= e.Fee
+					p.size -= e.Size
+					packages[d] = p
+					heap.Push(&candidates, p)
+				}
+			}
+		}
+	}
+	return selected, fees
+}
+
+// ancestorPackage returns the entry id with its ancestors that are not taken
+// yet, parents first
+func (mp *Mempool) ancestorPackage(id string, taken map[string]bool) []*MempoolEntry {
+	var pkg []*MempoolEntry
+	for a := range mp.ancestors(id) {
+		if !taken[a] {
+			pkg = append(pkg, mp.entries[a])
+		}
+	}
+	return mp.parentsFirst(pkg)
+}
+
+// acceptToMempool validates a transaction received from a client or a peer
+// and adds it to the mempool. The caller must hold bs.mu.
+func (bs *BlockchainServer) acceptToMempool(tx Tx) (uint64, error) {
+	id := tx.ID()
+	if bs.mempool.Has(id) {
+		return 0, fmt.Errorf("transaction %s already in mempool", id)
+	}
+	if _, ok := bs.orphanTxs[id]; ok {
+		return 0, fmt.Errorf("transaction %s already waiting for its parents", id)
+	}
+	bs.mempool.expire()
+
+	// A transaction spending the same outputs as mempool transactions can
+	// only replace them (see rbf.go)
+	replaced, err := bs.mempool.replacedBy(&tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Validate against the UTXO set as left by the pooled transactions, so
+	// it may spend their outputs. An output that is not there was either
+	// spent or belongs to a transaction we have not seen yet: only the
+	// transactions of the active chain can have spent outputs.
+	view := bs.pendingView(replaced)
+	var missing []string
+	for _, in := range tx.TxIns {
+		if _, ok := view.Get(outpointKey(in.PrevTx, in.PrevIndex)); ok {
+			continue
+		}
+		parent := hex.EncodeToString(in.PrevTx)
+		if replaced[parent] {
+			return 0, fmt.Errorf("transaction spends an output of %s, which it replaces", parent)
+		}
+		if _, confirmed := bs.txHeights[parent]; !confirmed && !bs.mempool.Has(parent) && !slices.Contains(missing, parent) {
+			missing = append(missing, parent)
+		}
+	}
+	if len(missing) > 0 {
+		return 0, &missingParentsError{parents: missing}
+	}
+	fee, err := tx.Validate(view)
+	if err != nil {
+		return 0, fmt.Errorf("transaction validation failed: %v", err)
+	}
+	// Without a minimum fee the mempool could be filled for free
+	if minFee := bs.mempool.config.MinRelayFeeRate * uint64(len(tx.Serialize())); fee < minFee {
+		return 0, fmt.Errorf("fee %d is below the minimum relay fee of %d (%d per byte)", fee, minFee, bs.mempool.config.MinRelayFeeRate)
+	}
+	if err := bs.mempool.checkReplacement(&tx, fee, replaced); err != nil {
+		return 0, err
+	}
+
+	// The replaced transactions go back in if tx does not fit after all
+	removed := bs.mempool.removeAll(replaced)
+	if err := bs.mempool.add(tx, fee); err != nil {
+		for _, e := range removed {
+			bs.mempool.insert(e)
+		}
+		return 0, err
+	}
+	for _, e := range removed {
+		fmt.Printf("♻️  Transaction %s replaced by %s\n", e.ID, id)
+	}
+	return fee, nil
+}
+
+// pendingView returns a view of the UTXO set after all pending transactions
+// but the excluded ones. The caller must hold bs.mu.
+func (bs *BlockchainServer) pendingView(exclude map[string]bool) *UTXOView {
+	view := bs.chainView(bs.tip)
+	for _, e := range bs.mempool.inArrivalOrder() {
+		if !exclude[e.ID] {
+			view.ApplyTx(&e.Tx)
+		}
+	}
+	return view
+}
+
+// GetPendingUTXOSet returns a copy of the UTXO set as left by the mempool
+// transactions: their outputs are in it and the outputs they spend are not.
+// A wallet building on it can spend its unconfirmed change.
+func (bs *BlockchainServer) GetPendingUTXOSet() map[string]TxOut {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.pendingView(nil).outputs()
+}
+
+// returnToPending puts the transactions of a disconnected block back into the
+// mempool, unchecked until the next revalidatePending. Coinbase transactions
+// are dropped.
+func (bs *BlockchainServer) returnToPending(block Block) {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() || bs.mempool.Has(tx.ID()) {
+			continue
+		}
+		bs.mempool.insert(&MempoolEntry{
+			Tx:    tx,
+			ID:    tx.ID(),
+			Size:  len(tx.Serialize()),
+			Added: time.Now(),
+		})
+	}
+}
+
+// removeConfirmedFromPending drops the mempool transactions included in block
+func (bs *BlockchainServer) removeConfirmedFromPending(block Block) {
+	for i := range block.Transactions {
+		bs.mempool.remove(block.Transactions[i].ID())
+	}
+}
+
+// removeForBlock updates the mempool for a block connected on top of the tip
+// it was validated against: the transactions of the block are confirmed, and
+// those spending the same outputs are now double spends, dropped along with
+// their descendants. The others stay valid and are not checked again.
+func (bs *BlockchainServer) removeForBlock(block Block) {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		bs.mempool.remove(tx.ID())
+		for _, in := range tx.TxIns {
+			if id, ok := bs.mempool.spentBy[outpointKey(in.PrevTx, in.PrevIndex)]; ok {
+				for _, removed := range bs.mempool.removeWithDescendants(id) {
+					fmt.Printf("🗑️  Dropping pending transaction %s: conflicts with block transaction %s\n", removed, tx.ID())
+				}
+			}
+		}
+	}
+}
+
+// revalidatePending rebuilds the mempool with the transactions that are still
+// valid on top of the active chain after a reorganization or a disconnected
+// block, recomputing their fees. Parents are checked before their children,
+// including those returned by a disconnected block, so a transaction that
+// fails drops its descendants too.
+func (bs *BlockchainServer) revalidatePending() {
+	old := bs.mempool
+	bs.mempool = NewMempool(old.config)
+	view := bs.chainView(bs.tip)
+	for _, e := range old.inArrivalOrder() {
+		fee, err := e.Tx.Validate(view)
+		if err != nil {
+			fmt.Printf("🗑️  Dropping pending transaction %s: %v\n", e.ID, err)
+			continue
+		}
+		view.ApplyTx(&e.Tx)
+		bs.mempool.insert(&MempoolEntry{Tx: e.Tx, ID: e.ID, Fee: fee, Size: e.Size, Added: e.Added})
+	}
+	bs.mempool.expire()
+	bs.mempool.trim()
+}
+
+// trim evicts the lowest fee-rate transactions until the mempool fits in
+// MaxSize again
+func (mp *Mempool) trim() {
+	if excess := mp.size - mp.config.MaxSize; excess > 0 {
+		evicted, _ := mp.cheapest(excess, nil)
+		for id := range evicted {
+			mp.remove(id)
+			fmt.Printf("🗑️  Transaction %s evicted from the full mempool\n", id)
+		}
+	}
+}
+
+// SetMempoolConfig changes the limits of the mempool, evicting transactions
+// if it no longer fits
+func (bs *BlockchainServer) SetMempoolConfig(config MempoolConfig) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.mempool.config = config
+	bs.mempool.expire()
+	bs.mempool.trim()
+}
+
+// MempoolEntries returns a copy of the mempool entries, highest fee-rate first
+func (bs *BlockchainServer) MempoolEntries() []MempoolEntry {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	sorted := bs.mempool.sorted()
+	entries := make([]MempoolEntry, len(sorted))
+	for i, e := range sorted {
+		entries[i] = *e
+	}
+	return entries
+}
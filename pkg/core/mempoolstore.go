@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const mempoolFile = "mempool.dat"
+
+// mempoolRecord is a transaction of the mempool file (see encodeMempool)
+type mempoolRecord struct {
+	Tx    Tx
+	Added int64 // Unix time it entered the mempool, so expiry survives restarts
+}
+
+// SaveMempool writes the mempool to disk, so that the pending transactions
+// survive a restart of the node. The server calls it periodically and on
+// shutdown.
+func (bs *BlockchainServer) SaveMempool() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.saveMempool()
+}
+
+// saveMempool writes the mempool entries in arrival order, parents before
+// their children. The caller must hold bs.mu.
+func (bs *BlockchainServer) saveMempool() error {
+	entries := bs.mempool.inArrivalOrder()
+	records := make([]mempoolRecord, len(entries))
+	for i, e := range entries {
+		records[i] = mempoolRecord{Tx: e.Tx, Added: e.Added.Unix()}
+	}
+	if err := writeFileAtomic(mempoolFile, encodeMempool(records)); err != nil {
+		return fmt.Errorf("error writing mempool: %v", err)
+	}
+	fmt.Printf("💾 Mempool saved to disk (%d transactions)\n", len(records))
+	return nil
+}
+
+// loadMempool reads the transactions saved by saveMempool and validates them
+// again on top of the active chain: blocks may have been connected since, or
+// the chain reorganized. It returns how many were kept and how many dropped
+// because they expired or are no longer valid.
+func (bs *BlockchainServer) loadMempool() (int, int, error) {
+	data, err := os.ReadFile(mempoolFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	records, err := decodeMempool(data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid mempool file %s: %v", mempoolFile, err)
+	}
+
+	kept, dropped := 0, 0
+	for _, r := range records {
+		added := time.Unix(r.Added, 0)
+		if time.Since(added) > bs.mempool.config.Expiry {
+			dropped++
+			continue
+		}
+		if _, err := bs.acceptToMempool(r.Tx); err != nil {
+			fmt.Printf("🗑️  Dropping saved transaction %s: %v\n", r.Tx.ID(), err)
+			dropped++
+			continue
+		}
+		bs.mempool.entries[r.Tx.ID()].Added = added
+		kept++
+	}
+	return kept, dropped, nil
+}
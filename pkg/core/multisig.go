@@ -0,0 +1,209 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// MultiSig describes an M-of-N output paid through pay-to-script-hash. The
+// redeem script is shared between the co-signers; senders only need the
+// locking script (or its hash).
+type MultiSig struct {
+	Required     int      `json:"required"`
+	PubKeys      [][]byte `json:"pub_keys"`
+	RedeemScript []byte   `json:"redeem_script"`
+}
+
+// NewMultiSig builds the redeem script requiring m signatures from pubKeys
+func NewMultiSig(m int, pubKeys [][]byte) (*MultiSig, error) {
+	redeem, err := MultiSigScript(m, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	// The redeem script is pushed by the unlocking script, so it must fit in
+	// one stack element
+	if len(redeem) > MaxScriptElementSize {
+		return nil, fmt.Errorf("redeem script of %d bytes exceeds %d, use fewer keys", len(redeem), MaxScriptElementSize)
+	}
+	return &MultiSig{Required: m, PubKeys: pubKeys, RedeemScript: redeem}, nil
+}
+
+// ScriptHash returns the SHA3-256 hash of the redeem script
+func (ms *MultiSig) ScriptHash() []byte {
+	return HashSHA3(ms.RedeemScript)
+}
+
+// LockingScript returns the P2SH locking script paying to the multisig
+func (ms *MultiSig) LockingScript() []byte {
+	return PayToScriptHashScript(ms.ScriptHash())
+}
+
+// CreateMultiSig creates the redeem data of an M-of-N output this wallet is
+// one of the signers of
+func (w *Wallet) CreateMultiSig(m int, pubKeys [][]byte) (*MultiSig, error) {
+	found := false
+	for _, pk := range pubKeys {
+		found = found || bytes.Equal(pk, w.PublicKey)
+	}
+	if !found {
+		return nil, errors.New("wallet public key is not one of the multisig keys")
+	}
+	return NewMultiSig(m, pubKeys)
+}
+
+// ------------------------------------------------------
+
+// PartialInput is what a signer needs to sign an input, plus the signatures
+// collected so far
+type PartialInput struct {
+	PrevOut      TxOut             `json:"prev_out"`
+	RedeemScript []byte            `json:"redeem_script,omitempty"` // For P2SH inputs
+	Signatures   map[string][]byte `json:"signatures,omitempty"`    // hex public key → signature + SigHashType
+}
+
+// PartialTx is a transaction being signed by several parties. It is passed
+// from signer to signer (as JSON) and finalized once enough signatures have
+// been collected.
+type PartialTx struct {
+	Tx     Tx             `json:"tx"`
+	Inputs []PartialInput `json:"inputs"`
+}
+
+// NewPartialTx prepares tx for signing. prevOuts are the outputs spent by its
+// inputs; redeemScripts holds the redeem script of each P2SH input (nil for
+// P2PKH inputs, or a nil slice if there are none).
+func NewPartialTx(tx Tx, prevOuts []TxOut, redeemScripts [][]byte) (*PartialTx, error) {
+	if len(prevOuts) != len(tx.TxIns) {
+		return nil, fmt.Errorf("expected %d spent outputs, got %d", len(tx.TxIns), len(prevOuts))
+	}
+	p := &PartialTx{Tx: tx, Inputs: make([]PartialInput, len(tx.TxIns))}
+	p.Tx.TxIns = append([]TxIn(nil), tx.TxIns...)
+	for i := range tx.TxIns {
+		p.Tx.TxIns[i].UnlockingScript = nil
+		p.Inputs[i] = PartialInput{PrevOut: prevOuts[i], Signatures: map[string][]byte{}}
+		if i < len(redeemScripts) && redeemScripts[i] != nil {
+			if !bytes.Equal(prevOuts[i].LockingScript, PayToScriptHashScript(HashSHA3(redeemScripts[i]))) {
+				return nil, fmt.Errorf("redeem script of input %d does not match the spent output", i)
+			}
+			p.Inputs[i].RedeemScript = redeemScripts[i]
+		}
+	}
+	return p, nil
+}
+
+// canSign reports whether pubKey may sign the input: the key of a P2PKH
+// output or one of the keys of a multisig redeem script
+func (in *PartialInput) canSign(pubKey []byte) bool {
+	if in.RedeemScript == nil {
+		return bytes.Equal(ExtractPubKeyHash(in.PrevOut.LockingScript), HashSHA3(pubKey))
+	}
+	_, pubKeys, err := ParseMultiSigScript(in.RedeemScript)
+	if err != nil {
+		return false
+	}
+	for _, pk := range pubKeys {
+		if bytes.Equal(pk, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignPartialTx adds this wallet's signature to every input it can sign and
+// returns how many inputs were signed
+func (w *Wallet) SignPartialTx(p *PartialTx, hashType SigHashType) (int, error) {
+	signed := 0
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if !in.canSign(w.PublicKey) {
+			continue
+		}
+		if kt := p.Tx.TxIns[i].KeyType; kt != w.KeyType {
+			return signed, fmt.Errorf("input %d uses %s keys, wallet has %s", i, kt, w.KeyType)
+		}
+		if st := p.Tx.TxIns[i].SigType; st != w.SigType {
+			return signed, fmt.Errorf("input %d uses %s signatures, wallet signs with %s", i, st, w.SigType)
+		}
+
+		hash, err := p.Tx.SignatureHash(i, in.PrevOut, hashType)
+		if err != nil {
+			return signed, fmt.Errorf("input %d: %v", i, err)
+		}
+		sig, err := w.sign(hash)
+		if err != nil {
+			return signed, err
+		}
+		if in.Signatures == nil {
+			in.Signatures = map[string][]byte{}
+		}
+		in.Signatures[hex.EncodeToString(w.PublicKey)] = append(sig, byte(hashType))
+		signed++
+	}
+	return signed, nil
+}
+
+// Combine merges the signatures collected by other copies of the same
+// partially signed transaction
+func (p *PartialTx) Combine(others ...*PartialTx) error {
+	for _, o := range others {
+		if o.Tx.ID() != p.Tx.ID() || len(o.Inputs) != len(p.Inputs) {
+			return errors.New("cannot combine different transactions")
+		}
+		for i := range o.Inputs {
+			if !bytes.Equal(o.Inputs[i].RedeemScript, p.Inputs[i].RedeemScript) {
+				return fmt.Errorf("input %d has a different redeem script", i)
+			}
+			if p.Inputs[i].Signatures == nil {
+				p.Inputs[i].Signatures = map[string][]byte{}
+			}
+			for pk, sig := range o.Inputs[i].Signatures {
+				p.Inputs[i].Signatures[pk] = sig
+			}
+		}
+	}
+	return nil
+}
+
+// Finalize builds the unlocking script of every input and returns the
+// signed transaction. It fails if an input lacks signatures.
+func (p *PartialTx) Finalize() (Tx, error) {
+	tx := p.Tx
+	tx.TxIns = append([]TxIn(nil), p.Tx.TxIns...)
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.RedeemScript == nil {
+			// P2PKH: the single signature of the key matching the output
+			for pkHex, sig := range in.Signatures {
+				pk, _ := hex.DecodeString(pkHex)
+				if bytes.Equal(ExtractPubKeyHash(in.PrevOut.LockingScript), HashSHA3(pk)) {
+					tx.TxIns[i].UnlockingScript = PubKeyHashUnlockingScript(sig, pk)
+				}
+			}
+			if tx.TxIns[i].UnlockingScript == nil {
+				return Tx{}, fmt.Errorf("input %d is not signed", i)
+			}
+			continue
+		}
+
+		// Multisig: the first m signatures in key order, then the redeem script
+		m, pubKeys, err := ParseMultiSigScript(in.RedeemScript)
+		if err != nil {
+			return Tx{}, fmt.Errorf("input %d: %v", i, err)
+		}
+		b := NewScriptBuilder()
+		have := 0
+		for _, pk := range pubKeys {
+			if sig, ok := in.Signatures[hex.EncodeToString(pk)]; ok && have < m {
+				b.AddData(sig)
+				have++
+			}
+		}
+		if have < m {
+			return Tx{}, fmt.Errorf("input %d has %d of %d signatures", i, have, m)
+		}
+		tx.TxIns[i].UnlockingScript = b.AddData(in.RedeemScript).Script()
+	}
+	return tx, nil
+}
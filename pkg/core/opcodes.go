@@ -0,0 +1,95 @@
+package core
+
+// Opcodes of the script language. The numbering follows Bitcoin Script so the
+// usual references apply, except that OP_SHA3 (SHA3-256) takes the place of
+// OP_HASH160 and CHECKMULTISIG does not pop an extra dummy element.
+const (
+	OP_0         = 0x00 // Push an empty byte array (false)
+	OP_FALSE     = OP_0
+	OP_DATA_1    = 0x01 // 0x01-0x4b push the next n bytes
+	OP_DATA_75   = 0x4b
+	OP_PUSHDATA1 = 0x4c // Next byte is the length of the data
+	OP_PUSHDATA2 = 0x4d // Next two bytes (little endian) are the length
+	OP_1NEGATE   = 0x4f
+	OP_1         = 0x51 // 0x51-0x60 push the numbers 1 to 16
+	OP_TRUE      = OP_1
+	OP_16        = 0x60
+
+	// Flow control
+	OP_NOP    = 0x61
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_VERIFY = 0x69
+	OP_RETURN = 0x6a
+
+	// Stack
+	OP_2DROP = 0x6d
+	OP_2DUP  = 0x6e
+	OP_DEPTH = 0x74
+	OP_DROP  = 0x75
+	OP_DUP   = 0x76
+	OP_NIP   = 0x77
+	OP_OVER  = 0x78
+	OP_SWAP  = 0x7c
+	OP_SIZE  = 0x82
+
+	// Bitwise logic
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+
+	// Arithmetic, on numbers of at most 4 bytes
+	OP_1ADD               = 0x8b
+	OP_1SUB               = 0x8c
+	OP_NEGATE             = 0x8f
+	OP_ABS                = 0x90
+	OP_NOT                = 0x91
+	OP_0NOTEQUAL          = 0x92
+	OP_ADD                = 0x93
+	OP_SUB                = 0x94
+	OP_BOOLAND            = 0x9a
+	OP_BOOLOR             = 0x9b
+	OP_NUMEQUAL           = 0x9c
+	OP_NUMEQUALVERIFY     = 0x9d
+	OP_NUMNOTEQUAL        = 0x9e
+	OP_LESSTHAN           = 0x9f
+	OP_GREATERTHAN        = 0xa0
+	OP_LESSTHANOREQUAL    = 0xa1
+	OP_GREATERTHANOREQUAL = 0xa2
+	OP_MIN                = 0xa3
+	OP_MAX                = 0xa4
+	OP_WITHIN             = 0xa5
+
+	// Crypto
+	OP_SHA256              = 0xa8
+	OP_SHA3                = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	// Timelocks (see locktime.go). They leave their operand on the stack,
+	// so they are usually followed by OP_DROP.
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+)
+
+var opcodeNames = map[byte]string{
+	OP_0: "OP_0", OP_PUSHDATA1: "OP_PUSHDATA1", OP_PUSHDATA2: "OP_PUSHDATA2", OP_1NEGATE: "OP_1NEGATE",
+	OP_NOP: "OP_NOP", OP_IF: "OP_IF", OP_NOTIF: "OP_NOTIF", OP_ELSE: "OP_ELSE", OP_ENDIF: "OP_ENDIF",
+	OP_VERIFY: "OP_VERIFY", OP_RETURN: "OP_RETURN",
+	OP_2DROP: "OP_2DROP", OP_2DUP: "OP_2DUP", OP_DEPTH: "OP_DEPTH", OP_DROP: "OP_DROP", OP_DUP: "OP_DUP",
+	OP_NIP: "OP_NIP", OP_OVER: "OP_OVER", OP_SWAP: "OP_SWAP", OP_SIZE: "OP_SIZE",
+	OP_EQUAL: "OP_EQUAL", OP_EQUALVERIFY: "OP_EQUALVERIFY",
+	OP_1ADD: "OP_1ADD", OP_1SUB: "OP_1SUB", OP_NEGATE: "OP_NEGATE", OP_ABS: "OP_ABS", OP_NOT: "OP_NOT",
+	OP_0NOTEQUAL: "OP_0NOTEQUAL", OP_ADD: "OP_ADD", OP_SUB: "OP_SUB", OP_BOOLAND: "OP_BOOLAND",
+	OP_BOOLOR: "OP_BOOLOR", OP_NUMEQUAL: "OP_NUMEQUAL", OP_NUMEQUALVERIFY: "OP_NUMEQUALVERIFY",
+	OP_NUMNOTEQUAL: "OP_NUMNOTEQUAL", OP_LESSTHAN: "OP_LESSTHAN", OP_GREATERTHAN: "OP_GREATERTHAN",
+	OP_LESSTHANOREQUAL: "OP_LESSTHANOREQUAL", OP_GREATERTHANOREQUAL: "OP_GREATERTHANOREQUAL",
+	OP_MIN: "OP_MIN", OP_MAX: "OP_MAX", OP_WITHIN: "OP_WITHIN",
+	OP_SHA256: "OP_SHA256", OP_SHA3: "OP_SHA3", OP_CHECKSIG: "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY", OP_CHECKMULTISIG: "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY", OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxOrphanBlocks = 100              // Maximum number of blocks waiting for their parent
+	orphanBlockTTL  = 20 * time.Minute // Orphans older than this are discarded
+)
+
+// orphanBlock is a block whose parent we do not know yet
+type orphanBlock struct {
+	block    Block
+	hashHex  string
+	prevHex  string
+	from     string // Peer that sent it, asked for the missing parent
+	received time.Time
+}
+
+// addOrphan stores a block until its parent arrives. It returns false if the
+// block was already waiting. The caller must hold bs.mu.
+func (bs *BlockchainServer) addOrphan(block Block, hashHex, from string) bool {
+	if _, ok := bs.orphanBlocks[hashHex]; ok {
+		return false
+	}
+
+	bs.pruneOrphans()
+
+	// Make room by evicting the oldest orphan
+	if len(bs.orphanBlocks) >= maxOrphanBlocks {
+		var oldest *orphanBlock
+		for _, o := range bs.orphanBlocks {
+			if oldest == nil || o.received.Before(oldest.received) {
+				oldest = o
+			}
+		}
+		bs.removeOrphan(oldest)
+	}
+
+	orphan := &orphanBlock{
+		block:    block,
+		hashHex:  hashHex,
+		prevHex:  hex.EncodeToString(block.PrevBlock),
+		from:     from,
+		received: time.Now(),
+	}
+	bs.orphanBlocks[hashHex] = orphan
+	bs.orphansByPrev[orphan.prevHex] = append(bs.orphansByPrev[orphan.prevHex], hashHex)
+	return true
+}
+
+// removeOrphan deletes an orphan from both indexes. The caller must hold bs.mu.
+func (bs *BlockchainServer) removeOrphan(orphan *orphanBlock) {
+	delete(bs.orphanBlocks, orphan.hashHex)
+	siblings := bs.orphansByPrev[orphan.prevHex]
+	for i, h := range siblings {
+		if h == orphan.hashHex {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(bs.orphansByPrev, orphan.prevHex)
+	} else {
+		bs.orphansByPrev[orphan.prevHex] = siblings
+	}
+}
+
+// pruneOrphans discards orphans that waited too long for their parent
+func (bs *BlockchainServer) pruneOrphans() {
+	for _, o := range bs.orphanBlocks {
+		if time.Since(o.received) > orphanBlockTTL {
+			fmt.Printf("🗑️  Orphan block %s expired\n", o.hashHex)
+			bs.removeOrphan(o)
+		}
+	}
+}
+
+// minOrphanBits returns the lowest difficulty accepted for an orphan block:
+// that of our tip lowered by one retarget, as the orphan may come after a
+// difficulty drop. Without it anyone could fill the orphan pool with blocks
+// of Bits 0 and evict the real ones. The caller must hold bs.mu.
+func (bs *BlockchainServer) minOrphanBits() uint64 {
+	if bs.tip == nil {
+		return bs.params.InitialBits
+	}
+	bits := bs.tip.block.Bits
+	if bits < bs.params.MinBits+bs.params.MaxBitsAdjustment {
+		return bs.params.MinBits
+	}
+	return bits - bs.params.MaxBitsAdjustment
+}
+
+// orphanRoot follows a chain of orphans back to the first missing ancestor
+func (bs *BlockchainServer) orphanRoot(prevHex string) string {
+	for {
+		o, ok := bs.orphanBlocks[prevHex]
+		if !ok {
+			return prevHex
+		}
+		prevHex = o.prevHex
+	}
+}
+
+// processOrphans connects, recursively, every orphan whose parent is the block
+// hashHex that was just accepted. The caller must hold bs.mu.
+func (bs *BlockchainServer) processOrphans(hashHex string) {
+	queue := []string{hashHex}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, h := range append([]string(nil), bs.orphansByPrev[parent]...) {
+			orphan := bs.orphanBlocks[h]
+			bs.removeOrphan(orphan)
+
+			if err := bs.acceptBlock(orphan.block); err != nil {
+				fmt.Printf("❌ Orphan block %s rejected: %v\n", h, err)
+				continue
+			}
+			fmt.Printf("🧩 Orphan block %s connected\n", h)
+			queue = append(queue, h)
+		}
+	}
+}
+
+// ProcessGetBlockMessage answers a GETBLOCK request with the block (main chain
+// or side branch) whose hash is hashHex.
+func (bs *BlockchainServer) ProcessGetBlockMessage(hashHex string) string {
+	bs.mu.Lock()
+	node, ok := bs.blockIndex[strings.ToLower(hashHex)]
+	from := bs.listenAddr
+	bs.mu.Unlock()
+	if !ok {
+		return fmt.Sprintf("ERROR: Unknown block %s", hashHex)
+	}
+
+	blockJSON, err := json.Marshal(BlockMessage{Block: node.block, From: from, Time: time.Now().Unix()})
+	if err != nil {
+		return fmt.Sprintf("ERROR: Failed to marshal block: %v", err)
+	}
+	return "BLOCK:" + string(blockJSON)
+}
+
+// requestBlock asks our configured peers for the block hashHex, the one that
+// sent the orphan first. The From of a message is chosen by its sender, so
+// only addresses of the configured list are ever dialed: a peer must not be
+// able to make us connect to arbitrary hosts.
+func (bs *BlockchainServer) requestBlock(from, hashHex string) {
+	bs.mu.Lock()
+	peers := append([]string(nil), bs.peerServers...)
+	bs.mu.Unlock()
+	if i := slices.Index(peers, from); i > 0 {
+		peers[0], peers[i] = peers[i], peers[0]
+	}
+
+	for _, peer := range peers {
+		fmt.Printf("📥 Requesting missing block %s from %s\n", hashHex, peer)
+		conn, err := net.DialTimeout("tcp", peer, 5*time.Second)
+		if err != nil {
+			log.Printf("Failed to connect to peer %s: %v", peer, err)
+			continue
+		}
+
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		if _, err := conn.Write([]byte("GETBLOCK:" + hashHex + "\n")); err != nil {
+			log.Printf("Failed to request block from peer %s: %v", peer, err)
+			conn.Close()
+			continue
+		}
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		remote := conn.RemoteAddr()
+		conn.Close()
+		if err != nil {
+			log.Printf("Failed to read block from peer %s: %v", peer, err)
+			continue
+		}
+
+		response = strings.TrimSpace(response)
+		if !strings.HasPrefix(response, "BLOCK:") {
+			log.Printf("Peer %s could not provide block %s: %s", peer, hashHex, response)
+			continue
+		}
+
+		var blockMsg BlockMessage
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(response, "BLOCK:")), &blockMsg); err != nil {
+			log.Printf("Invalid block from peer %s: %v", peer, err)
+			continue
+		}
+		if blockMsg.From == "" {
+			blockMsg.From = peer
+		}
+		bs.processBlock(blockMsg, remote)
+		return
+	}
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	maxOrphanTxs    = 100              // Maximum number of transactions waiting for their parents
+	maxOrphanTxSize = 100_000          // Larger orphans are refused: they could fill the pool with junk
+	orphanTxTTL     = 20 * time.Minute // Orphans older than this are discarded
+)
+
+// missingParentsError is returned by acceptToMempool for a transaction
+// spending outputs of transactions we do not know
+type missingParentsError struct {
+	parents []string
+}
+
+func (e *missingParentsError) Error() string {
+	return fmt.Sprintf("missing parent transaction(s) %s", strings.Join(e.parents, ", "))
+}
+
+// orphanTx is a transaction whose parents we have not seen yet, for instance
+// a child relayed before its parent
+type orphanTx struct {
+	tx       Tx
+	id       string
+	parents  []string // Missing parents it waits for
+	received time.Time
+}
+
+// addOrphanTx keeps a transaction until the parents it spends from arrive.
+// The caller must hold bs.mu.
+func (bs *BlockchainServer) addOrphanTx(tx Tx, parents []string) error {
+	id := tx.ID()
+	if _, ok := bs.orphanTxs[id]; ok {
+		return nil
+	}
+	if size := len(tx.Serialize()); size > maxOrphanTxSize {
+		return fmt.Errorf("orphan transaction too large (%d bytes, limit %d)", size, maxOrphanTxSize)
+	}
+
+	bs.pruneOrphanTxs()
+
+	// Make room by evicting the oldest orphan
+	if len(bs.orphanTxs) >= maxOrphanTxs {
+		var oldest *orphanTx
+		for _, o := range bs.orphanTxs {
+			if oldest == nil || o.received.Before(oldest.received) {
+				oldest = o
+			}
+		}
+		bs.removeOrphanTx(oldest)
+	}
+
+	orphan := &orphanTx{tx: tx, id: id, parents: parents, received: time.Now()}
+	bs.orphanTxs[id] = orphan
+	for _, parent := range parents {
+		bs.orphanTxsByPrev[parent] = append(bs.orphanTxsByPrev[parent], id)
+	}
+	return nil
+}
+
+// removeOrphanTx deletes an orphan from both indexes. The caller must hold bs.mu.
+func (bs *BlockchainServer) removeOrphanTx(orphan *orphanTx) {
+	delete(bs.orphanTxs, orphan.id)
+	for _, parent := range orphan.parents {
+		siblings := bs.orphanTxsByPrev[parent]
+		for i, id := range siblings {
+			if id == orphan.id {
+				siblings = append(siblings[:i], siblings[i+1:]...)
+				break
+			}
+		}
+		if len(siblings) == 0 {
+			delete(bs.orphanTxsByPrev, parent)
+		} else {
+			bs.orphanTxsByPrev[parent] = siblings
+		}
+	}
+}
+
+// pruneOrphanTxs discards orphans that waited too long for their parents
+func (bs *BlockchainServer) pruneOrphanTxs() {
+	for _, o := range bs.orphanTxs {
+		if time.Since(o.received) > orphanTxTTL {
+			fmt.Printf("🗑️  Orphan transaction %s expired\n", o.id)
+			bs.removeOrphanTx(o)
+		}
+	}
+}
+
+// processOrphanTxs retries, recursively, the orphans spending outputs of the
+// transactions ids, which just entered the mempool or the active chain. An
+// orphan still missing another parent goes back to the pool. It returns the
+// IDs of the orphans accepted, parents first. The caller must hold bs.mu.
+func (bs *BlockchainServer) processOrphanTxs(ids []string) []string {
+	var accepted []string
+	queue := append([]string(nil), ids...)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, id := range append([]string(nil), bs.orphanTxsByPrev[parent]...) {
+			orphan, ok := bs.orphanTxs[id]
+			if !ok {
+				continue
+			}
+			bs.removeOrphanTx(orphan)
+
+			fee, err := bs.acceptToMempool(orphan.tx)
+			if missing, ok := err.(*missingParentsError); ok {
+				bs.addOrphanTx(orphan.tx, missing.parents)
+				continue
+			}
+			if err != nil {
+				fmt.Printf("❌ Orphan transaction %s rejected: %v\n", id, err)
+				continue
+			}
+			fmt.Printf("🧩 Orphan transaction %s added to mempool (fee %d)\n", id, fee)
+			accepted = append(accepted, id)
+			queue = append(queue, id)
+		}
+	}
+	return accepted
+}
+
+// OrphanTxCount returns the number of transactions waiting for their parents
+func (bs *BlockchainServer) OrphanTxCount() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.orphanTxs)
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"math/big"
+	"time"
+)
+
+// ChainParams groups the consensus parameters of a network. Every node of a
+// network must use the same values, otherwise they will reject each other's
+// blocks.
+type ChainParams struct {
+	InitialBits        uint64        // Difficulty (leading zero bits) of the genesis block
+	MinBits            uint64        // Lowest difficulty retargeting can reach
+	MaxBits            uint64        // Highest difficulty retargeting can reach
+	RetargetInterval   uint64        // Difficulty is adjusted every RetargetInterval blocks
+	TargetBlockTime    time.Duration // Desired average time between blocks
+	MaxBitsAdjustment  uint64        // Maximum change of Bits per retarget (each bit doubles the work)
+	MaxFutureBlockTime time.Duration // How far ahead of network-adjusted time a block timestamp may be
+	InitialSubsidy     uint64        // Coins created by each block before the first halving
+	HalvingInterval    uint64        // The subsidy halves every HalvingInterval blocks
+}
+
+// DefaultChainParams are the parameters used by NewBlockchainServer
+var DefaultChainParams = ChainParams{
+	InitialBits:        12,
+	MinBits:            1,
+	MaxBits:            255,
+	RetargetInterval:   10,
+	TargetBlockTime:    30 * time.Second,
+	MaxBitsAdjustment:  2,
+	MaxFutureBlockTime: 2 * time.Hour,
+	InitialSubsidy:     50_000,
+	HalvingInterval:    1_000,
+}
+
+// nextBits returns the difficulty required for the block built on top of
+// parent (nil for the genesis block).
+//
+// Every RetargetInterval blocks the time it took to mine the last interval is
+// compared with the target. Since Bits counts leading zero bits, the work of a
+// block is 2^Bits and the adjustment is log2(expected/actual), rounded and
+// clamped to MaxBitsAdjustment. It is a consensus rule, so it only uses
+// integer arithmetic: every node must get the same result.
+func (p ChainParams) nextBits(parent *blockNode) uint64 {
+	if parent == nil {
+		return p.InitialBits
+	}
+
+	height := parent.height + 1
+	if p.RetargetInterval == 0 || height%p.RetargetInterval != 0 {
+		return parent.block.Bits
+	}
+
+	// First block of the interval that just ended
+	first := parent
+	for i := uint64(0); i < p.RetargetInterval && first.parent != nil; i++ {
+		first = first.parent
+	}
+
+	intervals := parent.height - first.height
+	expected := new(big.Int).SetUint64(intervals * uint64(p.TargetBlockTime/time.Second))
+	actual := big.NewInt(1)
+	if parent.block.Timestamp > first.block.Timestamp {
+		actual.SetUint64(parent.block.Timestamp - first.block.Timestamp)
+	}
+
+	bits := parent.block.Bits
+	if up := roundedLog2Ratio(expected, actual, p.MaxBitsAdjustment); up > 0 {
+		bits += up
+	} else if down := roundedLog2Ratio(actual, expected, p.MaxBitsAdjustment); down > 0 {
+		if down > bits {
+			down = bits
+		}
+		bits -= down
+	}
+	if bits < p.MinBits {
+		bits = p.MinBits
+	}
+	if bits > p.MaxBits {
+		bits = p.MaxBits
+	}
+	return bits
+}
+
+// roundedLog2Ratio returns log2(a/b) rounded to the nearest integer, or 0 if
+// a is not larger than b, capped at limit. The result is k when a lies in
+// [b*2^(k-1/2), b*2^(k+1/2)), which compares a^2 with b^2*2^(2k-1) and
+// b^2*2^(2k+1) to stay exact.
+func roundedLog2Ratio(a, b *big.Int, limit uint64) uint64 {
+	if b.Sign() <= 0 {
+		return limit
+	}
+	a2 := new(big.Int).Mul(a, a)
+	b2 := new(big.Int).Mul(b, b)
+	var k uint64
+	for k < limit && a2.Cmp(new(big.Int).Lsh(b2, uint(2*k+1))) >= 0 {
+		k++
+	}
+	return k
+}
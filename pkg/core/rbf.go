@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Replace-by-fee, after Bitcoin's BIP125.
+//
+// A transaction stuck in the mempool with too low a fee can be replaced by
+// another spending the same outputs and paying more, as long as the original
+// opted in. Otherwise the first transaction seen wins, so that a merchant
+// accepting unconfirmed payments knows they will not be replaced.
+
+const (
+	// SequenceReplaceable in the Sequence of any input opts a transaction in
+	// to replacement. BIP125 accepts every value up to this one, but here
+	// inputs default to 0 and replacement would no longer be opt-in. It has
+	// SequenceLockTimeDisabled set, so the input has no relative lock, and it
+	// is not SequenceFinal, so the LockTime of the transaction still applies.
+	SequenceReplaceable uint32 = 0xfffffffd
+
+	// maxReplacedTxs is the most mempool transactions, descendants included,
+	// a single replacement may evict
+	maxReplacedTxs = 100
+)
+
+// SignalsReplacement reports whether tx may be replaced in the mempool by a
+// conflicting transaction paying a higher fee
+func (tx *Tx) SignalsReplacement() bool {
+	for _, in := range tx.TxIns {
+		if in.Sequence == SequenceReplaceable {
+			return true
+		}
+	}
+	return false
+}
+
+// replacedBy returns the mempool transactions tx would replace: those
+// spending the same outputs, and their descendants. Every transaction it
+// conflicts with directly must have opted in to replacement.
+func (mp *Mempool) replacedBy(tx *Tx) (map[string]bool, error) {
+	replaced := make(map[string]bool)
+	for _, in := range tx.TxIns {
+		id, ok := mp.spentBy[outpointKey(in.PrevTx, in.PrevIndex)]
+		if !ok || replaced[id] {
+			continue
+		}
+		if !mp.entries[id].Tx.SignalsReplacement() {
+			return nil, fmt.Errorf("transaction conflicts with mempool transaction %s, which is not replaceable", id)
+		}
+		for d := range mp.descendants(id) {
+			replaced[d] = true
+		}
+	}
+	if len(replaced) > maxReplacedTxs {
+		return nil, fmt.Errorf("replacement would evict %d transactions, limit %d", len(replaced), maxReplacedTxs)
+	}
+	return replaced, nil
+}
+
+// checkReplacement verifies that tx, paying fee, pays for the transactions it
+// replaces: more in total than all of them together, so the network is paid
+// for relaying them, and more per byte than each one it conflicts with, so
+// that it is mined sooner.
+func (mp *Mempool) checkReplacement(tx *Tx, fee uint64, replaced map[string]bool) error {
+	if len(replaced) == 0 {
+		return nil
+	}
+	size := len(tx.Serialize())
+	var replacedFees uint64
+	for id := range replaced {
+		replacedFees += mp.entries[id].Fee
+	}
+	if fee <= replacedFees {
+		return fmt.Errorf("replacement fee %d is not higher than the %d paid by the %d transaction(s) it replaces", fee, replacedFees, len(replaced))
+	}
+	for _, in := range tx.TxIns {
+		id, ok := mp.spentBy[outpointKey(in.PrevTx, in.PrevIndex)]
+		if !ok {
+			continue
+		}
+		if original := mp.entries[id]; compareFeeRates(fee, size, original.Fee, original.Size) <= 0 {
+			return fmt.Errorf("replacement fee-rate %.2f is not higher than the %.2f of %s", float64(fee)/float64(size), original.FeeRate(), id)
+		}
+	}
+	return nil
+}
+
+// removeAll drops the given transactions and returns their entries in
+// arrival order
+func (mp *Mempool) removeAll(ids map[string]bool) []*MempoolEntry {
+	removed := make([]*MempoolEntry, 0, len(ids))
+	for id := range ids {
+		removed = append(removed, mp.entries[id])
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].seq < removed[j].seq })
+	for _, e := range removed {
+		mp.remove(e.ID)
+	}
+	return removed
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RejectCode identifies why a block was refused. It is sent back to the peer
+// in the ProcessBlockMessage response.
+type RejectCode string
+
+const (
+	RejectDuplicate      RejectCode = "duplicate"                // Block already known
+	RejectInvalidChain   RejectCode = "invalid-chain"            // Block (or its parent) was marked invalid
+	RejectBadGenesis     RejectCode = "bad-genesis"              // Second genesis block
+	RejectBadPrevBlock   RejectCode = "bad-prevblk"              // Parent unknown
+	RejectBadDiffBits    RejectCode = "bad-diffbits"             // Bits differ from the retargeting rule
+	RejectHighHash       RejectCode = "high-hash"                // Proof of Work does not meet Bits
+	RejectBadMerkleRoot  RejectCode = "bad-txnmrklroot"          // Header does not commit to the transactions
+	RejectBadWitnessRoot RejectCode = "bad-witness-merkle-match" // Header does not commit to the unlocking scripts
+	RejectTimeTooOld     RejectCode = "time-too-old"             // Timestamp not above median-time-past
+	RejectTimeTooNew     RejectCode = "time-too-new"             // Timestamp too far in the future
+	RejectBadTxns        RejectCode = "bad-txns"                 // A transaction failed validation
+	RejectBadCoinbase    RejectCode = "bad-cb"                   // Missing, duplicated or overpaying coinbase
+	RejectInternal       RejectCode = "internal"                 // Local failure, not the block's fault
+)
+
+// BlockRejectError is returned by block validation with a reason code
+type BlockRejectError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func (e *BlockRejectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+func rejectBlock(code RejectCode, format string, args ...any) error {
+	return &BlockRejectError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// rejectCodeOf extracts the reason code of a validation error
+func rejectCodeOf(err error) RejectCode {
+	var rejectErr *BlockRejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr.Code
+	}
+	return RejectInternal
+}
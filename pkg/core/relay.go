@@ -0,0 +1,224 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Transaction relay, in the inv/getdata style of Bitcoin.
+//
+// A node that accepts a transaction into its mempool announces its ID to its
+// peers with INV. Each peer answers with GETDATA, listing the IDs it does not
+// have yet, and the node sends those transactions on the same connection. The
+// node remembers which IDs each peer already knows, because it announced them
+// or got them from it, so a transaction is never sent back to where it came
+// from and does not bounce around the network forever.
+//
+// Both directions must agree on who a peer is. We reach a peer at its
+// configured address, while it reaches us from an ephemeral port and only
+// declares its listening address, which may be spelled differently or wrong.
+// A peer is therefore identified by its peerID: the IP it connects from (or
+// its configured host, resolved) and the port it listens on.
+
+// maxKnownInventory is how many transaction IDs we remember per peer
+const maxKnownInventory = 10_000
+
+// configuredPeerID returns the peerID of a peer server address, resolving its
+// host
+func configuredPeerID(addr string) string {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return addr
+	}
+	return tcpAddr.String()
+}
+
+// senderID returns the peerID of the sender of a message received from
+// remote, which declared from as its listening address. It returns "" for
+// local clients and senders that declared no address.
+func senderID(remote net.Addr, from string) string {
+	host := remoteHost(remote)
+	_, port, err := net.SplitHostPort(from)
+	if host == "" || err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// InvMessage announces transactions by ID
+type InvMessage struct {
+	TxIDs []string `json:"txids"`
+	From  string   `json:"from,omitempty"` // Listening address of the sender
+}
+
+// GetDataMessage is the answer to an InvMessage: the IDs the peer wants
+type GetDataMessage struct {
+	TxIDs []string `json:"txids"`
+}
+
+// knownInventory is the set of transaction IDs a peer knows about. The oldest
+// are forgotten beyond maxKnownInventory.
+type knownInventory struct {
+	ids   map[string]bool
+	order []string
+}
+
+func (k *knownInventory) add(id string) {
+	if k.ids[id] {
+		return
+	}
+	if len(k.order) >= maxKnownInventory {
+		delete(k.ids, k.order[0])
+		k.order = k.order[1:]
+	}
+	k.ids[id] = true
+	k.order = append(k.order, id)
+}
+
+// peerKnows records that the peer with the given peerID knows the
+// transaction id. The caller must hold bs.mu.
+func (bs *BlockchainServer) peerKnows(peer, id string) {
+	inv, ok := bs.peerInventory[peer]
+	if !ok {
+		inv = &knownInventory{ids: make(map[string]bool)}
+		bs.peerInventory[peer] = inv
+	}
+	inv.add(id)
+}
+
+// haveTransaction reports whether we already have the transaction id, in the
+// mempool, waiting for its parents or in the active chain. The caller must
+// hold bs.mu.
+func (bs *BlockchainServer) haveTransaction(id string) bool {
+	if _, ok := bs.orphanTxs[id]; ok {
+		return true
+	}
+	_, confirmed := bs.txHeights[id]
+	return confirmed || bs.mempool.Has(id)
+}
+
+// ProcessInvMessage answers the announcement of a peer with a GETDATA listing
+// the transactions we lack
+func (bs *BlockchainServer) ProcessInvMessage(invJSON string) string {
+	return bs.processInvMessage(nil, invJSON)
+}
+
+// processInvMessage answers an announcement received from remote
+func (bs *BlockchainServer) processInvMessage(remote net.Addr, invJSON string) string {
+	var inv InvMessage
+	if err := json.Unmarshal([]byte(invJSON), &inv); err != nil {
+		return fmt.Sprintf("ERROR: Invalid inv message JSON: %v", err)
+	}
+
+	peer := senderID(remote, inv.From)
+	bs.mu.Lock()
+	wanted := make([]string, 0, len(inv.TxIDs))
+	requested := make(map[string]bool)
+	for _, id := range inv.TxIDs {
+		id = strings.ToLower(id)
+		if peer != "" {
+			bs.peerKnows(peer, id)
+		}
+		if !bs.haveTransaction(id) && !requested[id] {
+			wanted = append(wanted, id)
+			requested[id] = true
+		}
+	}
+	bs.mu.Unlock()
+
+	data, err := json.Marshal(GetDataMessage{TxIDs: wanted})
+	if err != nil {
+		return fmt.Sprintf("ERROR: Failed to marshal getdata: %v", err)
+	}
+	return "GETDATA:" + string(data)
+}
+
+// relayTransactions announces the transactions ids, just accepted into the
+// mempool, to every peer that does not know them yet
+func (bs *BlockchainServer) relayTransactions(ids []string) {
+	bs.mu.Lock()
+	from := bs.listenAddr
+	announce := make(map[string][]string)
+	for _, peer := range bs.peerServers {
+		peerID := bs.peerIDs[peer]
+		for _, id := range ids {
+			if inv, ok := bs.peerInventory[peerID]; ok && inv.ids[id] {
+				continue
+			}
+			bs.peerKnows(peerID, id)
+			announce[peer] = append(announce[peer], id)
+		}
+	}
+	bs.mu.Unlock()
+
+	for peer, ids := range announce {
+		go bs.sendInv(peer, from, ids)
+	}
+}
+
+// sendInv announces ids to peer and sends the transactions it asks for
+func (bs *BlockchainServer) sendInv(peer, from string, ids []string) {
+	conn, err := net.DialTimeout("tcp", peer, 5*time.Second)
+	if err != nil {
+		log.Printf("Failed to connect to peer %s: %v", peer, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	invJSON, err := json.Marshal(InvMessage{TxIDs: ids, From: from})
+	if err != nil {
+		log.Printf("Error marshaling inv: %v", err)
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "INV:%s\n", invJSON); err != nil {
+		log.Printf("Failed to announce transactions to peer %s: %v", peer, err)
+		return
+	}
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		log.Printf("Failed to read getdata from peer %s: %v", peer, err)
+		return
+	}
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, "GETDATA:") {
+		log.Printf("Peer %s did not answer our inv: %s", peer, response)
+		return
+	}
+	var getData GetDataMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(response, "GETDATA:")), &getData); err != nil {
+		log.Printf("Invalid getdata from peer %s: %v", peer, err)
+		return
+	}
+
+	sent := 0
+	for _, id := range getData.TxIDs {
+		bs.mu.Lock()
+		entry, ok := bs.mempool.entries[id]
+		bs.mu.Unlock()
+		if !ok {
+			continue // Mined or replaced since we announced it
+		}
+		txJSON, err := json.Marshal(TransactionMessage{Transaction: entry.Tx, From: from})
+		if err != nil {
+			log.Printf("Error marshaling transaction: %v", err)
+			return
+		}
+		if _, err := fmt.Fprintf(conn, "TRANSACTION:%s\n", txJSON); err != nil {
+			log.Printf("Failed to send transaction to peer %s: %v", peer, err)
+			return
+		}
+		if _, err := reader.ReadString('\n'); err != nil {
+			log.Printf("Failed to read answer of peer %s: %v", peer, err)
+			return
+		}
+		sent++
+	}
+	fmt.Printf("📡 Announced %d transaction(s) to peer %s, sent the %d it asked for\n", len(ids), peer, sent)
+}
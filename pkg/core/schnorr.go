@@ -0,0 +1,189 @@
+package core
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xkal1bur/blockchain/pkg/crypto"
+)
+
+// SigType selects the signature scheme of an input. Like the key type it is
+// part of the input (TxIn.SigType) and the signature hash commits to it.
+type SigType byte
+
+const (
+	SigTypeECDSA   SigType = 0 // ECDSA r||s with low S
+	SigTypeSchnorr SigType = 1 // BIP-340 style Schnorr R.x||s over the key type's curve
+)
+
+// Valid reports whether s is a supported signature type
+func (s SigType) Valid() bool {
+	return s == SigTypeECDSA || s == SigTypeSchnorr
+}
+
+func (s SigType) String() string {
+	switch s {
+	case SigTypeECDSA:
+		return "ecdsa"
+	case SigTypeSchnorr:
+		return "schnorr"
+	}
+	return fmt.Sprintf("SigType(%d)", byte(s))
+}
+
+// ParseSigType returns the signature type named s ("ecdsa" or "schnorr"). An
+// empty name is ECDSA, the type of wallets saved before Schnorr existed.
+func ParseSigType(s string) (SigType, error) {
+	switch s {
+	case "", "ecdsa":
+		return SigTypeECDSA, nil
+	case "schnorr":
+		return SigTypeSchnorr, nil
+	}
+	return 0, fmt.Errorf("unknown signature type %q", s)
+}
+
+// xOnlyKey returns the x coordinate of a compressed or uncompressed public
+// key, the form Schnorr keys take. Scripts keep pushing the full key, so the same P2PKH
+// output can be spent with either signature type.
+func xOnlyKey(pubKey []byte) []byte {
+	return pubKey[1:33]
+}
+
+// sigBatch collects the Schnorr signatures checked by OP_CHECKSIG while
+// validating a block, grouped by curve, so they are verified together with
+// one multi-scalar multiplication per curve (crypto.BatchVerifySchnorr)
+type sigBatch struct {
+	pubs, msgs, sigs map[KeyType][][]byte
+}
+
+func newSigBatch() *sigBatch {
+	return &sigBatch{
+		pubs: map[KeyType][][]byte{},
+		msgs: map[KeyType][][]byte{},
+		sigs: map[KeyType][][]byte{},
+	}
+}
+
+func (b *sigBatch) add(keyType KeyType, pub, msg, sig []byte) {
+	b.pubs[keyType] = append(b.pubs[keyType], pub)
+	b.msgs[keyType] = append(b.msgs[keyType], msg)
+	b.sigs[keyType] = append(b.sigs[keyType], sig)
+}
+
+// verify reports whether every collected signature is valid
+func (b *sigBatch) verify() error {
+	for keyType, pubs := range b.pubs {
+		curve, err := keyType.Curve()
+		if err != nil {
+			return err
+		}
+		if !crypto.BatchVerifySchnorr(curve, pubs, b.msgs[keyType], b.sigs[keyType]) {
+			return fmt.Errorf("batch verification of %d %s Schnorr signatures failed", len(pubs), keyType)
+		}
+	}
+	return nil
+}
+
+// SignSchnorr signs data with a Schnorr signature (R.x||s). Without
+// NonceEntropy the signature is deterministic.
+func (w *Wallet) SignSchnorr(data []byte) ([]byte, error) {
+	privateKey, err := w.GetECDSAPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get private key: %v", err)
+	}
+	var aux []byte
+	if w.NonceEntropy != nil {
+		aux = make([]byte, 32)
+		if _, err := io.ReadFull(w.NonceEntropy, aux); err != nil {
+			return nil, fmt.Errorf("failed to read nonce entropy: %v", err)
+		}
+	}
+	return crypto.SignSchnorr(privateKey.Curve, privateKey.D, data, aux)
+}
+
+// sign signs a signature hash with the wallet's signature type
+func (w *Wallet) sign(hash []byte) ([]byte, error) {
+	if w.SigType == SigTypeSchnorr {
+		return w.SignSchnorr(hash)
+	}
+	return w.SignECDSA(hash)
+}
+
+// KeyAggregation combines the public keys of several signers into a single
+// Schnorr key. An output paying to PubKey (an ordinary P2PKH) is spent by a
+// Schnorr input with one signature that all of them build together:
+//
+//  1. every signer draws a nonce with NewNonce and shares its Public part
+//  2. with all the public nonces, every signer calls PartialSign
+//  3. anyone joins the partial signatures with CombineSignatures
+//
+// On chain it looks like any other single-key spend.
+type KeyAggregation struct {
+	KeyType KeyType
+	agg     *crypto.SchnorrKeyAgg
+}
+
+// AggregateKeys aggregates public keys of the given key type. Every signer
+// must use the same keys in the same order.
+func AggregateKeys(keyType KeyType, pubKeys [][]byte) (*KeyAggregation, error) {
+	curve, err := keyType.Curve()
+	if err != nil {
+		return nil, err
+	}
+	xOnly := make([][]byte, len(pubKeys))
+	for i, pub := range pubKeys {
+		if _, err := ParsePubKey(pub, keyType); err != nil {
+			return nil, fmt.Errorf("public key %d: %v", i, err)
+		}
+		xOnly[i] = xOnlyKey(pub)
+	}
+	agg, err := crypto.AggregateSchnorrKeys(curve, xOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyAggregation{KeyType: keyType, agg: agg}, nil
+}
+
+// PubKey returns the aggregated public key, compressed
+func (k *KeyAggregation) PubKey() []byte {
+	return crypto.CompressPoint(k.agg.Point())
+}
+
+// LockingScript returns the P2PKH locking script paying to the aggregated key
+func (k *KeyAggregation) LockingScript() []byte {
+	return PayToPubKeyHashScript(HashSHA3(k.PubKey()))
+}
+
+// NewNonce draws a fresh nonce for one signature. It must not be reused.
+func (k *KeyAggregation) NewNonce() (*crypto.SchnorrNonce, error) {
+	curve, err := k.KeyType.Curve()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewSchnorrNonce(curve, rand.Reader)
+}
+
+// PartialSign returns w's share of the signature of hash, given its own
+// nonce and the public nonces of every signer
+func (k *KeyAggregation) PartialSign(w *Wallet, nonce *crypto.SchnorrNonce, nonces [][]byte, hash []byte) ([]byte, error) {
+	if w.KeyType != k.KeyType {
+		return nil, fmt.Errorf("wallet has %s keys, aggregation uses %s", w.KeyType, k.KeyType)
+	}
+	privateKey, err := w.GetECDSAPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return k.agg.PartialSign(privateKey.D, nonce, nonces, hash)
+}
+
+// CombineSignatures joins the partial signatures of every signer into the
+// Schnorr signature of the aggregated key
+func (k *KeyAggregation) CombineSignatures(nonces, partials [][]byte) ([]byte, error) {
+	if len(partials) != len(nonces) {
+		return nil, errors.New("need one partial signature per nonce")
+	}
+	return k.agg.CombineSignatures(nonces, partials)
+}
@@ -0,0 +1,815 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/xkal1bur/blockchain/pkg/crypto"
+)
+
+// Small stack-based script language used to lock and unlock outputs.
+//
+// An output is locked by TxOut.LockingScript and spent by TxIn.UnlockingScript.
+// To validate an input the unlocking script (which may only push data) is run
+// first, then the locking script runs on the resulting stack. The input is
+// valid if no operation fails and the top of the stack is true at the end.
+
+// Resource limits, checked while parsing and executing
+const (
+	MaxScriptSize         = 10_000 // Bytes per script
+	MaxScriptElementSize  = 520    // Bytes per pushed element
+	MaxOpsPerScript       = 201    // Non-push operations per script
+	MaxStackSize          = 1_000  // Elements on the stack
+	MaxPubKeysPerMultisig = 20     // Keys in a CHECKMULTISIG
+	maxScriptNumLen       = 4      // Bytes of a number operand
+	maxLockTimeNumLen     = 5      // Bytes of a timelock operand, which can exceed 2^31
+)
+
+var (
+	ErrScriptFailed = errors.New("script evaluated to false")
+	errStackEmpty   = errors.New("stack underflow")
+)
+
+// scriptOp is a parsed operation: an opcode and, for pushes, its data
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+// parseScript splits a script into operations
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > MaxScriptSize {
+		return nil, fmt.Errorf("script size %d exceeds %d", len(script), MaxScriptSize)
+	}
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var n int
+		switch {
+		case op >= OP_DATA_1 && op <= OP_DATA_75:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA1")
+			}
+			n = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA2")
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, scriptOp{opcode: op})
+			continue
+		}
+
+		if i+n > len(script) {
+			return nil, fmt.Errorf("push of %d bytes past end of script", n)
+		}
+		ops = append(ops, scriptOp{opcode: op, data: script[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+// isPush reports whether op only pushes data (including the small numbers).
+// OP_PUSHDATA4 (0x4e) is not supported: no element is big enough to need it.
+func (op scriptOp) isPush() bool {
+	return op.opcode <= OP_PUSHDATA2 || op.opcode == OP_1NEGATE || (op.opcode >= OP_1 && op.opcode <= OP_16)
+}
+
+// isMinimalPush reports whether op is the shortest way to push its data, so a
+// third party cannot re-encode an unlocking script
+func (op scriptOp) isMinimalPush() bool {
+	if op.opcode == OP_1NEGATE || (op.opcode >= OP_1 && op.opcode <= OP_16) {
+		return true
+	}
+	n := len(op.data)
+	switch {
+	case n == 0:
+		return op.opcode == OP_0
+	case n == 1 && op.data[0] >= 1 && op.data[0] <= 16:
+		return op.opcode == OP_1+op.data[0]-1
+	case n == 1 && op.data[0] == 0x81:
+		return op.opcode == OP_1NEGATE
+	case n <= OP_DATA_75:
+		return int(op.opcode) == n
+	case n <= 0xff:
+		return op.opcode == OP_PUSHDATA1
+	}
+	return op.opcode == OP_PUSHDATA2
+}
+
+// pushedData returns what a push operation leaves on the stack
+func (op scriptOp) pushedData() []byte {
+	switch {
+	case op.opcode == OP_1NEGATE:
+		return []byte{0x81}
+	case op.opcode >= OP_1 && op.opcode <= OP_16:
+		return []byte{op.opcode - OP_1 + 1}
+	}
+	return op.data
+}
+
+// IsPushOnly reports whether script only pushes data
+func IsPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// DisasmScript returns a human readable form of script
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %v]", err)
+	}
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.opcode >= OP_1 && op.opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.opcode-OP_1+1))
+		case op.isPush() && op.opcode != OP_0 && op.opcode != OP_1NEGATE:
+			parts = append(parts, hex.EncodeToString(op.data))
+		default:
+			parts = append(parts, opcodeName(op.opcode))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func opcodeName(op byte) string {
+	if op >= OP_DATA_1 && op <= OP_DATA_75 {
+		return fmt.Sprintf("OP_DATA_%d", op)
+	}
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN_%02x", op)
+}
+
+// ------------------------------------------------------
+// Numbers are little endian sign-magnitude byte arrays, as in Bitcoin.
+
+// scriptNum decodes a number operand, which must be minimally encoded
+func scriptNum(b []byte, maxLen int) (int64, error) {
+	if len(b) > maxLen {
+		return 0, fmt.Errorf("number of %d bytes exceeds %d", len(b), maxLen)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	// The last byte may only be 0x00/0x80 if the previous one needs its sign bit
+	if b[len(b)-1]&0x7f == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+		return 0, errors.New("non-minimally encoded number")
+	}
+	var v int64
+	for i, c := range b {
+		v |= int64(c) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		v &^= int64(0x80) << (8 * (len(b) - 1))
+		return -v, nil
+	}
+	return v, nil
+}
+
+// encodeScriptNum returns the minimal encoding of v
+func encodeScriptNum(v int64) []byte {
+	if v == 0 {
+		return nil
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var b []byte
+	for v > 0 {
+		b = append(b, byte(v&0xff))
+		v >>= 8
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		if neg {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0x00)
+		}
+	} else if neg {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// asBool interprets a stack element: false is any encoding of zero
+func asBool(b []byte) bool {
+	for i, c := range b {
+		if c != 0 {
+			// Negative zero is false too
+			return !(i == len(b)-1 && c == 0x80)
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// ------------------------------------------------------
+
+// scriptEngine runs the scripts of input idx of tx, which spends prevOut
+type scriptEngine struct {
+	tx      *Tx
+	idx     int
+	prevOut TxOut
+
+	stack     [][]byte
+	condStack []bool // One entry per open IF: whether its branch is executed
+	opCount   int
+
+	// batch, if set, collects the Schnorr signatures of OP_CHECKSIG to be
+	// verified later together with the rest of the block
+	batch *sigBatch
+}
+
+// VerifyScript checks that the unlocking script of input idx of tx satisfies
+// the locking script of prevOut, the output it spends
+func VerifyScript(tx *Tx, idx int, prevOut TxOut) error {
+	return verifyScript(tx, idx, prevOut, nil)
+}
+
+// verifyScript is VerifyScript, deferring Schnorr OP_CHECKSIG signatures to
+// batch if it is not nil
+func verifyScript(tx *Tx, idx int, prevOut TxOut, batch *sigBatch) error {
+	if idx < 0 || idx >= len(tx.TxIns) {
+		return fmt.Errorf("input index %d out of range", idx)
+	}
+	unlocking := tx.TxIns[idx].UnlockingScript
+	if !IsPushOnly(unlocking) {
+		return errors.New("unlocking script must only push data")
+	}
+
+	e := &scriptEngine{tx: tx, idx: idx, prevOut: prevOut, batch: batch}
+	if err := e.run(unlocking); err != nil {
+		return fmt.Errorf("unlocking script: %v", err)
+	}
+	// Keep what the unlocking script pushed in case this is a P2SH output
+	pushed := append([][]byte(nil), e.stack...)
+
+	if err := e.run(prevOut.LockingScript); err != nil {
+		return fmt.Errorf("locking script: %v", err)
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFailed
+	}
+
+	// Pay-to-script-hash: the locking script only checked the hash of the last
+	// pushed element. That element is the redeem script, which now runs on the
+	// rest of the pushed data.
+	if IsPayToScriptHash(prevOut.LockingScript) {
+		if len(pushed) == 0 {
+			return errors.New("P2SH: missing redeem script")
+		}
+		redeem := pushed[len(pushed)-1]
+		e.stack = pushed[:len(pushed)-1]
+		if err := e.run(redeem); err != nil {
+			return fmt.Errorf("redeem script: %v", err)
+		}
+		if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+			return ErrScriptFailed
+		}
+	}
+	return nil
+}
+
+func (e *scriptEngine) executing() bool {
+	for _, c := range e.condStack {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *scriptEngine) push(b []byte) {
+	e.stack = append(e.stack, b)
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, errStackEmpty
+	}
+	b := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return b, nil
+}
+
+// peek returns the element n positions below the top (0 is the top)
+func (e *scriptEngine) peek(n int) ([]byte, error) {
+	if n >= len(e.stack) {
+		return nil, errStackEmpty
+	}
+	return e.stack[len(e.stack)-1-n], nil
+}
+
+func (e *scriptEngine) popNum() (int64, error) {
+	b, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return scriptNum(b, maxScriptNumLen)
+}
+
+func (e *scriptEngine) popBool() (bool, error) {
+	b, err := e.pop()
+	if err != nil {
+		return false, err
+	}
+	return asBool(b), nil
+}
+
+// run executes one script on the current stack
+func (e *scriptEngine) run(script []byte) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+	e.opCount = 0
+	e.condStack = e.condStack[:0]
+
+	for pc, op := range ops {
+		if len(op.data) > MaxScriptElementSize {
+			return fmt.Errorf("push of %d bytes exceeds %d", len(op.data), MaxScriptElementSize)
+		}
+		if !op.isPush() {
+			e.opCount++
+			if e.opCount > MaxOpsPerScript {
+				return fmt.Errorf("more than %d operations", MaxOpsPerScript)
+			}
+		}
+
+		// Skip operations in a branch not taken, except those that change branches
+		if !e.executing() && (op.opcode < OP_IF || op.opcode > OP_ENDIF) {
+			continue
+		}
+
+		if err := e.step(op); err != nil {
+			return fmt.Errorf("op #%d (%s): %v", pc, opcodeName(op.opcode), err)
+		}
+		if len(e.stack) > MaxStackSize {
+			return fmt.Errorf("stack size exceeds %d", MaxStackSize)
+		}
+	}
+	if len(e.condStack) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+// step executes a single operation
+func (e *scriptEngine) step(op scriptOp) error {
+	if op.isPush() {
+		if !op.isMinimalPush() {
+			return errors.New("non-minimal push")
+		}
+		e.push(op.pushedData())
+		return nil
+	}
+
+	switch op.opcode {
+	// Flow control
+	case OP_NOP:
+	case OP_IF, OP_NOTIF:
+		taken := false
+		if e.executing() {
+			v, err := e.popBool()
+			if err != nil {
+				return err
+			}
+			taken = v == (op.opcode == OP_IF)
+		}
+		e.condStack = append(e.condStack, taken)
+	case OP_ELSE:
+		if len(e.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		// Only flip if the enclosing branches are executed
+		last := len(e.condStack) - 1
+		outer := true
+		for _, c := range e.condStack[:last] {
+			outer = outer && c
+		}
+		if outer {
+			e.condStack[last] = !e.condStack[last]
+		}
+	case OP_ENDIF:
+		if len(e.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		e.condStack = e.condStack[:len(e.condStack)-1]
+	case OP_VERIFY:
+		v, err := e.popBool()
+		if err != nil {
+			return err
+		}
+		if !v {
+			return errors.New("OP_VERIFY failed")
+		}
+	case OP_RETURN:
+		return errors.New("OP_RETURN")
+
+	// Stack
+	case OP_2DROP:
+		if len(e.stack) < 2 {
+			return errStackEmpty
+		}
+		e.stack = e.stack[:len(e.stack)-2]
+	case OP_2DUP:
+		a, err := e.peek(1)
+		if err != nil {
+			return err
+		}
+		b, _ := e.peek(0)
+		e.push(a)
+		e.push(b)
+	case OP_DEPTH:
+		e.push(encodeScriptNum(int64(len(e.stack))))
+	case OP_DROP:
+		if _, err := e.pop(); err != nil {
+			return err
+		}
+	case OP_DUP:
+		b, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		e.push(b)
+	case OP_NIP:
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if _, err := e.pop(); err != nil {
+			return err
+		}
+		e.push(b)
+	case OP_OVER:
+		b, err := e.peek(1)
+		if err != nil {
+			return err
+		}
+		e.push(b)
+	case OP_SWAP:
+		if len(e.stack) < 2 {
+			return errStackEmpty
+		}
+		n := len(e.stack)
+		e.stack[n-1], e.stack[n-2] = e.stack[n-2], e.stack[n-1]
+	case OP_SIZE:
+		b, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		e.push(encodeScriptNum(int64(len(b))))
+
+	// Equality
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		eq := bytes.Equal(a, b)
+		if op.opcode == OP_EQUALVERIFY {
+			if !eq {
+				return errors.New("OP_EQUALVERIFY failed")
+			}
+			return nil
+		}
+		e.push(fromBool(eq))
+
+	// Unary arithmetic
+	case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+		a, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		switch op.opcode {
+		case OP_1ADD:
+			a++
+		case OP_1SUB:
+			a--
+		case OP_NEGATE:
+			a = -a
+		case OP_ABS:
+			if a < 0 {
+				a = -a
+			}
+		case OP_NOT:
+			if a == 0 {
+				a = 1
+			} else {
+				a = 0
+			}
+		case OP_0NOTEQUAL:
+			if a != 0 {
+				a = 1
+			}
+		}
+		e.push(encodeScriptNum(a))
+
+	// Binary arithmetic
+	case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY, OP_NUMNOTEQUAL,
+		OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL, OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+		b, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		a, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		var r int64
+		switch op.opcode {
+		case OP_ADD:
+			r = a + b
+		case OP_SUB:
+			r = a - b
+		case OP_BOOLAND:
+			r = boolNum(a != 0 && b != 0)
+		case OP_BOOLOR:
+			r = boolNum(a != 0 || b != 0)
+		case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+			r = boolNum(a == b)
+		case OP_NUMNOTEQUAL:
+			r = boolNum(a != b)
+		case OP_LESSTHAN:
+			r = boolNum(a < b)
+		case OP_GREATERTHAN:
+			r = boolNum(a > b)
+		case OP_LESSTHANOREQUAL:
+			r = boolNum(a <= b)
+		case OP_GREATERTHANOREQUAL:
+			r = boolNum(a >= b)
+		case OP_MIN:
+			r = min(a, b)
+		case OP_MAX:
+			r = max(a, b)
+		}
+		if op.opcode == OP_NUMEQUALVERIFY {
+			if r == 0 {
+				return errors.New("OP_NUMEQUALVERIFY failed")
+			}
+			return nil
+		}
+		e.push(encodeScriptNum(r))
+	case OP_WITHIN:
+		hi, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		lo, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		x, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		e.push(fromBool(lo <= x && x < hi))
+
+	// Crypto
+	case OP_SHA256:
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(b)
+		e.push(h[:])
+	case OP_SHA3:
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(HashSHA3(b))
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ok, err := e.checkSig(sig, pubKey)
+		if err != nil {
+			return err
+		}
+		if op.opcode == OP_CHECKSIGVERIFY {
+			if !ok {
+				return errors.New("OP_CHECKSIGVERIFY failed")
+			}
+			return nil
+		}
+		e.push(fromBool(ok))
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		ok, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if op.opcode == OP_CHECKMULTISIGVERIFY {
+			if !ok {
+				return errors.New("OP_CHECKMULTISIGVERIFY failed")
+			}
+			return nil
+		}
+		e.push(fromBool(ok))
+
+	// Timelocks
+	case OP_CHECKLOCKTIMEVERIFY, OP_CHECKSEQUENCEVERIFY:
+		b, err := e.peek(0)
+		if err != nil {
+			return err
+		}
+		n, err := scriptNum(b, maxLockTimeNumLen)
+		if err != nil {
+			return err
+		}
+		if op.opcode == OP_CHECKLOCKTIMEVERIFY {
+			return e.checkLockTime(n)
+		}
+		return e.checkSequence(n)
+
+	default:
+		return fmt.Errorf("unknown opcode 0x%02x", op.opcode)
+	}
+	return nil
+}
+
+func boolNum(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// checkSig verifies sig (r||s||hashType or R.x||s||hashType) by pubKey over
+// the signature hash of the input. An empty signature is a valid way to fail;
+// any other signature that does not verify is an error, so a failed check
+// cannot be hidden by a script that expects false. That is also why a
+// Schnorr signature can be left to the block's batch and taken as valid here.
+func (e *scriptEngine) checkSig(sig, pubKey []byte) (bool, error) {
+	if len(sig) == 0 {
+		return false, nil
+	}
+	if err := e.checkLowS(sig); err != nil {
+		return false, err
+	}
+	if e.batch != nil && e.tx.TxIns[e.idx].SigType == SigTypeSchnorr {
+		_, hash, ok := e.sigParts(sig, pubKey)
+		if !ok {
+			return false, errors.New("invalid signature")
+		}
+		e.batch.add(e.tx.TxIns[e.idx].KeyType, xOnlyKey(pubKey), hash, sig[:64])
+		return true, nil
+	}
+	if !e.verifySig(sig, pubKey) {
+		return false, errors.New("invalid signature")
+	}
+	return true, nil
+}
+
+// checkMultiSig pops <sig1..sigM> <M> <pubkey1..pubkeyN> <N> and checks that
+// the signatures match M of the keys, in the same order as the keys
+func (e *scriptEngine) checkMultiSig() (bool, error) {
+	n, err := e.popNum()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > MaxPubKeysPerMultisig {
+		return false, fmt.Errorf("invalid number of public keys %d", n)
+	}
+	e.opCount += int(n)
+	if e.opCount > MaxOpsPerScript {
+		return false, fmt.Errorf("more than %d operations", MaxOpsPerScript)
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := e.popNum()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, fmt.Errorf("invalid number of signatures %d of %d", m, n)
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	// All signatures empty is a valid way to fail
+	allEmpty := true
+	for _, sig := range sigs {
+		allEmpty = allEmpty && len(sig) == 0
+	}
+	if allEmpty && m > 0 {
+		return false, nil
+	}
+
+	// Walk keys and signatures in order; a signature that matches no
+	// remaining key makes the script fail
+	k := 0
+	for _, sig := range sigs {
+		if err := e.checkLowS(sig); err != nil {
+			return false, err
+		}
+		matched := false
+		for k < len(pubKeys) && !matched {
+			matched = e.verifySig(sig, pubKeys[k])
+			k++
+		}
+		if !matched {
+			return false, errors.New("signatures do not match the public keys")
+		}
+	}
+	return true, nil
+}
+
+// checkLowS rejects ECDSA signatures whose S is above n/2. Anyone can turn a
+// valid signature (r, s) into another valid one, (r, n-s); accepting only the
+// low form leaves a single valid encoding per signature. Schnorr signatures
+// have a single valid s already.
+func (e *scriptEngine) checkLowS(sig []byte) error {
+	in := e.tx.TxIns[e.idx]
+	if in.SigType != SigTypeECDSA {
+		return nil
+	}
+	halfOrder := in.KeyType.halfOrder()
+	if len(sig) == 65 && new(big.Int).SetBytes(sig[32:64]).Cmp(halfOrder) > 0 {
+		return errors.New("non-canonical signature: high S value")
+	}
+	return nil
+}
+
+// sigParts parses pubKey, a key of the input's key type, and computes the
+// signature hash selected by the last byte of sig
+func (e *scriptEngine) sigParts(sig, pubKey []byte) (*ecdsa.PublicKey, []byte, bool) {
+	if len(sig) != 65 {
+		return nil, nil, false
+	}
+	key, err := ParsePubKey(pubKey, e.tx.TxIns[e.idx].KeyType)
+	if err != nil {
+		return nil, nil, false
+	}
+	hash, err := e.tx.SignatureHash(e.idx, e.prevOut, SigHashType(sig[64]))
+	if err != nil {
+		return nil, nil, false
+	}
+	return key, hash, true
+}
+
+// verifySig reports whether sig is a valid signature of the input by pubKey,
+// with the input's signature type
+func (e *scriptEngine) verifySig(sig, pubKey []byte) bool {
+	key, hash, ok := e.sigParts(sig, pubKey)
+	if !ok {
+		return false
+	}
+	if e.tx.TxIns[e.idx].SigType == SigTypeSchnorr {
+		return crypto.VerifySchnorr(key.Curve, xOnlyKey(pubKey), hash, sig[:64])
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:64])
+	return ecdsa.Verify(key, hash, r, s)
+}
@@ -0,0 +1,116 @@
+package core
+
+import "fmt"
+
+// SigHashType selects which parts of a transaction an input signature commits
+// to. It is appended to the signature as its last byte.
+type SigHashType byte
+
+const (
+	SigHashAll    SigHashType = 0x01 // Commit to every input and output
+	SigHashNone   SigHashType = 0x02 // Commit to the inputs only, outputs may change
+	SigHashSingle SigHashType = 0x03 // Commit to the output with the same index as the input
+
+	// SigHashAnyoneCanPay may be combined with the modes above: only the
+	// signed input is committed to and anyone can add more inputs
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+// sigHashTag separates signature hashes from any other SHA3 hash in the system
+var sigHashTag = []byte("sighash")
+
+func (t SigHashType) baseType() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+// Valid reports whether t is one of the supported combinations
+func (t SigHashType) Valid() bool {
+	base := t.baseType()
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.baseType() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("SigHashType(0x%02x)", byte(t))
+	}
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// SignatureHash returns the message signed by input idx, which spends
+// prevOut. Unlike GetHashForSigning, the hash is different for every input
+// and commits to the amount and locking script being spent, so a signature
+// cannot be moved to another input or replayed against a different output.
+func (tx *Tx) SignatureHash(idx int, prevOut TxOut, hashType SigHashType) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.TxIns) {
+		return nil, fmt.Errorf("input index %d out of range", idx)
+	}
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("unsupported sighash type 0x%02x", byte(hashType))
+	}
+	base := hashType.baseType()
+	if base == SigHashSingle && idx >= len(tx.TxOuts) {
+		return nil, fmt.Errorf("SIGHASH_SINGLE input %d has no matching output", idx)
+	}
+
+	// The preimage uses the same primitives as the transaction codec
+	e := &encoder{buf: append([]byte(nil), sigHashTag...)}
+	e.uvarint(CodecVersion)
+	e.uvarint(uint64(tx.Version))
+	e.uvarint(uint64(hashType))
+
+	// Inputs: every outpoint, or only ours with ANYONECANPAY. With NONE and
+	// SINGLE the sequence of the other inputs is not committed to, so their
+	// owners can still change it.
+	writeIn := func(i int, in TxIn) {
+		e.bytes(in.PrevTx)
+		e.uvarint(uint64(in.PrevIndex))
+		e.string(in.Net)
+		if i == idx || base == SigHashAll {
+			e.uvarint(uint64(in.Sequence))
+		} else {
+			e.uvarint(0)
+		}
+		e.uvarint(uint64(in.KeyType))
+		e.uvarint(uint64(in.SigType))
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		e.uvarint(1)
+		writeIn(idx, tx.TxIns[idx])
+	} else {
+		e.uvarint(uint64(len(tx.TxIns)))
+		for i, in := range tx.TxIns {
+			writeIn(i, in)
+		}
+	}
+
+	// The input being signed and the output it spends
+	e.uvarint(uint64(idx))
+	prevOut.encode(e)
+
+	// Outputs selected by the base type
+	var outs []TxOut
+	switch base {
+	case SigHashAll:
+		outs = tx.TxOuts
+	case SigHashSingle:
+		outs = tx.TxOuts[idx : idx+1]
+	}
+	e.uvarint(uint64(len(outs)))
+	for i := range outs {
+		outs[i].encode(e)
+	}
+	e.uvarint(uint64(tx.LockTime))
+
+	return HashSHA3(e.buf), nil
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ScriptBuilder assembles a script, always choosing the minimal push for data
+type ScriptBuilder struct {
+	script []byte
+}
+
+// NewScriptBuilder returns an empty builder
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode
+func (b *ScriptBuilder) AddOp(op byte) *ScriptBuilder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddData appends the shortest push of data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	n := len(data)
+	switch {
+	case n == 0:
+		b.script = append(b.script, OP_0)
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		b.script = append(b.script, OP_1+data[0]-1)
+	case n == 1 && data[0] == 0x81:
+		b.script = append(b.script, OP_1NEGATE)
+	case n <= OP_DATA_75:
+		b.script = append(b.script, byte(n))
+		b.script = append(b.script, data...)
+	case n <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(n))
+		b.script = append(b.script, data...)
+	default:
+		b.script = append(b.script, OP_PUSHDATA2)
+		b.script = binary.LittleEndian.AppendUint16(b.script, uint16(n))
+		b.script = append(b.script, data...)
+	}
+	return b
+}
+
+// AddInt64 appends a number
+func (b *ScriptBuilder) AddInt64(v int64) *ScriptBuilder {
+	return b.AddData(encodeScriptNum(v))
+}
+
+// Script returns the assembled script
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// ------------------------------------------------------
+// Standard templates
+
+// PayToPubKeyHashScript locks an output to the owner of the public key whose
+// SHA3-256 hash is pubKeyHash:
+//
+//	OP_DUP OP_SHA3 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OP_DUP).AddOp(OP_SHA3).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// PayToAddressScript returns the locking script paying to address (see
+// DecodeAddress). Malformed or mistyped addresses are rejected.
+func PayToAddressScript(address string) ([]byte, error) {
+	a, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return a.LockingScript(), nil
+}
+
+// PubKeyHashUnlockingScript spends a P2PKH output: <signature> <pubKey>
+func PubKeyHashUnlockingScript(sig, pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(sig).AddData(pubKey).Script()
+}
+
+// ExtractPubKeyHash returns the public key hash of a P2PKH script, or nil if
+// script is not one
+func ExtractPubKeyHash(script []byte) []byte {
+	if len(script) == 37 && script[0] == OP_DUP && script[1] == OP_SHA3 && script[2] == 32 &&
+		script[35] == OP_EQUALVERIFY && script[36] == OP_CHECKSIG {
+		return script[3:35]
+	}
+	return nil
+}
+
+// PayToScriptHashScript locks an output to any script whose SHA3-256 hash is
+// scriptHash. The sender only needs the hash; the spender reveals the script
+// (the redeem script) as the last push of the unlocking script:
+//
+//	OP_SHA3 <scriptHash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_SHA3).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// IsPayToScriptHash reports whether script is a P2SH locking script
+func IsPayToScriptHash(script []byte) bool {
+	return len(script) == 35 && script[0] == OP_SHA3 && script[1] == 32 && script[34] == OP_EQUAL
+}
+
+// MultiSigScript returns the script that requires m signatures from pubKeys:
+//
+//	<m> <pubKey1> ... <pubKeyN> <n> OP_CHECKMULTISIG
+//
+// Signatures must be given in the same order as the keys.
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	// The template encodes m and n with OP_1..OP_16
+	n := len(pubKeys)
+	if n == 0 || n > 16 {
+		return nil, fmt.Errorf("multisig needs between 1 and 16 public keys, got %d", n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("invalid multisig threshold %d of %d", m, n)
+	}
+	b := NewScriptBuilder().AddInt64(int64(m))
+	for i, pk := range pubKeys {
+		if !isPubKey(pk) {
+			return nil, fmt.Errorf("public key %d is not a valid key of any curve", i)
+		}
+		b.AddData(pk)
+	}
+	return b.AddInt64(int64(n)).AddOp(OP_CHECKMULTISIG).Script(), nil
+}
+
+// ParseMultiSigScript returns the threshold and public keys of a script built
+// by MultiSigScript
+func ParseMultiSigScript(script []byte) (int, [][]byte, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return 0, nil, err
+	}
+	smallInt := func(op scriptOp) (int, bool) {
+		if op.opcode >= OP_1 && op.opcode <= OP_16 {
+			return int(op.opcode-OP_1) + 1, true
+		}
+		return 0, false
+	}
+	if len(ops) < 4 || ops[len(ops)-1].opcode != OP_CHECKMULTISIG {
+		return 0, nil, errors.New("not a multisig script")
+	}
+	m, okM := smallInt(ops[0])
+	n, okN := smallInt(ops[len(ops)-2])
+	if !okM || !okN || n != len(ops)-3 || m > n {
+		return 0, nil, errors.New("not a multisig script")
+	}
+	pubKeys := make([][]byte, 0, n)
+	for _, op := range ops[1 : len(ops)-2] {
+		if !op.isPush() || len(op.data) == 0 {
+			return 0, nil, errors.New("not a multisig script")
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+	return m, pubKeys, nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	medianTimeSpan    = 11               // Blocks used to compute the median-time-past
+	maxTimeSamples    = 200              // Peers whose clock offset we remember
+	minTimeSamples    = 5                // Offsets needed before adjusting our clock
+	maxTimeAdjustment = 70 * time.Minute // Larger median offsets are ignored
+)
+
+// medianTimePast returns the median timestamp of node and its previous
+// medianTimeSpan-1 ancestors. A new block on top of node must be newer.
+func medianTimePast(node *blockNode) uint64 {
+	var timestamps []uint64
+	for n := node; n != nil && len(timestamps) < medianTimeSpan; n = n.parent {
+		timestamps = append(timestamps, n.block.Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// addTimeSample records the clock offset of a peer, taken from the time it
+// stamped on a message, and recomputes the network time offset as the median
+// of all samples. Samples are keyed by the host the connection came from, not
+// by a self-declared address: a single peer sending many messages, even over
+// several connections, only counts once. The caller must hold bs.mu.
+func (bs *BlockchainServer) addTimeSample(peer string, peerTime int64) {
+	if peer == "" || peerTime == 0 {
+		return
+	}
+	if _, known := bs.timeSamples[peer]; !known && len(bs.timeSamples) >= maxTimeSamples {
+		return
+	}
+	bs.timeSamples[peer] = peerTime - time.Now().Unix()
+
+	if len(bs.timeSamples) < minTimeSamples {
+		return
+	}
+	offsets := make([]int64, 0, len(bs.timeSamples))
+	for _, o := range bs.timeSamples {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	median := time.Duration(offsets[len(offsets)/2]) * time.Second
+
+	if median > maxTimeAdjustment || median < -maxTimeAdjustment {
+		fmt.Printf("⚠️  Peers' clocks differ from ours by %v, please check your system time\n", median)
+		bs.timeOffset = 0
+		return
+	}
+	bs.timeOffset = median
+}
+
+// adjustedTime returns our clock corrected by the median offset of our peers.
+// The caller must hold bs.mu.
+func (bs *BlockchainServer) adjustedTime() time.Time {
+	return time.Now().Add(bs.timeOffset)
+}
+
+// checkBlockTime enforces the timestamp rules for a block built on parent
+func (bs *BlockchainServer) checkBlockTime(block Block, parent *blockNode) error {
+	if parent != nil {
+		if mtp := medianTimePast(parent); block.Timestamp <= mtp {
+			return rejectBlock(RejectTimeTooOld, "timestamp %d not after median-time-past %d", block.Timestamp, mtp)
+		}
+	}
+
+	limit := bs.adjustedTime().Add(bs.params.MaxFutureBlockTime).Unix()
+	if int64(block.Timestamp) > limit {
+		return rejectBlock(RejectTimeTooNew, "timestamp %d more than %v ahead of network time", block.Timestamp, bs.params.MaxFutureBlockTime)
+	}
+	return nil
+}
+
+// MedianTimePast returns the median-time-past of the active tip
+func (bs *BlockchainServer) MedianTimePast() uint64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return medianTimePast(bs.tip)
+}
+
+// ChainTip describes the last block of a known branch
+type ChainTip struct {
+	Hash           string `json:"hash"`
+	Height         uint64 `json:"height"`
+	ChainWork      string `json:"chain_work"`
+	MedianTimePast uint64 `json:"median_time_past"`
+	Active         bool   `json:"active"`
+}
+
+// ChainTips lists the tips of the active chain and of every side branch
+func (bs *BlockchainServer) ChainTips() []ChainTip {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	hasChildren := make(map[*blockNode]bool)
+	for _, node := range bs.blockIndex {
+		if node.parent != nil {
+			hasChildren[node.parent] = true
+		}
+	}
+
+	var tips []ChainTip
+	for _, node := range bs.blockIndex {
+		if hasChildren[node] && node != bs.tip {
+			continue
+		}
+		tips = append(tips, ChainTip{
+			Hash:           node.hashHex,
+			Height:         node.height,
+			ChainWork:      node.chainWork.String(),
+			MedianTimePast: medianTimePast(node),
+			Active:         node == bs.tip,
+		})
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Height > tips[j].Height })
+	return tips
+}
@@ -1,10 +1,7 @@
 package core
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -15,57 +12,52 @@ import (
 
 // ------------------------------------------------------
 
+const (
+	// MaxMoney es el mayor monto que puede existir; ningún output ni suma de
+	// outputs puede superarlo
+	MaxMoney uint64 = 21_000_000 * 100_000_000
+	// DustLimit es el monto mínimo de un output: cualquier valor menor cuesta
+	// más en comisiones gastarlo que lo que vale
+	DustLimit uint64 = 546
+)
+
 type Tx struct {
 	Version uint32
 	TxIns   []TxIn
 	TxOuts  []TxOut
+	// Altura (< LockTimeThreshold) o tiempo Unix hasta el cual la transacción
+	// no puede entrar en un bloque; 0 la deja sin bloqueo (ver locktime.go)
+	LockTime uint32
 }
 type TxIn struct {
 	PrevTx    []byte // ID de la transacción previa
 	PrevIndex uint32 // Índice de la salida que se gasta
-	Signature []byte // Firma r||s del dueño de la salida
-	PubKey    []byte // Clave pública en formato no comprimido (0x04 + 64 bytes)
-	Net       string // Red
+	// Script que desbloquea la salida gastada, solo puede apilar datos.
+	// Para P2PKH: <firma r||s + SigHashType> <clave pública>
+	UnlockingScript []byte
+	Net             string // Red
+	// Bloqueo relativo del input desde que se confirmó la salida gastada (si
+	// Version >= 2). SequenceFinal desactiva también el LockTime.
+	Sequence uint32
+	KeyType  KeyType // Curva de las claves y firmas del input (ver keytype.go)
+	SigType  SigType // Esquema de firma del input: ECDSA o Schnorr (ver schnorr.go)
 }
 type TxOut struct {
 	Amount        uint64
-	LockingScript []byte
+	LockingScript []byte // Condición para gastar la salida (ver script.go)
 }
 
+// ID devuelve el hash SHA3-256 (en hex) de la codificación sin testigos: los
+// unlocking scripts no cuentan, así que alterar una firma no cambia el ID y
+// las transacciones que gastan sus salidas siguen siendo válidas
 func (tx *Tx) ID() string {
-	// Serialize the transaction data
-	var buf bytes.Buffer
-
-	// Write version
-	binary.Write(&buf, binary.LittleEndian, tx.Version)
-
-	// Write number of inputs
-	binary.Write(&buf, binary.LittleEndian, uint32(len(tx.TxIns)))
-
-	// Write each input
-	for _, txIn := range tx.TxIns {
-		buf.Write(txIn.PrevTx)
-		binary.Write(&buf, binary.LittleEndian, txIn.PrevIndex)
-		binary.Write(&buf, binary.LittleEndian, uint32(len(txIn.Signature)))
-		buf.Write(txIn.Signature)
-		buf.WriteString(txIn.Net)
-	}
-
-	// Write number of outputs
-	binary.Write(&buf, binary.LittleEndian, uint32(len(tx.TxOuts)))
-
-	// Write each output
-	for _, txOut := range tx.TxOuts {
-		binary.Write(&buf, binary.LittleEndian, txOut.Amount)
-		binary.Write(&buf, binary.LittleEndian, uint32(len(txOut.LockingScript)))
-		buf.Write(txOut.LockingScript)
-	}
-
-	// Hash the serialized data with SHA3-256
-	hash := sha3.Sum256(buf.Bytes())
+	return hex.EncodeToString(HashSHA3(tx.SerializeWithoutWitness()))
+}
 
-	// Return as hex string
-	return hex.EncodeToString(hash[:])
+// WitnessHash devuelve el hash SHA3-256 (en hex) de la codificación completa,
+// unlocking scripts incluidos
+func (tx *Tx) WitnessHash() string {
+	return hex.EncodeToString(HashSHA3(tx.Serialize()))
 }
 
 // HashSHA3 devuelve SHA3-256(data)
@@ -74,76 +66,138 @@ func HashSHA3(data []byte) []byte {
 	return sum[:]
 }
 
-// ParsePubKeySafe convierte bytes (0x04 + 64) a *ecdsa.PublicKey y valida que esté en la curva
+// ParsePubKeySafe convierte una clave P-256 comprimida (0x02/0x03 + 32 bytes)
+// o no comprimida (0x04 + 64) a *ecdsa.PublicKey y valida que esté en la curva.
+// Para otras curvas ver ParsePubKey.
 func ParsePubKeySafe(pub []byte) (*ecdsa.PublicKey, error) {
-	if len(pub) != 65 || pub[0] != 0x04 {
-		return nil, errors.New("clave pública debe estar en formato no comprimido (0x04 + 64 bytes)")
-	}
-
-	x := new(big.Int).SetBytes(pub[1:33])
-	y := new(big.Int).SetBytes(pub[33:])
-
-	curve := elliptic.P256()
-	if !curve.IsOnCurve(x, y) {
-		return nil, errors.New("la clave pública no está en la curva")
-	}
+	return ParsePubKey(pub, KeyTypeP256)
+}
 
-	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+// Validate ejecuta la verificación completa contra la vista de UTXOs: cada
+// input debe gastar una salida que siga sin gastar en utxos.
+// Devuelve la comisión implícita (inputs - outputs) de la transacción.
+func (tx *Tx) Validate(utxos *UTXOView) (uint64, error) {
+	return tx.validate(utxos, nil)
 }
 
-// Validate ejecuta la verificación completa usando las transacciones previas (prevTxs)
-// prevTxs es un mapa txID(hex) → *Tx
-func (tx *Tx) Validate(prevTxs map[string]*Tx) bool {
-	// Special case: coinbase transactions (no inputs) are always valid
+// validate es Validate, pero deja en batch (si no es nil) las firmas Schnorr
+// de OP_CHECKSIG para verificarlas junto con las del resto del bloque.
+func (tx *Tx) validate(utxos *UTXOView, batch *sigBatch) (uint64, error) {
+	// Coinbase transactions are only valid as the first transaction of a block
+	// (see checkCoinbase); transactions without inputs create coins from nothing
 	if len(tx.TxIns) == 0 {
-		fmt.Println("✅ Transacción coinbase válida (sin inputs)")
-		return true
+		return 0, errors.New("transacción sin inputs")
+	}
+	if tx.IsCoinbase() {
+		return 0, errors.New("transacción coinbase fuera de un bloque")
 	}
 
-	msg := tx.GetHashForSigning()
+	totalOut, err := tx.checkOutputs()
+	if err != nil {
+		return 0, err
+	}
 
+	// La transacción debe poder entrar en el próximo bloque de la vista
+	if !tx.IsFinal(utxos.height, utxos.medianTime) {
+		return 0, fmt.Errorf("transacción bloqueada hasta %s", lockTimeString(tx.LockTime))
+	}
+
+	var totalIn uint64
+	seen := make(map[string]bool, len(tx.TxIns))
 	for i, txin := range tx.TxIns {
-		// 1. Obtener la transacción previa
-		prevTxID := hex.EncodeToString(txin.PrevTx)
-		prevTx, ok := prevTxs[prevTxID]
+		// 1. La salida gastada debe existir y no estar gastada, ni siquiera
+		// por otro input de esta misma transacción
+		key := outpointKey(txin.PrevTx, txin.PrevIndex)
+		if seen[key] {
+			return 0, fmt.Errorf("input #%d gasta %s dos veces", i, key)
+		}
+		seen[key] = true
+		prevOut, ok := utxos.Get(key)
 		if !ok {
-			fmt.Printf("❌ Transacción previa %s no encontrada\n", prevTxID)
-			return false
+			return 0, fmt.Errorf("input #%d: la salida %s no existe o ya fue gastada", i, key)
+		}
+		if !txin.KeyType.Valid() {
+			return 0, fmt.Errorf("input #%d: tipo de clave desconocido %d", i, byte(txin.KeyType))
+		}
+		if !txin.SigType.Valid() {
+			return 0, fmt.Errorf("input #%d: tipo de firma desconocido %d", i, byte(txin.SigType))
 		}
 
-		// 2. Verificar índice válido
-		if int(txin.PrevIndex) >= len(prevTx.TxOuts) {
-			fmt.Printf("❌ Índice inválido en input #%d\n", i)
-			return false
+		// 2. La salida gastada debe tener la antigüedad pedida por Sequence
+		if err := utxos.checkSequenceLock(tx, i, key); err != nil {
+			return 0, fmt.Errorf("input #%d: %v", i, err)
 		}
-		prevOut := prevTx.TxOuts[txin.PrevIndex]
 
-		// 3. Comparar dirección derivada (hex del hash) con LockingScript
-		addr := hex.EncodeToString(HashSHA3(txin.PubKey))
-		if string(prevOut.LockingScript) != addr {
-			fmt.Printf("❌ El locking script no coincide con la dirección derivada\n")
-			return false
+		// 3. El unlocking script debe satisfacer el locking script de la salida
+		if err := verifyScript(tx, i, prevOut, batch); err != nil {
+			return 0, fmt.Errorf("input #%d: %v", i, err)
 		}
 
-		// 4. Verificar la firma
-		pubKey, err := ParsePubKeySafe(txin.PubKey)
-		if err != nil {
-			fmt.Printf("❌ Error al parsear pubkey: %v\n", err)
-			return false
+		// 4. Acumular el valor gastado sin desbordar
+		if totalIn, ok = addMoney(totalIn, prevOut.Amount); !ok {
+			return 0, fmt.Errorf("la suma de los inputs supera MaxMoney en input #%d", i)
+		}
+	}
+
+	// 5. Conservación del valor: no se puede gastar más de lo que se consume
+	if totalOut > totalIn {
+		return 0, fmt.Errorf("los outputs (%d) superan a los inputs (%d)", totalOut, totalIn)
+	}
+
+	return totalIn - totalOut, nil
+}
+
+// checkOutputs verifica que la transacción cree al menos un output, que ninguno
+// sea polvo y que ni cada monto ni su suma superen MaxMoney. Devuelve la suma.
+func (tx *Tx) checkOutputs() (uint64, error) {
+	if len(tx.TxOuts) == 0 {
+		return 0, errors.New("transacción sin outputs")
+	}
+	var total uint64
+	for i, txout := range tx.TxOuts {
+		if txout.Amount < DustLimit {
+			return 0, fmt.Errorf("output #%d es polvo (%d < %d)", i, txout.Amount, DustLimit)
 		}
-		if len(txin.Signature) != 64 {
-			fmt.Printf("❌ Firma inválida (esperado 64 bytes, got %d)\n", len(txin.Signature))
+		var ok bool
+		if total, ok = addMoney(total, txout.Amount); !ok {
+			return 0, fmt.Errorf("la suma de los outputs supera MaxMoney en output #%d", i)
+		}
+	}
+	return total, nil
+}
+
+// addMoney suma dos montos y devuelve false si el resultado desborda uint64
+// o supera MaxMoney
+func addMoney(a, b uint64) (uint64, bool) {
+	sum := a + b
+	if sum < a || sum > MaxMoney {
+		return 0, false
+	}
+	return sum, true
+}
+
+// Verify checks the signature of every input against the given public keys
+// (publicKeys[i] signs input i) using GetHashForSigning. The signature is the
+// first push of the unlocking script, in r||s form. Unlike Validate it does
+// not run the scripts of the outputs being spent.
+func (tx *Tx) Verify(publicKeys []*ecdsa.PublicKey) bool {
+	if len(publicKeys) != len(tx.TxIns) {
+		return false
+	}
+
+	msg := tx.GetHashForSigning()
+	for i, txin := range tx.TxIns {
+		ops, err := parseScript(txin.UnlockingScript)
+		if err != nil || len(ops) == 0 || len(ops[0].data) != 64 {
 			return false
 		}
-		r := new(big.Int).SetBytes(txin.Signature[:32])
-		s := new(big.Int).SetBytes(txin.Signature[32:])
-		if !ecdsa.Verify(pubKey, msg, r, s) {
-			fmt.Printf("❌ Firma inválida en input #%d\n", i)
+		sig := ops[0].data
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(publicKeys[i], msg, r, s) {
 			return false
 		}
 	}
-
-	fmt.Println("✅ Transacción válida")
 	return true
 }
 
@@ -168,35 +222,8 @@ func bytesToECDSAPublicKey(data []byte) (*ecdsa.PublicKey, error) {
 	}, nil
 }
 
-// GetHashForSigning returns the transaction hash used for signing
+// GetHashForSigning returns the transaction hash used for signing: the
+// hash of the transaction without its unlocking scripts, as in its ID
 func (tx *Tx) GetHashForSigning() []byte {
-	// Create a deep copy of the transaction without signatures for hashing
-	txCopy := *tx
-	// Deep copy the TxIns slice
-	txCopy.TxIns = make([]TxIn, len(tx.TxIns))
-	for i, txIn := range tx.TxIns {
-		txCopy.TxIns[i] = txIn
-		txCopy.TxIns[i].Signature = []byte{} // Clear signatures in the copy only
-	}
-
-	// Serialize and hash
-	var buf bytes.Buffer
-	binary.Write(&buf, binary.LittleEndian, txCopy.Version)
-	binary.Write(&buf, binary.LittleEndian, uint32(len(txCopy.TxIns)))
-
-	for _, txIn := range txCopy.TxIns {
-		buf.Write(txIn.PrevTx)
-		binary.Write(&buf, binary.LittleEndian, txIn.PrevIndex)
-		buf.WriteString(txIn.Net)
-	}
-
-	binary.Write(&buf, binary.LittleEndian, uint32(len(txCopy.TxOuts)))
-	for _, txOut := range txCopy.TxOuts {
-		binary.Write(&buf, binary.LittleEndian, txOut.Amount)
-		binary.Write(&buf, binary.LittleEndian, uint32(len(txOut.LockingScript)))
-		buf.Write(txOut.LockingScript)
-	}
-
-	hash := sha3.Sum256(buf.Bytes())
-	return hash[:]
+	return HashSHA3(tx.SerializeWithoutWitness())
 }
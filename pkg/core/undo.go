@@ -0,0 +1,178 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+const undoFile = "undo.dat"
+
+// SpentOutput is an output removed from the UTXO set when a block was
+// connected, kept so that the block can be disconnected later.
+type SpentOutput struct {
+	Key string `json:"key"` // "txid:index"
+	Out TxOut  `json:"out"`
+}
+
+// BlockUndo holds everything a block removed from the UTXO set.
+// Spent[i] are the outputs consumed by the i-th transaction of the block.
+type BlockUndo struct {
+	BlockHash string          `json:"block_hash"`
+	Spent     [][]SpentOutput `json:"spent"`
+}
+
+// applyUndo reverts the effect of block on the UTXO set using its undo record.
+// Transactions are processed in reverse order so that outputs created and
+// spent inside the same block are restored and removed correctly.
+func (bs *BlockchainServer) applyUndo(block Block, undo BlockUndo) error {
+	if len(undo.Spent) != len(block.Transactions) {
+		return fmt.Errorf("undo data for block %s does not match its transactions", undo.BlockHash)
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := &block.Transactions[i]
+
+		// Remove the outputs created by the transaction
+		txID := tx.ID()
+		for idx := range tx.TxOuts {
+			delete(bs.utxoSet, fmt.Sprintf("%s:%d", txID, idx))
+		}
+
+		// Restore the outputs it spent
+		for _, spent := range undo.Spent[i] {
+			bs.utxoSet[spent.Key] = spent.Out
+		}
+	}
+	return nil
+}
+
+// disconnectTip removes the tip of the active chain and restores the UTXO set
+// to its state before that block. The caller must hold bs.mu.
+func (bs *BlockchainServer) disconnectTip() (*blockNode, error) {
+	node := bs.tip
+	if node == nil {
+		return nil, fmt.Errorf("no blocks to disconnect")
+	}
+
+	undo, ok := bs.undoData[node.hashHex]
+	if !ok {
+		return nil, fmt.Errorf("missing undo data for block %s", node.hashHex)
+	}
+	if err := bs.applyUndo(node.block, undo); err != nil {
+		return nil, err
+	}
+
+	delete(bs.undoData, node.hashHex)
+	for i := range node.block.Transactions {
+		delete(bs.txHeights, node.block.Transactions[i].ID())
+	}
+	bs.blockchain = bs.blockchain[:len(bs.blockchain)-1]
+	bs.tip = node.parent
+	return node, nil
+}
+
+// DisconnectBlock removes the tip of the active chain, restoring the exact
+// UTXO set that existed before it was connected. Its transactions go back to
+// the pending pool. The block stays known as a side branch.
+func (bs *BlockchainServer) DisconnectBlock() (Block, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	node, err := bs.disconnectTip()
+	if err != nil {
+		return Block{}, err
+	}
+
+	bs.returnToPending(node.block)
+	bs.revalidatePending()
+	bs.saveBlockchain()
+	bs.saveUTXOSet()
+	bs.saveUndoData()
+
+	fmt.Printf("⏪ Block %s disconnected, new height %d\n", node.hashHex, len(bs.blockchain))
+	return node.block, nil
+}
+
+// InvalidateBlock marks a block (hex hash) and all its descendants as invalid.
+// If the block is part of the active chain it is disconnected, and the node
+// switches to the heaviest remaining valid branch.
+func (bs *BlockchainServer) InvalidateBlock(hashHex string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	target, ok := bs.blockIndex[hashHex]
+	if !ok {
+		return fmt.Errorf("unknown block %s", hashHex)
+	}
+
+	// Disconnect until the target is no longer on the active chain
+	for bs.tip != nil && findFork(bs.tip, target) == target {
+		node, err := bs.disconnectTip()
+		if err != nil {
+			return err
+		}
+		bs.returnToPending(node.block)
+	}
+
+	// Forget the target and every block built on top of it
+	for h, node := range bs.blockIndex {
+		if findFork(node, target) == target {
+			bs.invalidBlocks[h] = true
+			delete(bs.blockIndex, h)
+		}
+	}
+
+	if best := bs.bestValidNode(); best != nil && (bs.tip == nil || best.chainWork.Cmp(bs.tip.chainWork) > 0) {
+		if err := bs.reorganize(best); err != nil {
+			fmt.Printf("⚠️  Could not switch to branch %s: %v\n", best.hashHex, err)
+		}
+	}
+
+	bs.revalidatePending()
+	bs.saveBlockchain()
+	bs.saveUTXOSet()
+	bs.saveUndoData()
+
+	fmt.Printf("🚫 Block %s invalidated, tip at height %d\n", hashHex, len(bs.blockchain))
+	return nil
+}
+
+// bestValidNode returns the known block with the most cumulative work
+func (bs *BlockchainServer) bestValidNode() *blockNode {
+	var best *blockNode
+	for _, node := range bs.blockIndex {
+		if best == nil || node.chainWork.Cmp(best.chainWork) > 0 {
+			best = node
+		}
+	}
+	return best
+}
+
+// loadUndoData loads the undo records from disk
+func (bs *BlockchainServer) loadUndoData() error {
+	data, err := os.ReadFile(undoFile)
+	if err != nil {
+		return err
+	}
+	m, err := decodeUndoData(data)
+	if err != nil {
+		return err
+	}
+	// Every block of the active chain needs its undo record
+	for n := bs.tip; n != nil; n = n.parent {
+		if _, ok := m[n.hashHex]; !ok {
+			return fmt.Errorf("missing undo data for block %s", n.hashHex)
+		}
+	}
+	bs.undoData = m
+	fmt.Printf("🔄 Undo data loaded (%d blocks)\n", len(m))
+	return nil
+}
+
+// saveUndoData persists the undo records. The caller must hold bs.mu.
+func (bs *BlockchainServer) saveUndoData() {
+	if err := writeFileAtomic(undoFile, encodeUndoData(bs.undoData)); err != nil {
+		log.Printf("Error writing undo data: %v", err)
+	}
+}
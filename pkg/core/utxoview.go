@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UTXOView is a copy-on-write view of a UTXO set. Transactions applied to the
+// view spend and create outputs without touching the underlying set, which
+// lets a block (or the pending pool) be validated transaction by transaction:
+// an output spent earlier in the same block is no longer in the view.
+type UTXOView struct {
+	base  map[string]TxOut
+	added map[string]TxOut
+	spent map[string]bool
+
+	// Block the transactions are validated for, used by timelocks (see
+	// chainView). A plain NewUTXOView validates as if for the genesis block.
+	height     uint64            // Height of the block
+	medianTime uint64            // Median time past of its parent
+	tip        *blockNode        // Its parent
+	txHeights  map[string]uint64 // Height of the block confirming each transaction of the chain
+}
+
+// NewUTXOView returns an empty view on top of base. base is only read.
+func NewUTXOView(base map[string]TxOut) *UTXOView {
+	return &UTXOView{
+		base:  base,
+		added: make(map[string]TxOut),
+		spent: make(map[string]bool),
+	}
+}
+
+// outpointKey returns the UTXO set key of output index of transaction txid
+func outpointKey(txid []byte, index uint32) string {
+	return fmt.Sprintf("%x:%d", txid, index)
+}
+
+// parseOutpointKey splits a UTXO set key into its transaction ID and index
+func parseOutpointKey(key string) ([]byte, uint32, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid utxo key %s", key)
+	}
+	txid, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid txid hex: %v", err)
+	}
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid output index in utxo key %s", key)
+	}
+	return txid, uint32(index), nil
+}
+
+// Get returns the unspent output stored under key ("txid:index")
+func (v *UTXOView) Get(key string) (TxOut, bool) {
+	if v.spent[key] {
+		return TxOut{}, false
+	}
+	if out, ok := v.added[key]; ok {
+		return out, true
+	}
+	out, ok := v.base[key]
+	return out, ok
+}
+
+// ApplyTx marks the outputs spent by tx as spent and adds the outputs it
+// creates. The coinbase input does not spend anything.
+func (v *UTXOView) ApplyTx(tx *Tx) {
+	if !tx.IsCoinbase() {
+		for _, in := range tx.TxIns {
+			key := outpointKey(in.PrevTx, in.PrevIndex)
+			delete(v.added, key)
+			v.spent[key] = true
+		}
+	}
+	txID := tx.ID()
+	for idx, out := range tx.TxOuts {
+		key := fmt.Sprintf("%s:%d", txID, idx)
+		delete(v.spent, key)
+		v.added[key] = out
+	}
+}
+
+// outputs returns a copy of the unspent outputs seen through the view
+func (v *UTXOView) outputs() map[string]TxOut {
+	utxos := make(map[string]TxOut, len(v.base)+len(v.added))
+	for k, out := range v.base {
+		if !v.spent[k] {
+			utxos[k] = out
+		}
+	}
+	for k, out := range v.added {
+		utxos[k] = out
+	}
+	return utxos
+}
@@ -5,17 +5,17 @@ import (
 	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sort"
 
+	"github.com/xkal1bur/blockchain/pkg/crypto"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -23,10 +23,17 @@ import (
 var StandardCurve = elliptic.P256()
 
 type Wallet struct {
-	PrivateKey []byte `json:"private_key"`
-	PublicKey  []byte `json:"public_key"`
-	Address    string `json:"address"`
-	WalletFile string `json:"-"`
+	PrivateKey []byte  `json:"private_key"`
+	PublicKey  []byte  `json:"public_key"`
+	Address    string  `json:"address"`
+	WalletFile string  `json:"-"`
+	KeyType    KeyType `json:"key_type"` // Curve of the keys (see keytype.go)
+	SigType    SigType `json:"sig_type"` // Signature scheme of the inputs it signs (see schnorr.go)
+	Net        string  `json:"net"`      // Network of its addresses and transactions; empty means mainnet
+	// NonceEntropy, if set, is read for 32 bytes of extra entropy mixed into
+	// every signing nonce (RFC 6979 section 3.6). Without it signing is fully
+	// deterministic.
+	NonceEntropy io.Reader `json:"-"`
 }
 
 type WalletData struct {
@@ -34,23 +41,23 @@ type WalletData struct {
 	PublicKey  string `json:"public_key"`
 	Address    string `json:"address"`
 	CreatedAt  string `json:"created_at"`
+	KeyType    string `json:"key_type,omitempty"` // Empty for P-256
+	SigType    string `json:"sig_type,omitempty"` // Empty for ECDSA
+	Net        string `json:"net,omitempty"`      // Empty for mainnet
 }
 
-// NewWallet creates a new wallet with generated keys
+// NewWallet creates a new wallet with generated P-256 keys
 func NewWallet() (*Wallet, error) {
-	// Generate ECDH P-256 key pair
-	curve := ecdh.P256()
-	privateKey, err := curve.GenerateKey(rand.Reader)
+	return NewWalletWithKeyType(KeyTypeP256)
+}
+
+// NewWalletWithKeyType creates a new wallet with keys of the given curve
+func NewWalletWithKeyType(keyType KeyType) (*Wallet, error) {
+	privateKeyBytes, publicKeyBytes, err := generateKey(keyType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key: %v", err)
 	}
 
-	publicKey := privateKey.PublicKey()
-
-	// Get the raw bytes
-	privateKeyBytes := privateKey.Bytes()
-	publicKeyBytes := publicKey.Bytes()
-
 	// Generate address from public key (simplified - using SHA256 hash)
 	address := generateAddress(publicKeyBytes)
 
@@ -59,6 +66,8 @@ func NewWallet() (*Wallet, error) {
 		PublicKey:  publicKeyBytes,
 		Address:    address,
 		WalletFile: "wallet.json",
+		KeyType:    keyType,
+		Net:        "mainnet",
 	}
 
 	return wallet, nil
@@ -87,11 +96,30 @@ func LoadWallet(walletFile string) (*Wallet, error) {
 		return nil, fmt.Errorf("failed to decode public key: %v", err)
 	}
 
+	keyType, err := ParseKeyType(walletData.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key type: %v", err)
+	}
+	sigType, err := ParseSigType(walletData.SigType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature type: %v", err)
+	}
+	net := walletData.Net
+	if net == "" {
+		net = "mainnet"
+	}
+	if err := CheckNetwork(net); err != nil {
+		return nil, fmt.Errorf("failed to decode network: %v", err)
+	}
+
 	wallet := &Wallet{
 		PrivateKey: privateKeyBytes,
 		PublicKey:  publicKeyBytes,
 		Address:    walletData.Address,
 		WalletFile: walletFile,
+		KeyType:    keyType,
+		SigType:    sigType,
+		Net:        net,
 	}
 
 	return wallet, nil
@@ -111,6 +139,15 @@ func (w *Wallet) SaveToDisk() error {
 		Address:    w.Address,
 		CreatedAt:  fmt.Sprintf("%d", getCurrentTimestamp()),
 	}
+	if w.KeyType != KeyTypeP256 {
+		walletData.KeyType = w.KeyType.String()
+	}
+	if w.SigType != SigTypeECDSA {
+		walletData.SigType = w.SigType.String()
+	}
+	if w.network() != "mainnet" {
+		walletData.Net = w.network()
+	}
 
 	file, err := os.Create(w.WalletFile)
 	if err != nil {
@@ -127,11 +164,25 @@ func (w *Wallet) SaveToDisk() error {
 	return nil
 }
 
-// GetAddressHex returns the wallet address as hex string
+// GetAddressHex returns the hash of the wallet's public key as hex string
 func (w *Wallet) GetAddressHex() string {
 	return w.Address
 }
 
+// network returns the network of the wallet, mainnet unless Net says otherwise
+func (w *Wallet) network() string {
+	if w.Net == "" {
+		return "mainnet"
+	}
+	return w.Net
+}
+
+// GetAddress returns the checksummed address of the wallet on its network,
+// the one to give to payers
+func (w *Wallet) GetAddress() string {
+	return NewAddress(w.network(), w.PublicKey).String()
+}
+
 // GetPrivateKeyHex returns the private key as hex string
 func (w *Wallet) GetPrivateKeyHex() string {
 	return hex.EncodeToString(w.PrivateKey)
@@ -150,7 +201,9 @@ func (w *Wallet) SignData(data []byte) ([]byte, error) {
 	return hash[:], nil
 }
 
-// SignECDSA signs data with ECDSA and returns signature in r||s format
+// SignECDSA signs data with ECDSA and returns signature in r||s format.
+// The nonce is derived from the key and data as in RFC 6979 (HMAC-SHA3-256),
+// so the same data always gets the same signature.
 func (w *Wallet) SignECDSA(data []byte) ([]byte, error) {
 	// Get ECDSA private key
 	privateKey, err := w.GetECDSAPrivateKey()
@@ -158,10 +211,20 @@ func (w *Wallet) SignECDSA(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to get ECDSA private key: %v", err)
 	}
 
+	var extra []byte
+	if w.NonceEntropy != nil {
+		extra = make([]byte, 32)
+		if _, err := io.ReadFull(w.NonceEntropy, extra); err != nil {
+			return nil, fmt.Errorf("failed to read nonce entropy: %v", err)
+		}
+	}
+
 	// Sign the data
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to sign data: %v", err)
+	r, s := crypto.SignRFC6979(privateKey, data, sha3.New256, extra)
+	// (r, s) and (r, n-s) are both valid; always use the low S so nobody
+	// else can produce the other one (see checkLowS)
+	if s.Cmp(w.KeyType.halfOrder()) > 0 {
+		s.Sub(privateKey.Curve.Params().N, s)
 	}
 
 	// Combine r and s into a single byte slice (r||s format)
@@ -179,6 +242,46 @@ func (w *Wallet) SignECDSA(data []byte) ([]byte, error) {
 	return signature, nil
 }
 
+// SignInput signs input idx of tx, which spends the P2PKH output prevOut, and
+// sets its unlocking script to the signature (with the sighash type) and the
+// wallet's public key. The input takes the wallet's key and signature types.
+func (w *Wallet) SignInput(tx *Tx, idx int, prevOut TxOut, hashType SigHashType) error {
+	if idx < 0 || idx >= len(tx.TxIns) {
+		return fmt.Errorf("input index %d out of range", idx)
+	}
+	tx.TxIns[idx].KeyType = w.KeyType
+	tx.TxIns[idx].SigType = w.SigType
+	hash, err := tx.SignatureHash(idx, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+	sig, err := w.sign(hash)
+	if err != nil {
+		return err
+	}
+	tx.TxIns[idx].UnlockingScript = PubKeyHashUnlockingScript(append(sig, byte(hashType)), w.PublicKey)
+	return nil
+}
+
+// SignTx signs every input of tx that spends an output of this wallet.
+// utxoSet must contain the outputs being spent.
+func (w *Wallet) SignTx(tx *Tx, utxoSet map[string]TxOut, hashType SigHashType) error {
+	for i, in := range tx.TxIns {
+		key := outpointKey(in.PrevTx, in.PrevIndex)
+		prevOut, ok := utxoSet[key]
+		if !ok {
+			return fmt.Errorf("output %s being spent not found", key)
+		}
+		if !bytes.Equal(prevOut.LockingScript, w.GetLockingScript()) {
+			continue
+		}
+		if err := w.SignInput(tx, i, prevOut, hashType); err != nil {
+			return fmt.Errorf("signing input %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
 // VerifySignature verifies a signature against the wallet's public key
 func (w *Wallet) VerifySignature(data, signature []byte) bool {
 	// Simple verification for demonstration
@@ -207,7 +310,11 @@ func WalletExists(walletFile string) bool {
 // DisplayWalletInfo prints wallet information
 func (w *Wallet) DisplayWalletInfo() {
 	fmt.Println("💰 Wallet Information:")
-	fmt.Printf("   Address: %s\n", w.Address)
+	fmt.Printf("   Address: %s\n", w.GetAddress())
+	fmt.Printf("   Network: %s\n", w.network())
+	fmt.Printf("   Key Hash: %s\n", w.Address)
+	fmt.Printf("   Curve: %s\n", w.KeyType)
+	fmt.Printf("   Signatures: %s\n", w.SigType)
 	fmt.Printf("   Public Key: %s\n", w.GetPublicKeyHex())
 	fmt.Printf("   Private Key: %s...\n", w.GetPrivateKeyHex()[:16])
 	fmt.Printf("   Wallet File: %s\n", w.WalletFile)
@@ -215,6 +322,19 @@ func (w *Wallet) DisplayWalletInfo() {
 
 // GetECDSAPrivateKey returns the wallet's private key as an ECDSA private key
 func (w *Wallet) GetECDSAPrivateKey() (*ecdsa.PrivateKey, error) {
+	if w.KeyType != KeyTypeP256 {
+		curve, err := w.KeyType.Curve()
+		if err != nil {
+			return nil, err
+		}
+		d := new(big.Int).SetBytes(w.PrivateKey)
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, fmt.Errorf("invalid %s private key", w.KeyType)
+		}
+		x, y := curve.ScalarBaseMult(w.PrivateKey)
+		return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}, nil
+	}
+
 	// For ECDSA, we need to convert from ECDH format
 	// This is a simplified approach - in production you'd store the key in ECDSA format directly
 	curve := ecdh.P256()
@@ -266,25 +386,31 @@ func (w *Wallet) GetPublicKeyData() (PublicKeyData, error) {
 	}, nil
 }
 
-// GetLockingScript returns the wallet's locking script (SHA3-256 of its public key)
+// GetLockingScript returns the P2PKH locking script paying to this wallet
 func (w *Wallet) GetLockingScript() []byte {
-	return []byte(w.Address)
+	return PayToPubKeyHashScript(HashSHA3(w.PublicKey))
 }
 
-// FindSpendableUTXOs selects UTXOs from utxoSet belonging to this wallet until amount is reached
+// FindSpendableUTXOs selects UTXOs from utxoSet belonging to this wallet until amount is reached,
+// in key order so the same set always gives the same selection.
 // Returns slice of keys and total amount gathered
 func (w *Wallet) FindSpendableUTXOs(utxoSet map[string]TxOut, amount uint64) ([]string, uint64, error) {
-	var selected []string
-	var total uint64
-	script := []byte(w.Address)
-
+	script := w.GetLockingScript()
+	var owned []string
 	for key, out := range utxoSet {
 		if bytes.Equal(out.LockingScript, script) {
-			selected = append(selected, key)
-			total += out.Amount
-			if total >= amount {
-				break
-			}
+			owned = append(owned, key)
+		}
+	}
+	sort.Strings(owned)
+
+	var selected []string
+	var total uint64
+	for _, key := range owned {
+		selected = append(selected, key)
+		total += utxoSet[key].Amount
+		if total >= amount {
+			break
 		}
 	}
 
@@ -294,118 +420,220 @@ func (w *Wallet) FindSpendableUTXOs(utxoSet map[string]TxOut, amount uint64) ([]
 	return selected, total, nil
 }
 
-// BuildTransaction builds and signs a transaction sending 'amount' to destPubKey.
-// utxoSet is required to choose inputs. Returns the transaction and the keys used.
-func (w *Wallet) BuildTransaction(destPubKey []byte, amount uint64, utxoSet map[string]TxOut) (Tx, []string, error) {
-	inputKeys, totalIn, err := w.FindSpendableUTXOs(utxoSet, amount)
-	if err != nil {
-		return Tx{}, nil, err
-	}
+// TxOption customizes a transaction built by the wallet before it is signed
+type TxOption func(*Tx)
 
-	tx := Tx{Version: 1}
+// WithLockTime keeps the transaction out of the chain until a block after the
+// given height (below LockTimeThreshold) or Unix time
+func WithLockTime(lockTime uint32) TxOption {
+	return func(tx *Tx) {
+		tx.LockTime = lockTime
+	}
+}
 
-	// Create inputs
-	for _, key := range inputKeys {
-		parts := strings.Split(key, ":")
-		if len(parts) != 2 {
-			return Tx{}, nil, fmt.Errorf("invalid utxo key %s", key)
+// WithReplaceable opts the transaction in to replace-by-fee, so that BumpFee
+// can speed it up if it gets stuck in the mempool
+func WithReplaceable() TxOption {
+	return func(tx *Tx) {
+		for i := range tx.TxIns {
+			tx.TxIns[i].Sequence = SequenceReplaceable
 		}
-		txidHex := parts[0]
-		idxStr := parts[1]
-		idxParsed, _ := strconv.Atoi(idxStr)
+	}
+}
 
-		txidBytes, err := hex.DecodeString(txidHex)
+// BuildTransaction builds and signs a transaction sending amount to an output
+// locked by destScript (for instance a multisig P2SH script), paying feeRate
+// per byte of the signed transaction. utxoSet is required to choose inputs.
+// Returns the transaction and the keys used.
+func (w *Wallet) BuildTransaction(destScript []byte, amount, feeRate uint64, utxoSet map[string]TxOut, opts ...TxOption) (Tx, []string, error) {
+	if amount < DustLimit {
+		return Tx{}, nil, fmt.Errorf("amount %d is below the dust limit (%d)", amount, DustLimit)
+	}
+
+	// The fee depends on the size of the signed transaction, which grows
+	// with the inputs needed to pay it: raise it until the transaction pays
+	// feeRate for its own size
+	var fee uint64
+	for {
+		inputKeys, totalIn, err := w.FindSpendableUTXOs(utxoSet, amount+fee)
 		if err != nil {
-			return Tx{}, nil, fmt.Errorf("invalid txid hex: %v", err)
+			return Tx{}, nil, err
 		}
 
-		tx.TxIns = append(tx.TxIns, TxIn{
-			PrevTx:    txidBytes,
-			PrevIndex: uint32(idxParsed),
-			Signature: []byte{},
-			PubKey:    w.PublicKey,
-			Net:       "mainnet",
-		})
-	}
+		tx := Tx{Version: 1}
+		for _, key := range inputKeys {
+			txid, index, err := parseOutpointKey(key)
+			if err != nil {
+				return Tx{}, nil, err
+			}
+			tx.TxIns = append(tx.TxIns, TxIn{
+				PrevTx:    txid,
+				PrevIndex: index,
+				Net:       w.network(),
+				KeyType:   w.KeyType,
+				SigType:   w.SigType,
+			})
+		}
 
-	// Outputs: recipient + change (if any)
-	tx.TxOuts = append(tx.TxOuts, TxOut{
-		Amount:        amount,
-		LockingScript: []byte(hex.EncodeToString(HashSHA3(destPubKey))),
-	})
+		// Outputs: destination + change (if any). Change below the dust
+		// limit is left to the miner as fee
+		tx.TxOuts = append(tx.TxOuts, TxOut{Amount: amount, LockingScript: destScript})
+		if change := totalIn - amount - fee; change >= DustLimit {
+			tx.TxOuts = append(tx.TxOuts, TxOut{Amount: change, LockingScript: w.GetLockingScript()})
+		}
 
-	change := totalIn - amount
-	if change > 0 {
-		tx.TxOuts = append(tx.TxOuts, TxOut{
-			Amount:        change,
-			LockingScript: w.GetLockingScript(),
-		})
+		for _, opt := range opts {
+			opt(&tx)
+		}
+		if err := w.SignTx(&tx, utxoSet, SigHashAll); err != nil {
+			return Tx{}, nil, err
+		}
+
+		var totalOut uint64
+		for _, out := range tx.TxOuts {
+			totalOut += out.Amount
+		}
+		required := feeRate * uint64(len(tx.Serialize()))
+		if totalIn-totalOut >= required {
+			return tx, inputKeys, nil
+		}
+		// required is above what this pass paid, itself at least fee: the fee
+		// only grows, until it is paid or the funds run out
+		fee = max(fee, required)
 	}
+}
 
-	// Sign inputs
-	hashForSign := tx.GetHashForSigning()
-	sig, err := w.SignECDSA(hashForSign)
+// BuildTransactionToAddress creates a tx sending 'amount' to a destination
+// address (see DecodeAddress). Malformed addresses, and addresses of another
+// network than the wallet's, are rejected.
+func (w *Wallet) BuildTransactionToAddress(destAddress string, amount, feeRate uint64, utxoSet map[string]TxOut, opts ...TxOption) (Tx, []string, error) {
+	addr, err := DecodeAddress(destAddress)
 	if err != nil {
 		return Tx{}, nil, err
 	}
-
-	for i := range tx.TxIns {
-		tx.TxIns[i].Signature = sig
+	if addr.Net != w.network() {
+		return Tx{}, nil, fmt.Errorf("address %s is for %s, not %s", destAddress, addr.Net, w.network())
 	}
-
-	return tx, inputKeys, nil
+	return w.BuildTransaction(addr.LockingScript(), amount, feeRate, utxoSet, opts...)
 }
 
-// BuildTransactionToAddress creates a tx sending 'amount' to a destination address string (locking script = address bytes)
-func (w *Wallet) BuildTransactionToAddress(destAddress string, amount uint64, utxoSet map[string]TxOut) (Tx, []string, error) {
-	inputKeys, totalIn, err := w.FindSpendableUTXOs(utxoSet, amount)
-	if err != nil {
-		return Tx{}, nil, err
+// BumpFee rebuilds a replaceable transaction of this wallet stuck in the
+// mempool so that it pays at least fee in total, and signs it again. The
+// destination outputs are kept; the extra fee comes out of the change (the
+// last output paying to the wallet), and more of the wallet's outputs are
+// spent if the change is not enough. Extra inputs make the transaction
+// bigger, so the fee is raised further if needed to pay more per byte than
+// the original, as the mempool requires from a replacement. utxoSet must
+// contain the outputs tx spends, and the extra ones are chosen from it.
+func (w *Wallet) BumpFee(tx Tx, fee uint64, utxoSet map[string]TxOut) (Tx, error) {
+	if !tx.SignalsReplacement() {
+		return Tx{}, fmt.Errorf("transaction %s did not opt in to replace-by-fee", tx.ID())
 	}
-
-	tx := Tx{Version: 1}
-
-	// inputs
-	for _, key := range inputKeys {
-		parts := strings.Split(key, ":")
-		if len(parts) != 2 {
-			return Tx{}, nil, fmt.Errorf("invalid utxo key %s", key)
+	script := w.GetLockingScript()
+
+	var origIn uint64
+	spent := make(map[string]bool, len(tx.TxIns))
+	for _, in := range tx.TxIns {
+		key := outpointKey(in.PrevTx, in.PrevIndex)
+		prevOut, ok := utxoSet[key]
+		if !ok {
+			return Tx{}, fmt.Errorf("output %s being spent not found", key)
 		}
-		txidBytes, _ := hex.DecodeString(parts[0])
-		idx, _ := strconv.Atoi(parts[1])
-
-		tx.TxIns = append(tx.TxIns, TxIn{
-			PrevTx:    txidBytes,
-			PrevIndex: uint32(idx),
-			PubKey:    w.PublicKey,
-			Signature: []byte{},
-			Net:       "mainnet",
-		})
+		origIn += prevOut.Amount
+		spent[key] = true
 	}
 
-	// outputs: destination + change
-	tx.TxOuts = append(tx.TxOuts, TxOut{Amount: amount, LockingScript: []byte(destAddress)})
-	change := totalIn - amount
-	if change > 0 {
-		tx.TxOuts = append(tx.TxOuts, TxOut{Amount: change, LockingScript: w.GetLockingScript()})
+	change := -1
+	for i, out := range tx.TxOuts {
+		if bytes.Equal(out.LockingScript, script) {
+			change = i
+		}
+	}
+	var outs []TxOut
+	var origOut, paid uint64
+	for i, out := range tx.TxOuts {
+		origOut += out.Amount
+		if i != change {
+			outs = append(outs, out)
+			paid += out.Amount
+		}
 	}
+	if origOut > origIn {
+		return Tx{}, fmt.Errorf("outputs (%d) exceed inputs (%d)", origOut, origIn)
+	}
+	origFee := origIn - origOut
+	if fee <= origFee {
+		return Tx{}, fmt.Errorf("transaction already pays a fee of %d", origFee)
+	}
+	origSize := uint64(len(tx.Serialize()))
 
-	// sign
-	hash := tx.GetHashForSigning()
-	sig, err := w.SignECDSA(hash)
-	if err != nil {
-		return Tx{}, nil, err
+	// The wallet's other outputs, in case the change does not cover the fee
+	var extra []string
+	for key, out := range utxoSet {
+		if !spent[key] && bytes.Equal(out.LockingScript, script) {
+			extra = append(extra, key)
+		}
 	}
-	for i := range tx.TxIns {
-		tx.TxIns[i].Signature = sig
+	sort.Strings(extra)
+
+	for {
+		bumped := Tx{Version: tx.Version, LockTime: tx.LockTime}
+		for _, in := range tx.TxIns {
+			in.UnlockingScript = nil
+			bumped.TxIns = append(bumped.TxIns, in)
+		}
+		totalIn := origIn
+		needed := paid + fee
+		for _, key := range extra {
+			if totalIn >= needed {
+				break
+			}
+			txid, index, err := parseOutpointKey(key)
+			if err != nil {
+				return Tx{}, err
+			}
+			bumped.TxIns = append(bumped.TxIns, TxIn{
+				PrevTx:    txid,
+				PrevIndex: index,
+				Net:       bumped.TxIns[0].Net,
+				KeyType:   w.KeyType,
+				SigType:   w.SigType,
+				Sequence:  SequenceReplaceable,
+			})
+			totalIn += utxoSet[key].Amount
+		}
+		if totalIn < needed {
+			return Tx{}, fmt.Errorf("insufficient funds: needed %d, available %d", needed, totalIn)
+		}
+
+		// Change below the dust limit is left to the miner as fee
+		bumped.TxOuts = append([]TxOut(nil), outs...)
+		bumpedFee := totalIn - paid
+		if rest := totalIn - needed; rest >= DustLimit {
+			bumped.TxOuts = append(bumped.TxOuts, TxOut{Amount: rest, LockingScript: script})
+			bumpedFee = fee
+		}
+		if len(bumped.TxOuts) == 0 {
+			return Tx{}, fmt.Errorf("fee %d leaves no outputs", fee)
+		}
+		if err := w.SignTx(&bumped, utxoSet, SigHashAll); err != nil {
+			return Tx{}, err
+		}
+
+		size := uint64(len(bumped.Serialize()))
+		if compareFeeRates(bumpedFee, int(size), origFee, int(origSize)) > 0 {
+			return bumped, nil
+		}
+		// Lowest fee paying more per byte than the original at this size,
+		// computed without overflowing origFee*size
+		fee = origFee/origSize*size + origFee%origSize*size/origSize + 1
 	}
-	return tx, inputKeys, nil
 }
 
 // FilterUTXOs returns a subset of utxoSet that belong to this wallet
 func (w *Wallet) FilterUTXOs(utxoSet map[string]TxOut) map[string]TxOut {
 	res := make(map[string]TxOut)
-	script := []byte(w.Address)
+	script := w.GetLockingScript()
 	for k, v := range utxoSet {
 		if bytes.Equal(v.LockingScript, script) {
 			res[k] = v
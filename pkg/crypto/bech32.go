@@ -0,0 +1,143 @@
+package crypto
+
+// Bech32m (BIP-350): a human readable prefix, the separator "1", data in a
+// 32-character alphabet and a 6-character BCH checksum. The checksum detects
+// any error in up to 4 characters, and the alphabet avoids the characters
+// most easily mistaken for each other (1, b, i, o).
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32mConst is the value the checksum polymod leaves for a valid string
+// (1 in the original Bech32, which BIP-350 replaced)
+const bech32mConst = 0x2bc830a3
+
+// bech32MaxLength is the longest valid string
+const bech32MaxLength = 90
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HRPExpand spreads the prefix over 5-bit values so the checksum
+// covers it
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// EncodeBech32m encodes data, a list of 5-bit values, with the prefix hrp
+func EncodeBech32m(hrp string, data []byte) (string, error) {
+	if len(hrp) == 0 || len(hrp)+1+len(data)+6 > bech32MaxLength {
+		return "", errors.New("bech32m: invalid length")
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 || (hrp[i] >= 'A' && hrp[i] <= 'Z') {
+			return "", fmt.Errorf("bech32m: invalid prefix character %q", hrp[i])
+		}
+	}
+
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ bech32mConst
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		if v >= 32 {
+			return "", errors.New("bech32m: data values must be 5 bits")
+		}
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(mod>>(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// DecodeBech32m returns the prefix and the 5-bit data values of s, checking
+// its checksum. Strings mixing upper and lower case are rejected.
+func DecodeBech32m(s string) (string, []byte, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, errors.New("bech32m: string too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("bech32m: mixed case")
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("bech32m: missing prefix, separator or checksum")
+	}
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("bech32m: invalid prefix character %q", hrp[i])
+		}
+	}
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("bech32m: invalid character %q at position %d", s[i], i)
+		}
+		data = append(data, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), data...)) != bech32mConst {
+		return "", nil, errors.New("bech32m: invalid checksum")
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// ConvertBits regroups data from groups of from bits into groups of to bits.
+// With pad the last group is completed with zeros; without it, leftover bits
+// must be zero and fewer than from.
+func ConvertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	var out []byte
+	for _, v := range data {
+		if uint(v)>>from != 0 {
+			return nil, fmt.Errorf("value %d does not fit in %d bits", v, from)
+		}
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
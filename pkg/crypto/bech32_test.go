@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// Valid strings of BIP-350
+func TestBech32mVectors(t *testing.T) {
+	valid := []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	}
+	for _, s := range valid {
+		hrp, data, err := DecodeBech32m(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if enc, err := EncodeBech32m(hrp, data); err != nil || enc != strings.ToLower(s) {
+			t.Errorf("%s: re-encoded as %s (%v)", s, enc, err)
+		}
+	}
+}
+
+func TestBech32mRejectsErrors(t *testing.T) {
+	data, _ := ConvertBits(bytes.Repeat([]byte{0xa5}, 32), 8, 5, true)
+	s, err := EncodeBech32m("blk", data)
+	if err != nil {
+		t.Fatalf("EncodeBech32m: %v", err)
+	}
+
+	// Every single-character substitution is detected
+	for i := len("blk1"); i < len(s); i++ {
+		for _, c := range bech32Charset {
+			if byte(c) == s[i] {
+				continue
+			}
+			typo := s[:i] + string(c) + s[i+1:]
+			if _, _, err := DecodeBech32m(typo); err == nil {
+				t.Fatalf("typo at %d (%s) not detected", i, typo)
+			}
+		}
+	}
+
+	cases := map[string]string{
+		"swapped":      s[:10] + s[11:12] + s[10:11] + s[12:],
+		"mixed case":   strings.ToUpper(s[:5]) + s[5:],
+		"bad char":     s[:10] + "b" + s[11:],
+		"no separator": strings.Replace(s, "1", "", 1),
+		"short":        "blk1qqqqq",
+		"empty prefix": "1" + s[4:],
+		"other prefix": "blc" + s[3:],
+		"too long":     "blk1" + strings.Repeat("q", 90),
+	}
+	for what, bad := range cases {
+		if _, _, err := DecodeBech32m(bad); err == nil {
+			t.Errorf("%s accepted: %s", what, bad)
+		}
+	}
+
+	back, err := ConvertBits(data, 5, 8, false)
+	if err != nil || !bytes.Equal(back, bytes.Repeat([]byte{0xa5}, 32)) {
+		t.Errorf("ConvertBits round trip: %x, %v", back, err)
+	}
+	if _, err := ConvertBits([]byte{31, 31}, 5, 8, false); err == nil {
+		t.Errorf("non-zero padding accepted")
+	}
+}
+
+func TestPointCompression(t *testing.T) {
+	for _, curve := range []elliptic.Curve{Secp256k1(), elliptic.P256()} {
+		for i := 0; i < 10; i++ {
+			d, _ := randScalar(rand.Reader, curve.Params().N)
+			x, y := curve.ScalarBaseMult(bytes32(d))
+			c := CompressPoint(x, y)
+			dx, dy, err := DecompressPoint(curve, c)
+			if err != nil || dx.Cmp(x) != 0 || dy.Cmp(y) != 0 {
+				t.Fatalf("%s: point changed after compression (%v)", curve.Params().Name, err)
+			}
+		}
+		if _, _, err := DecompressPoint(curve, append([]byte{0x04}, make([]byte, 32)...)); err == nil {
+			t.Errorf("%s: invalid prefix accepted", curve.Params().Name)
+		}
+		if _, _, err := DecompressPoint(curve, append([]byte{0x02}, bytes32(curve.Params().P)...)); err == nil {
+			t.Errorf("%s: x >= p accepted", curve.Params().Name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package crypto
+
+// Binary Merkle tree over SHA3-256, used to commit to the transactions of a
+// block in its header.
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, so an inner node
+// can never be presented as a leaf (second preimage attack).
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleStep is one sibling hash of an inclusion proof
+type MerkleStep struct {
+	Hash []byte `json:"hash"`
+	Left bool   `json:"left"` // Sibling is on the left of the running hash
+}
+
+func merkleLeaf(data []byte) []byte {
+	h := sha3.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return h[:]
+}
+
+func merkleNode(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	h := sha3.Sum256(buf)
+	return h[:]
+}
+
+// nextLevel hashes pairs of nodes. An odd last node is promoted unchanged
+// instead of being paired with itself, so two different lists of leaves can
+// never produce the same root.
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the tree over leaves.
+// The root of an empty tree is 32 zero bytes.
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return make([]byte, 32)
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = merkleLeaf(l)
+	}
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the sibling hashes needed to prove that leaves[index]
+// is part of the tree
+func MerkleProof(leaves [][]byte, index int) ([]MerkleStep, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("merkle proof index out of range")
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = merkleLeaf(l)
+	}
+
+	var proof []MerkleStep
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, MerkleStep{Hash: level[index-1], Left: true})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleStep{Hash: level[index+1], Left: false})
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that leaf belongs to the tree with the given root
+func VerifyMerkleProof(leaf []byte, proof []MerkleStep, root []byte) bool {
+	h := merkleLeaf(leaf)
+	for _, step := range proof {
+		if step.Left {
+			h = merkleNode(step.Hash, h)
+		} else {
+			h = merkleNode(h, step.Hash)
+		}
+	}
+	return bytes.Equal(h, root)
+}
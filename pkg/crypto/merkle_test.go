@@ -0,0 +1,40 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		var leaves [][]byte
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, []byte(fmt.Sprintf("tx-%d", i)))
+		}
+		root := MerkleRoot(leaves)
+
+		for i := range leaves {
+			proof, err := MerkleProof(leaves, i)
+			if err != nil {
+				t.Fatalf("MerkleProof(%d leaves, %d): %v", n, i, err)
+			}
+			if !VerifyMerkleProof(leaves[i], proof, root) {
+				t.Errorf("valid proof rejected (%d leaves, index %d)", n, i)
+			}
+			if VerifyMerkleProof([]byte("other"), proof, root) {
+				t.Errorf("proof accepted for a different leaf (%d leaves, index %d)", n, i)
+			}
+		}
+	}
+}
+
+func TestMerkleRootDoesNotDuplicateOddLeaf(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	if bytes.Equal(MerkleRoot([][]byte{a, b, c}), MerkleRoot([][]byte{a, b, c, c})) {
+		t.Errorf("duplicating the last leaf must change the root")
+	}
+	if !bytes.Equal(MerkleRoot(nil), make([]byte, 32)) {
+		t.Errorf("empty tree root must be all zeros")
+	}
+}
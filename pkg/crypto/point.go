@@ -0,0 +1,72 @@
+package crypto
+
+// Point encodings shared by the signature schemes. A compressed point is
+// 0x02 or 0x03 (the parity of y) followed by x; y is recovered from the
+// curve equation.
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+)
+
+func hasEvenY(y *big.Int) bool {
+	return y.Bit(0) == 0
+}
+
+// liftX returns the point with the given x and an even y. The curve is
+// y² = x³ + a·x + b; CurveParams has no a, so it is derived from the generator.
+func liftX(curve elliptic.Curve, x *big.Int) (*big.Int, *big.Int, bool) {
+	params := curve.Params()
+	p := params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 {
+		return nil, nil, false
+	}
+
+	// a = (Gy² - Gx³ - b) / Gx
+	a := new(big.Int).Mul(params.Gy, params.Gy)
+	a.Sub(a, new(big.Int).Exp(params.Gx, big.NewInt(3), p))
+	a.Sub(a, params.B)
+	a.Mul(a, new(big.Int).ModInverse(params.Gx, p))
+	a.Mod(a, p)
+
+	c := new(big.Int).Exp(x, big.NewInt(3), p)
+	c.Add(c, new(big.Int).Mul(a, x))
+	c.Add(c, params.B)
+	c.Mod(c, p)
+	y := new(big.Int).ModSqrt(c, p)
+	if y == nil {
+		return nil, nil, false
+	}
+	if !hasEvenY(y) {
+		y.Sub(p, y)
+	}
+	return new(big.Int).Set(x), y, true
+}
+
+// CompressPoint encodes (x, y) in 33 bytes
+func CompressPoint(x, y *big.Int) []byte {
+	out := make([]byte, 33)
+	out[0] = 0x02
+	if !hasEvenY(y) {
+		out[0] = 0x03
+	}
+	x.FillBytes(out[1:])
+	return out
+}
+
+// DecompressPoint decodes a compressed point, checking that it is on the
+// curve
+func DecompressPoint(curve elliptic.Curve, data []byte) (*big.Int, *big.Int, error) {
+	if len(data) != 33 || (data[0] != 0x02 && data[0] != 0x03) {
+		return nil, nil, errors.New("compressed point must be 0x02 or 0x03 followed by 32 bytes")
+	}
+	x, y, ok := liftX(curve, new(big.Int).SetBytes(data[1:]))
+	if !ok {
+		return nil, nil, errors.New("compressed point is not on the curve")
+	}
+	if data[0] == 0x03 {
+		y.Sub(curve.Params().P, y)
+	}
+	return x, y, nil
+}
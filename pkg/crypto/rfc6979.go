@@ -0,0 +1,129 @@
+package crypto
+
+// Deterministic ECDSA nonces, following RFC 6979. The nonce k is derived
+// from the private key and the message with HMAC-DRBG, so signing needs no
+// randomness and the same key and message always give the same signature.
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"hash"
+	"math/big"
+)
+
+// rfc6979 is the HMAC-DRBG of RFC 6979 section 3.2 for one key and message
+type rfc6979 struct {
+	q    *big.Int // Order of the curve
+	qlen int      // Bit length of q
+	mac  func(key []byte, data ...[]byte) []byte
+	k, v []byte
+	used bool // A candidate was already returned
+}
+
+// newRFC6979 seeds the generator with the private key x and the message hash.
+// extra, if not empty, is the additional data of section 3.6 (for example
+// fresh randomness), mixed in without losing determinism for equal inputs.
+func newRFC6979(q, x *big.Int, hash []byte, newHash func() hash.Hash, extra []byte) *rfc6979 {
+	g := &rfc6979{q: q, qlen: q.BitLen()}
+	g.mac = func(key []byte, data ...[]byte) []byte {
+		m := hmac.New(newHash, key)
+		for _, d := range data {
+			m.Write(d)
+		}
+		return m.Sum(nil)
+	}
+
+	holen := newHash().Size()
+	g.v = make([]byte, holen) // V = 0x01 0x01 ... 0x01
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	g.k = make([]byte, holen) // K = 0x00 0x00 ... 0x00
+
+	key := g.int2octets(x)
+	msg := g.bits2octets(hash)
+	g.k = g.mac(g.k, g.v, []byte{0x00}, key, msg, extra)
+	g.v = g.mac(g.k, g.v)
+	g.k = g.mac(g.k, g.v, []byte{0x01}, key, msg, extra)
+	g.v = g.mac(g.k, g.v)
+	return g
+}
+
+// next returns the next candidate nonce in [1, q-1]. Every candidate after
+// the first, whether out of range or rejected by the caller (r or s zero),
+// is preceded by the K and V update of section 3.2 step h.3.
+func (g *rfc6979) next() *big.Int {
+	for {
+		if g.used {
+			g.k = g.mac(g.k, g.v, []byte{0x00})
+			g.v = g.mac(g.k, g.v)
+		}
+		g.used = true
+
+		var t []byte
+		for len(t)*8 < g.qlen {
+			g.v = g.mac(g.k, g.v)
+			t = append(t, g.v...)
+		}
+		k := g.bits2int(t)
+		if k.Sign() > 0 && k.Cmp(g.q) < 0 {
+			return k
+		}
+	}
+}
+
+// bits2int keeps the leftmost qlen bits of b (section 2.3.2)
+func (g *rfc6979) bits2int(b []byte) *big.Int {
+	v := new(big.Int).SetBytes(b)
+	if extra := len(b)*8 - g.qlen; extra > 0 {
+		v.Rsh(v, uint(extra))
+	}
+	return v
+}
+
+// int2octets writes x big endian in ceil(qlen/8) bytes (section 2.3.3)
+func (g *rfc6979) int2octets(x *big.Int) []byte {
+	return x.FillBytes(make([]byte, (g.qlen+7)/8))
+}
+
+// bits2octets reduces the hash modulo q (section 2.3.4)
+func (g *rfc6979) bits2octets(b []byte) []byte {
+	z := g.bits2int(b)
+	if z.Cmp(g.q) >= 0 {
+		z.Sub(z, g.q)
+	}
+	return g.int2octets(z)
+}
+
+// NonceRFC6979 returns the first nonce RFC 6979 derives for signing hash with
+// the private key x on a curve of order q, using HMAC with newHash
+func NonceRFC6979(q, x *big.Int, hash []byte, newHash func() hash.Hash, extra []byte) *big.Int {
+	return newRFC6979(q, x, hash, newHash, extra).next()
+}
+
+// SignRFC6979 signs hash with priv like ecdsa.Sign, but with the
+// deterministic nonce of RFC 6979 instead of a random one
+func SignRFC6979(priv *ecdsa.PrivateKey, hash []byte, newHash func() hash.Hash, extra []byte) (r, s *big.Int) {
+	n := priv.Curve.Params().N
+	g := newRFC6979(n, priv.D, hash, newHash, extra)
+	e := g.bits2int(hash)
+	for {
+		k := g.next()
+
+		// r = x(kG) mod n
+		x, _ := priv.Curve.ScalarBaseMult(g.int2octets(k))
+		r = new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = k⁻¹(e + r·d) mod n
+		s = new(big.Int).Mul(r, priv.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() != 0 {
+			return r, s
+		}
+	}
+}
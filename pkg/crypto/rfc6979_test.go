@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"math/big"
+	"testing"
+)
+
+func hexInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		t.Fatalf("invalid hex %q", s)
+	}
+	return v
+}
+
+// Test vectors of RFC 6979 appendix A.2.5 (ECDSA, P-256)
+func TestRFC6979Vectors(t *testing.T) {
+	curve := elliptic.P256()
+	priv := &ecdsa.PrivateKey{D: hexInt(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")}
+	priv.Curve = curve
+	priv.X, priv.Y = curve.ScalarBaseMult(priv.D.Bytes())
+	if priv.X.Cmp(hexInt(t, "60FED4BA255A9D31C961EB74C6356D68C049B8923B61FA6CE669622E60F29FB6")) != 0 {
+		t.Fatalf("wrong public key for the test key")
+	}
+
+	cases := []struct {
+		msg     string
+		newHash func() hash.Hash
+		k, r, s string
+	}{
+		{"sample", sha256.New,
+			"A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8"},
+		{"test", sha256.New,
+			"D16B6AE827F17175E040871A1C7EC3500192C4C92677336EC2537ACAEE0008E0",
+			"F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+			"019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083"},
+		{"sample", sha512.New,
+			"5FA81C63109BADB88C1F367B47DA606DA28CAD69AA22C4FE6AD7DF73A7173AA5",
+			"8496A60B5E9B47C825488827E0495B0E3FA109EC4568FD3F8D1097678EB97F00",
+			"2362AB1ADBE2B8ADF9CB9EDAB740EA6049C028114F2460F96554F61FAE3302FE"},
+	}
+	for _, c := range cases {
+		h := c.newHash()
+		h.Write([]byte(c.msg))
+		digest := h.Sum(nil)
+
+		if k := NonceRFC6979(curve.Params().N, priv.D, digest, c.newHash, nil); k.Cmp(hexInt(t, c.k)) != 0 {
+			t.Errorf("%s (%d-byte hash): k = %X", c.msg, len(digest), k)
+		}
+		r, s := SignRFC6979(priv, digest, c.newHash, nil)
+		if r.Cmp(hexInt(t, c.r)) != 0 || s.Cmp(hexInt(t, c.s)) != 0 {
+			t.Errorf("%s (%d-byte hash): r = %X, s = %X", c.msg, len(digest), r, s)
+		}
+		if !ecdsa.Verify(&priv.PublicKey, digest, r, s) {
+			t.Errorf("%s (%d-byte hash): signature does not verify", c.msg, len(digest))
+		}
+	}
+}
+
+func TestRFC6979ExtraEntropy(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+
+	r1, s1 := SignRFC6979(priv, digest[:], sha256.New, nil)
+	r2, s2 := SignRFC6979(priv, digest[:], sha256.New, nil)
+	if r1.Cmp(r2) != 0 || s1.Cmp(s2) != 0 {
+		t.Errorf("same key and message gave different signatures")
+	}
+	r3, s3 := SignRFC6979(priv, digest[:], sha256.New, []byte("extra"))
+	if r3.Cmp(r1) == 0 {
+		t.Errorf("extra entropy did not change the nonce")
+	}
+	if !ecdsa.Verify(&priv.PublicKey, digest[:], r3, s3) {
+		t.Errorf("signature with extra entropy does not verify")
+	}
+}
+
+// A nonce rejected by the signer is followed by the same K and V update as
+// one out of range (RFC 6979 section 3.2 step h.3)
+func TestRFC6979NextCandidate(t *testing.T) {
+	q := elliptic.P256().Params().N
+	x := hexInt(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")
+	hash := sha256.Sum256([]byte("sample"))
+
+	g := newRFC6979(q, x, hash[:], sha256.New, nil)
+	first := g.next()
+	second := g.next()
+
+	ref := newRFC6979(q, x, hash[:], sha256.New, nil)
+	ref.v = ref.mac(ref.k, ref.v)
+	if ref.bits2int(ref.v).Cmp(first) != 0 {
+		t.Fatalf("first candidate is not T = HMAC_K(V)")
+	}
+	ref.k = ref.mac(ref.k, ref.v, []byte{0x00})
+	ref.v = ref.mac(ref.k, ref.v)
+	ref.v = ref.mac(ref.k, ref.v)
+	if want := ref.bits2int(ref.v); second.Cmp(want) != 0 {
+		t.Errorf("second candidate %x, expected %x", second, want)
+	}
+}
@@ -0,0 +1,444 @@
+package crypto
+
+// Schnorr signatures in the style of BIP-340, over any curve whose field
+// elements and scalars fit in 32 bytes (secp256k1, where they match BIP-340
+// exactly, and P-256). Public keys are x-only: a key stands for the point with
+// that x and an even y. A signature is R.x || s, with s·G = R + e·P.
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// SchnorrKeySize and SchnorrSigSize are the lengths of x-only public keys and
+// signatures
+const (
+	SchnorrKeySize = 32
+	SchnorrSigSize = 64
+)
+
+// taggedHash is SHA256(SHA256(tag) || SHA256(tag) || data...). The tag keeps
+// hashes computed for one purpose from being valid for another.
+func taggedHash(tag string, data ...[]byte) []byte {
+	t := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(t[:])
+	h.Write(t[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// bytes32 writes v big endian in 32 bytes
+func bytes32(v *big.Int) []byte {
+	return v.FillBytes(make([]byte, 32))
+}
+
+func isInfinity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+// checkSchnorrCurve rejects curves whose elements do not fit in 32 bytes
+func checkSchnorrCurve(curve elliptic.Curve) error {
+	params := curve.Params()
+	if params.P.BitLen() > 256 || params.N.BitLen() > 256 {
+		return errors.New("schnorr: curve too large")
+	}
+	return nil
+}
+
+// challenge is e = H(R.x || P.x || msg) mod n
+func challenge(n *big.Int, rx, px []byte, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rx, px, msg))
+	return e.Mod(e, n)
+}
+
+// SchnorrPubKey returns the x-only public key of the private key d
+func SchnorrPubKey(curve elliptic.Curve, d *big.Int) []byte {
+	x, _ := curve.ScalarBaseMult(bytes32(d))
+	return bytes32(x)
+}
+
+// SignSchnorr signs msg with the private key d. aux is 32 bytes of auxiliary
+// randomness mixed into the nonce; nil uses zeros, which makes the signature
+// deterministic (the nonce still depends on the key and the message).
+func SignSchnorr(curve elliptic.Curve, d *big.Int, msg, aux []byte) ([]byte, error) {
+	if err := checkSchnorrCurve(curve); err != nil {
+		return nil, err
+	}
+	n := curve.Params().N
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, errors.New("schnorr: invalid private key")
+	}
+	if aux == nil {
+		aux = make([]byte, 32)
+	}
+	if len(aux) != 32 {
+		return nil, errors.New("schnorr: auxiliary randomness must be 32 bytes")
+	}
+
+	// The public key is the even-y point, so negate d if d·G has an odd y
+	px, py := curve.ScalarBaseMult(bytes32(d))
+	if !hasEvenY(py) {
+		d = new(big.Int).Sub(n, d)
+	}
+	pxBytes := bytes32(px)
+
+	// k = H(d xor H(aux) || P.x || msg) mod n
+	t := bytes32(d)
+	for i, b := range taggedHash("BIP0340/aux", aux) {
+		t[i] ^= b
+	}
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, pxBytes, msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, errors.New("schnorr: nonce is zero")
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if !hasEvenY(ry) {
+		k.Sub(n, k)
+	}
+	rxBytes := bytes32(rx)
+
+	// s = k + e·d mod n
+	s := challenge(n, rxBytes, pxBytes, msg)
+	s.Mul(s, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(rxBytes, bytes32(s)...), nil
+}
+
+// parseSchnorr decodes a public key and a signature, checking that the key is
+// on the curve and that r < p and s < n
+func parseSchnorr(curve elliptic.Curve, pub, sig []byte) (px, py, r, s *big.Int, ok bool) {
+	if len(pub) != SchnorrKeySize || len(sig) != SchnorrSigSize || checkSchnorrCurve(curve) != nil {
+		return nil, nil, nil, nil, false
+	}
+	params := curve.Params()
+	px, py, ok = liftX(curve, new(big.Int).SetBytes(pub))
+	if !ok {
+		return nil, nil, nil, nil, false
+	}
+	r = new(big.Int).SetBytes(sig[:32])
+	s = new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(params.P) >= 0 || s.Cmp(params.N) >= 0 {
+		return nil, nil, nil, nil, false
+	}
+	return px, py, r, s, true
+}
+
+// VerifySchnorr reports whether sig is a valid signature of msg by the
+// x-only public key pub
+func VerifySchnorr(curve elliptic.Curve, pub, msg, sig []byte) bool {
+	px, py, r, s, ok := parseSchnorr(curve, pub, sig)
+	if !ok {
+		return false
+	}
+	n := curve.Params().N
+
+	// R = s·G - e·P must have an even y and x = r
+	e := challenge(n, sig[:32], pub, msg)
+	negE := new(big.Int).Sub(n, e)
+	negE.Mod(negE, n)
+	x1, y1 := curve.ScalarBaseMult(bytes32(s))
+	x2, y2 := curve.ScalarMult(px, py, bytes32(negE))
+	rx, ry := curve.Add(x1, y1, x2, y2)
+	return !isInfinity(rx, ry) && hasEvenY(ry) && rx.Cmp(r) == 0
+}
+
+// multiScalarMult returns Σ k_i·P_i. All the terms share the same doublings
+// (Straus' method), so n terms cost one scalar multiplication plus additions
+// instead of n full multiplications. It is not constant time and must only
+// be used with public scalars.
+func multiScalarMult(curve elliptic.Curve, xs, ys, ks []*big.Int) (*big.Int, *big.Int) {
+	accX, accY := new(big.Int), new(big.Int)
+	bits := 0
+	for _, k := range ks {
+		if k.BitLen() > bits {
+			bits = k.BitLen()
+		}
+	}
+	for bit := bits - 1; bit >= 0; bit-- {
+		accX, accY = curve.Double(accX, accY)
+		for i, k := range ks {
+			if k.Bit(bit) == 1 {
+				accX, accY = curve.Add(accX, accY, xs[i], ys[i])
+			}
+		}
+	}
+	return accX, accY
+}
+
+// BatchVerifySchnorr reports whether every sigs[i] is a valid signature of
+// msgs[i] by pubs[i]. With weights a_1 = 1 and a_i pseudo-random it checks
+//
+//	Σ a_i·R_i + Σ a_i·e_i·P_i - (Σ a_i·s_i)·G = 0
+//
+// in a single multi-scalar multiplication. The weights come from a hash of
+// all the inputs, so a batch with an invalid signature passes with negligible
+// probability. It does not say which signature is invalid.
+func BatchVerifySchnorr(curve elliptic.Curve, pubs, msgs, sigs [][]byte) bool {
+	if len(pubs) != len(msgs) || len(pubs) != len(sigs) {
+		return false
+	}
+	if len(pubs) == 0 {
+		return true
+	}
+	n := curve.Params().N
+
+	seed := sha256.New()
+	for i := range pubs {
+		seed.Write(pubs[i])
+		seed.Write(bytes32(big.NewInt(int64(len(msgs[i])))))
+		seed.Write(msgs[i])
+		seed.Write(sigs[i])
+	}
+	seedBytes := seed.Sum(nil)
+
+	var xs, ys, ks []*big.Int
+	sum := new(big.Int)
+	for i := range pubs {
+		px, py, r, s, ok := parseSchnorr(curve, pubs[i], sigs[i])
+		if !ok {
+			return false
+		}
+		rx, ry, ok := liftX(curve, r)
+		if !ok {
+			return false
+		}
+
+		a := big.NewInt(1)
+		if i > 0 {
+			a.SetBytes(taggedHash("BIP0340/batch", seedBytes, bytes32(big.NewInt(int64(i)))))
+			a.Mod(a, n)
+			if a.Sign() == 0 {
+				a.SetInt64(1)
+			}
+		}
+		e := challenge(n, sigs[i][:32], pubs[i], msgs[i])
+		ae := e.Mul(e, a)
+		ae.Mod(ae, n)
+
+		xs = append(xs, rx, px)
+		ys = append(ys, ry, py)
+		ks = append(ks, a, ae)
+		sum.Add(sum, new(big.Int).Mul(a, s))
+	}
+	// -(Σ a_i·s_i)·G
+	sum.Mod(sum, n)
+	sum.Sub(n, sum)
+	sum.Mod(sum, n)
+	params := curve.Params()
+	xs = append(xs, params.Gx)
+	ys = append(ys, params.Gy)
+	ks = append(ks, sum)
+
+	x, y := multiScalarMult(curve, xs, ys, ks)
+	return isInfinity(x, y)
+}
+
+// SchnorrKeyAgg combines the x-only keys of several signers into one key, with
+// the MuSig coefficients: Q = Σ a_i·P_i, a_i = H(H(P_1 || ... || P_n) || P_i).
+// The coefficients keep a signer from choosing its key as a function of the
+// others' to cancel them out. All signers must build it with the keys in the
+// same order.
+type SchnorrKeyAgg struct {
+	curve  elliptic.Curve
+	keys   [][]byte
+	coefs  []*big.Int
+	qx, qy *big.Int // Aggregated point, with its actual y
+}
+
+// AggregateSchnorrKeys aggregates the x-only public keys pubs
+func AggregateSchnorrKeys(curve elliptic.Curve, pubs [][]byte) (*SchnorrKeyAgg, error) {
+	if err := checkSchnorrCurve(curve); err != nil {
+		return nil, err
+	}
+	if len(pubs) == 0 {
+		return nil, errors.New("schnorr: no keys to aggregate")
+	}
+	n := curve.Params().N
+	agg := &SchnorrKeyAgg{curve: curve}
+	list := taggedHash("KeyAgg list", pubs...)
+
+	var xs, ys []*big.Int
+	for i, pub := range pubs {
+		if len(pub) != SchnorrKeySize {
+			return nil, errors.New("schnorr: public keys must be 32 bytes")
+		}
+		x, y, ok := liftX(curve, new(big.Int).SetBytes(pub))
+		if !ok {
+			return nil, errors.New("schnorr: public key is not on the curve")
+		}
+		a := new(big.Int).SetBytes(taggedHash("KeyAgg coefficient", list, pub))
+		a.Mod(a, n)
+		if a.Sign() == 0 {
+			return nil, errors.New("schnorr: zero key coefficient")
+		}
+		agg.keys = append(agg.keys, append([]byte(nil), pubs[i]...))
+		agg.coefs = append(agg.coefs, a)
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+	agg.qx, agg.qy = multiScalarMult(curve, xs, ys, agg.coefs)
+	if isInfinity(agg.qx, agg.qy) {
+		return nil, errors.New("schnorr: aggregated key is the point at infinity")
+	}
+	return agg, nil
+}
+
+// PubKey returns the aggregated x-only public key. Signatures built with
+// PartialSign and CombineSignatures verify against it with VerifySchnorr.
+func (agg *SchnorrKeyAgg) PubKey() []byte {
+	return bytes32(agg.qx)
+}
+
+// Point returns the aggregated key as the point with an even y
+func (agg *SchnorrKeyAgg) Point() (*big.Int, *big.Int) {
+	y := new(big.Int).Set(agg.qy)
+	if !hasEvenY(y) {
+		y.Sub(agg.curve.Params().P, y)
+	}
+	return new(big.Int).Set(agg.qx), y
+}
+
+// SchnorrNonce is the secret nonce of one signer for one aggregated
+// signature. It is cleared when used, since signing two messages with the
+// same nonce reveals the private key.
+type SchnorrNonce struct {
+	k      *big.Int
+	Public []byte // k·G, uncompressed (0x04 || x || y), to share with the other signers
+}
+
+// NewSchnorrNonce draws a random nonce
+func NewSchnorrNonce(curve elliptic.Curve, rand io.Reader) (*SchnorrNonce, error) {
+	n := curve.Params().N
+	k, err := randScalar(rand, n)
+	if err != nil {
+		return nil, err
+	}
+	x, y := curve.ScalarBaseMult(bytes32(k))
+	public := append([]byte{0x04}, bytes32(x)...)
+	return &SchnorrNonce{k: k, Public: append(public, bytes32(y)...)}, nil
+}
+
+// randScalar returns a uniform scalar in [1, n-1]
+func randScalar(rand io.Reader, n *big.Int) (*big.Int, error) {
+	buf := make([]byte, (n.BitLen()+7)/8+16) // 128 extra bits make the bias negligible
+	if _, err := io.ReadFull(rand, buf); err != nil {
+		return nil, err
+	}
+	k := new(big.Int).SetBytes(buf)
+	k.Mod(k, new(big.Int).Sub(n, big.NewInt(1)))
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// aggregateNonces returns R = Σ R_i
+func (agg *SchnorrKeyAgg) aggregateNonces(nonces [][]byte) (*big.Int, *big.Int, error) {
+	rx, ry := new(big.Int), new(big.Int)
+	for _, nonce := range nonces {
+		if len(nonce) != 65 || nonce[0] != 0x04 {
+			return nil, nil, errors.New("schnorr: public nonces must be uncompressed points")
+		}
+		x := new(big.Int).SetBytes(nonce[1:33])
+		y := new(big.Int).SetBytes(nonce[33:])
+		if !agg.curve.IsOnCurve(x, y) {
+			return nil, nil, errors.New("schnorr: public nonce is not on the curve")
+		}
+		rx, ry = agg.curve.Add(rx, ry, x, y)
+	}
+	if isInfinity(rx, ry) {
+		return nil, nil, errors.New("schnorr: aggregated nonce is the point at infinity")
+	}
+	return rx, ry, nil
+}
+
+// PartialSign returns the share of the signature of msg for the signer with
+// private key d, given its secret nonce and the public nonces of all the
+// signers (its own included):
+//
+//	s_i = k_i + e·a_i·d_i, with e = H(R.x || Q.x || msg) and R = Σ R_i
+//
+// with k_i and d_i negated as needed so that R and Q have an even y.
+//
+// This is the simple two-round protocol: every signer must know all the
+// public nonces before any partial signature is released, and a nonce is
+// never reused. With untrusted co-signers running many sessions at once,
+// exchange hashes of the nonces first (the commitment round of MuSig).
+func (agg *SchnorrKeyAgg) PartialSign(d *big.Int, nonce *SchnorrNonce, nonces [][]byte, msg []byte) ([]byte, error) {
+	if nonce == nil || nonce.k == nil {
+		return nil, errors.New("schnorr: nonce already used")
+	}
+	k := nonce.k
+	nonce.k = nil
+
+	n := agg.curve.Params().N
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, errors.New("schnorr: invalid private key")
+	}
+	px, py := agg.curve.ScalarBaseMult(bytes32(d))
+	pub := bytes32(px)
+	idx := -1
+	for i, key := range agg.keys {
+		if string(key) == string(pub) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, errors.New("schnorr: private key is not one of the aggregated keys")
+	}
+
+	rx, ry, err := agg.aggregateNonces(nonces)
+	if err != nil {
+		return nil, err
+	}
+
+	// d·G must be the even-y P_i, and the sum of the a_i·d_i must be the
+	// discrete log of the even-y Q
+	d = new(big.Int).Set(d)
+	if !hasEvenY(py) {
+		d.Sub(n, d)
+	}
+	if !hasEvenY(agg.qy) {
+		d.Sub(n, d)
+	}
+	k = new(big.Int).Set(k)
+	if !hasEvenY(ry) {
+		k.Sub(n, k)
+	}
+
+	s := challenge(n, bytes32(rx), agg.PubKey(), msg)
+	s.Mul(s, agg.coefs[idx])
+	s.Mul(s, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return bytes32(s), nil
+}
+
+// CombineSignatures sums the partial signatures of all the signers into a
+// signature R.x || Σ s_i for the aggregated key
+func (agg *SchnorrKeyAgg) CombineSignatures(nonces, partials [][]byte) ([]byte, error) {
+	rx, _, err := agg.aggregateNonces(nonces)
+	if err != nil {
+		return nil, err
+	}
+	n := agg.curve.Params().N
+	s := new(big.Int)
+	for _, partial := range partials {
+		if len(partial) != 32 {
+			return nil, errors.New("schnorr: partial signatures must be 32 bytes")
+		}
+		si := new(big.Int).SetBytes(partial)
+		if si.Cmp(n) >= 0 {
+			return nil, errors.New("schnorr: partial signature out of range")
+		}
+		s.Add(s, si)
+	}
+	s.Mod(s, n)
+	return append(bytes32(rx), bytes32(s)...), nil
+}
@@ -0,0 +1,206 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q", s)
+	}
+	return b
+}
+
+// Test vectors 0 to 2 of BIP-340 (secp256k1)
+func TestSchnorrBIP340Vectors(t *testing.T) {
+	curve := Secp256k1()
+	cases := []struct{ key, pub, aux, msg, sig string }{
+		{"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0"},
+		{"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A"},
+		{"C90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B14E5C9",
+			"DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+			"C87AA53824B4D7AE2EB035A2B5BBBCCC080E76CDC6D1692C4B0B62D798E6D906",
+			"7E2D58D8B3BCDF1ABADEC7829054F90DDA9805AAB56C77333024B9D0A508B75C",
+			"5831AAEED7B44BB74E5EAB94BA9D4294C49BCF2A60728D8B4C200F50DD313C1BAB745879A5AD954A72C45A91C3A51D3C7ADEA98D82F8481E0E1E03674A6F3FB7"},
+	}
+	for i, c := range cases {
+		d := hexInt(t, c.key)
+		pub, msg := unhex(t, c.pub), unhex(t, c.msg)
+		if got := SchnorrPubKey(curve, d); !bytes.Equal(got, pub) {
+			t.Errorf("vector %d: public key %X", i, got)
+		}
+		sig, err := SignSchnorr(curve, d, msg, unhex(t, c.aux))
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if !bytes.Equal(sig, unhex(t, c.sig)) {
+			t.Errorf("vector %d: signature %X", i, sig)
+		}
+		if !VerifySchnorr(curve, pub, msg, sig) {
+			t.Errorf("vector %d: signature does not verify", i)
+		}
+	}
+}
+
+func TestSchnorrRejectsInvalid(t *testing.T) {
+	for _, curve := range []elliptic.Curve{Secp256k1(), elliptic.P256()} {
+		name := curve.Params().Name
+		d, _ := randScalar(rand.Reader, curve.Params().N)
+		pub := SchnorrPubKey(curve, d)
+		msg := bytes.Repeat([]byte{0x42}, 32)
+		sig, err := SignSchnorr(curve, d, msg, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !VerifySchnorr(curve, pub, msg, sig) {
+			t.Fatalf("%s: valid signature rejected", name)
+		}
+
+		tampered := func(i int) []byte {
+			b := append([]byte(nil), sig...)
+			b[i] ^= 1
+			return b
+		}
+		n := bytes32(curve.Params().N)
+		p := bytes32(curve.Params().P)
+		cases := map[string][]byte{
+			"r changed":  tampered(0),
+			"s changed":  tampered(63),
+			"s = s + n":  append(sig[:32:32], n...),
+			"r >= p":     append(p, sig[32:]...),
+			"truncated":  sig[:63],
+			"other msg":  nil,
+			"other key":  nil,
+			"key past p": nil,
+		}
+		for what, bad := range cases {
+			key, m := pub, msg
+			switch what {
+			case "other msg":
+				bad, m = sig, bytes.Repeat([]byte{0x43}, 32)
+			case "other key":
+				bad, key = sig, SchnorrPubKey(curve, big.NewInt(7))
+			case "key past p":
+				bad, key = sig, p
+			}
+			if VerifySchnorr(curve, key, m, bad) {
+				t.Errorf("%s: %s accepted", name, what)
+			}
+		}
+	}
+}
+
+func TestSchnorrBatchVerification(t *testing.T) {
+	for _, curve := range []elliptic.Curve{Secp256k1(), elliptic.P256()} {
+		name := curve.Params().Name
+		var pubs, msgs, sigs [][]byte
+		for i := 0; i < 5; i++ {
+			d, _ := randScalar(rand.Reader, curve.Params().N)
+			msg := []byte(strings.Repeat("m", i+1))
+			sig, err := SignSchnorr(curve, d, msg, nil)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			pubs = append(pubs, SchnorrPubKey(curve, d))
+			msgs = append(msgs, msg)
+			sigs = append(sigs, sig)
+		}
+		if !BatchVerifySchnorr(curve, pubs, msgs, sigs) {
+			t.Errorf("%s: valid batch rejected", name)
+		}
+		if !BatchVerifySchnorr(curve, nil, nil, nil) {
+			t.Errorf("%s: empty batch rejected", name)
+		}
+
+		// One bad signature fails the batch
+		bad := append([]byte(nil), sigs[3]...)
+		bad[40] ^= 1
+		badSigs := append(append([][]byte(nil), sigs[:3]...), bad, sigs[4])
+		if BatchVerifySchnorr(curve, pubs, msgs, badSigs) {
+			t.Errorf("%s: batch with an invalid signature accepted", name)
+		}
+
+		// Swapping two signatures keeps every R and s but breaks the batch
+		swapped := append([][]byte(nil), sigs...)
+		swapped[0], swapped[1] = swapped[1], swapped[0]
+		if BatchVerifySchnorr(curve, pubs, msgs, swapped) {
+			t.Errorf("%s: batch with swapped signatures accepted", name)
+		}
+	}
+}
+
+func TestSchnorrKeyAggregation(t *testing.T) {
+	for _, curve := range []elliptic.Curve{Secp256k1(), elliptic.P256()} {
+		name := curve.Params().Name
+		var keys []*big.Int
+		var pubs [][]byte
+		for i := 0; i < 3; i++ {
+			d, _ := randScalar(rand.Reader, curve.Params().N)
+			keys = append(keys, d)
+			pubs = append(pubs, SchnorrPubKey(curve, d))
+		}
+		agg, err := AggregateSchnorrKeys(curve, pubs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		msg := []byte("spend the shared output")
+		var secret []*SchnorrNonce
+		var nonces [][]byte
+		for range keys {
+			nonce, err := NewSchnorrNonce(curve, rand.Reader)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			secret = append(secret, nonce)
+			nonces = append(nonces, nonce.Public)
+		}
+		var partials [][]byte
+		for i, d := range keys {
+			s, err := agg.PartialSign(d, secret[i], nonces, msg)
+			if err != nil {
+				t.Fatalf("%s: PartialSign: %v", name, err)
+			}
+			partials = append(partials, s)
+		}
+		sig, err := agg.CombineSignatures(nonces, partials)
+		if err != nil {
+			t.Fatalf("%s: CombineSignatures: %v", name, err)
+		}
+		if !VerifySchnorr(curve, agg.PubKey(), msg, sig) {
+			t.Errorf("%s: aggregated signature does not verify", name)
+		}
+
+		// Missing a signer, reusing a nonce or signing with another key fails
+		short, _ := agg.CombineSignatures(nonces, partials[:2])
+		if VerifySchnorr(curve, agg.PubKey(), msg, short) {
+			t.Errorf("%s: signature without every signer accepted", name)
+		}
+		if _, err := agg.PartialSign(keys[0], secret[0], nonces, msg); err == nil {
+			t.Errorf("%s: nonce used twice", name)
+		}
+		outsider, _ := NewSchnorrNonce(curve, rand.Reader)
+		if _, err := agg.PartialSign(big.NewInt(7), outsider, nonces, msg); err == nil {
+			t.Errorf("%s: key outside the aggregation signed", name)
+		}
+		if other, _ := AggregateSchnorrKeys(curve, [][]byte{pubs[1], pubs[0], pubs[2]}); bytes.Equal(other.PubKey(), agg.PubKey()) {
+			t.Errorf("%s: key order does not change the aggregated key", name)
+		}
+	}
+}
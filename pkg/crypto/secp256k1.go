@@ -0,0 +1,360 @@
+/* secp256k1.go */
+package crypto
+
+// Implementation of the secp256k1 curve (SEC 2, section 2.4.1) from scratch.
+// Only for educational purposes.
+//
+// The curve is y² = x³ + 7 over the prime field of
+// p = 2²⁵⁶ - 2³² - 977. Field elements are four 64-bit limbs and every
+// operation runs the same instructions whatever the values, so the time it
+// takes does not leak secret scalars:
+//
+//   - additions and subtractions reduce with masks instead of branches,
+//   - points use the complete addition formulas of Renes, Costello and Batina
+//     (2015), which have no special cases for doubling or the identity,
+//   - scalar multiplication is a Montgomery ladder with constant-time swaps.
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/bits"
+)
+
+// fe is a field element modulo p, four little-endian limbs, always < p
+type fe [4]uint64
+
+// feP is the field prime p = 2²⁵⁶ - 2³² - 977
+var feP = fe{0xFFFFFFFEFFFFFC2F, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF}
+
+// feR is 2²⁵⁶ mod p, used to fold the high half of a product into the low half
+const feR = 0x1000003D1
+
+// feSelect returns a if mask is all ones and b if it is zero
+func feSelect(mask uint64, a, b *fe) fe {
+	return fe{
+		(a[0] & mask) | (b[0] &^ mask),
+		(a[1] & mask) | (b[1] &^ mask),
+		(a[2] & mask) | (b[2] &^ mask),
+		(a[3] & mask) | (b[3] &^ mask),
+	}
+}
+
+// feReduce subtracts p from a value below 2p
+func feReduce(a *fe) fe {
+	var s fe
+	var borrow uint64
+	s[0], borrow = bits.Sub64(a[0], feP[0], 0)
+	s[1], borrow = bits.Sub64(a[1], feP[1], borrow)
+	s[2], borrow = bits.Sub64(a[2], feP[2], borrow)
+	s[3], borrow = bits.Sub64(a[3], feP[3], borrow)
+	// No borrow: a >= p, keep a - p
+	return feSelect(borrow-1, &s, a)
+}
+
+func feAdd(a, b *fe) fe {
+	var r fe
+	var carry uint64
+	r[0], carry = bits.Add64(a[0], b[0], 0)
+	r[1], carry = bits.Add64(a[1], b[1], carry)
+	r[2], carry = bits.Add64(a[2], b[2], carry)
+	r[3], carry = bits.Add64(a[3], b[3], carry)
+
+	var s fe
+	var borrow uint64
+	s[0], borrow = bits.Sub64(r[0], feP[0], 0)
+	s[1], borrow = bits.Sub64(r[1], feP[1], borrow)
+	s[2], borrow = bits.Sub64(r[2], feP[2], borrow)
+	s[3], borrow = bits.Sub64(r[3], feP[3], borrow)
+	// The sum is >= p if it overflowed 256 bits or subtracting p did not borrow
+	return feSelect(-(carry | (borrow ^ 1)), &s, &r)
+}
+
+func feSub(a, b *fe) fe {
+	var r fe
+	var borrow uint64
+	r[0], borrow = bits.Sub64(a[0], b[0], 0)
+	r[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	r[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	r[3], borrow = bits.Sub64(a[3], b[3], borrow)
+
+	// Went below zero: add p back
+	mask := -borrow
+	var carry uint64
+	r[0], carry = bits.Add64(r[0], feP[0]&mask, 0)
+	r[1], carry = bits.Add64(r[1], feP[1]&mask, carry)
+	r[2], carry = bits.Add64(r[2], feP[2]&mask, carry)
+	r[3], _ = bits.Add64(r[3], feP[3]&mask, carry)
+	return r
+}
+
+func feMul(a, b *fe) fe {
+	// Schoolbook product into eight limbs
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j] = lo
+			carry = hi
+		}
+		t[i+4] = carry
+	}
+
+	// t = lo + hi·2²⁵⁶ ≡ lo + hi·feR (mod p)
+	var r [5]uint64
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		hi, lo := bits.Mul64(t[4+i], feR)
+		var c uint64
+		lo, c = bits.Add64(lo, t[i], 0)
+		hi += c
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		r[i] = lo
+		carry = hi
+	}
+	r[4] = carry // < 2³⁴
+
+	// Fold the fifth limb the same way
+	hi, lo := bits.Mul64(r[4], feR)
+	var c uint64
+	r[0], c = bits.Add64(r[0], lo, 0)
+	r[1], c = bits.Add64(r[1], hi, c)
+	r[2], c = bits.Add64(r[2], 0, c)
+	r[3], c = bits.Add64(r[3], 0, c)
+
+	// A last carry means 2²⁵⁶ more, that is feR more; r is small by then
+	r[0], c = bits.Add64(r[0], c*feR, 0)
+	r[1], c = bits.Add64(r[1], 0, c)
+	r[2], c = bits.Add64(r[2], 0, c)
+	r[3], _ = bits.Add64(r[3], 0, c)
+
+	return feReduce(&fe{r[0], r[1], r[2], r[3]})
+}
+
+// feInv returns a⁻¹ = a^(p-2) (Fermat). The exponent is public, so the
+// branches below do not depend on secrets.
+func feInv(a *fe) fe {
+	e := new(big.Int).Sub(feToBig(&feP), big.NewInt(2))
+	r := fe{1}
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		r = feMul(&r, &r)
+		if e.Bit(i) == 1 {
+			r = feMul(&r, a)
+		}
+	}
+	return r
+}
+
+func feIsZero(a *fe) bool {
+	return a[0]|a[1]|a[2]|a[3] == 0
+}
+
+// feFromBig converts v to a field element. It reports false if v is not
+// in [0, p).
+func feFromBig(v *big.Int) (fe, bool) {
+	if v.Sign() < 0 || v.Cmp(secp256k1Params.P) >= 0 {
+		return fe{}, false
+	}
+	var b [32]byte
+	v.FillBytes(b[:])
+	var r fe
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			r[i] |= uint64(b[31-8*i-j]) << (8 * j)
+		}
+	}
+	return r, true
+}
+
+func feToBig(a *fe) *big.Int {
+	var b [32]byte
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[31-8*i-j] = byte(a[i] >> (8 * j))
+		}
+	}
+	return new(big.Int).SetBytes(b[:])
+}
+
+// point is a point in projective coordinates (X:Y:Z), x = X/Z and y = Y/Z.
+// The identity is (0:1:0).
+type point struct {
+	x, y, z fe
+}
+
+// feB3 is 3·b = 21, used by the addition formulas
+var feB3 = fe{21}
+
+// pointAdd returns p + q with algorithm 7 of Renes, Costello and Batina for
+// curves with a = 0. It is complete: it also works for p = q and the identity.
+func pointAdd(p, q *point) point {
+	t0 := feMul(&p.x, &q.x)
+	t1 := feMul(&p.y, &q.y)
+	t2 := feMul(&p.z, &q.z)
+	t3 := feAdd(&p.x, &p.y)
+	t4 := feAdd(&q.x, &q.y)
+	t3 = feMul(&t3, &t4)
+	t4 = feAdd(&t0, &t1)
+	t3 = feSub(&t3, &t4)
+	t4 = feAdd(&p.y, &p.z)
+	x3 := feAdd(&q.y, &q.z)
+	t4 = feMul(&t4, &x3)
+	x3 = feAdd(&t1, &t2)
+	t4 = feSub(&t4, &x3)
+	x3 = feAdd(&p.x, &p.z)
+	y3 := feAdd(&q.x, &q.z)
+	x3 = feMul(&x3, &y3)
+	y3 = feAdd(&t0, &t2)
+	y3 = feSub(&x3, &y3)
+	x3 = feAdd(&t0, &t0)
+	t0 = feAdd(&x3, &t0)
+	t2 = feMul(&feB3, &t2)
+	z3 := feAdd(&t1, &t2)
+	t1 = feSub(&t1, &t2)
+	y3 = feMul(&feB3, &y3)
+	x3 = feMul(&t4, &y3)
+	t2 = feMul(&t3, &t1)
+	x3 = feSub(&t2, &x3)
+	y3 = feMul(&y3, &t0)
+	t1 = feMul(&t1, &z3)
+	y3 = feAdd(&t1, &y3)
+	t0 = feMul(&t0, &t3)
+	z3 = feMul(&z3, &t4)
+	z3 = feAdd(&z3, &t0)
+	return point{x3, y3, z3}
+}
+
+// pointSwap swaps p and q if swap is 1, without branching on it
+func pointSwap(p, q *point, swap uint64) {
+	mask := -swap
+	for _, pair := range [3][2]*fe{{&p.x, &q.x}, {&p.y, &q.y}, {&p.z, &q.z}} {
+		a, b := pair[0], pair[1]
+		for i := range a {
+			t := (a[i] ^ b[i]) & mask
+			a[i] ^= t
+			b[i] ^= t
+		}
+	}
+}
+
+// scalarMult returns k·p with a Montgomery ladder: the same additions run for
+// every bit of k, whatever its value
+func scalarMult(p *point, k []byte) point {
+	r0 := point{y: fe{1}} // identity
+	r1 := *p
+	for _, b := range k {
+		for i := 7; i >= 0; i-- {
+			bit := uint64(b>>i) & 1
+			pointSwap(&r0, &r1, bit)
+			r1 = pointAdd(&r0, &r1)
+			r0 = pointAdd(&r0, &r0)
+			pointSwap(&r0, &r1, bit)
+		}
+	}
+	return r0
+}
+
+// toAffine returns the affine coordinates of p, (0, 0) for the identity as
+// crypto/elliptic expects
+func (p *point) toAffine() (*big.Int, *big.Int) {
+	if feIsZero(&p.z) {
+		return new(big.Int), new(big.Int)
+	}
+	zInv := feInv(&p.z)
+	x := feMul(&p.x, &zInv)
+	y := feMul(&p.y, &zInv)
+	return feToBig(&x), feToBig(&y)
+}
+
+// fromAffine converts affine coordinates, (0, 0) being the identity. It
+// reports false if they are not a point of the curve.
+func fromAffine(x, y *big.Int) (point, bool) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return point{y: fe{1}}, true
+	}
+	fx, okX := feFromBig(x)
+	fy, okY := feFromBig(y)
+	if !okX || !okY || !onCurve(&fx, &fy) {
+		return point{}, false
+	}
+	return point{fx, fy, fe{1}}, true
+}
+
+// onCurve checks y² = x³ + 7
+func onCurve(x, y *fe) bool {
+	y2 := feMul(y, y)
+	x3 := feMul(x, x)
+	x3 = feMul(&x3, x)
+	x3 = feAdd(&x3, &fe{7})
+	return y2 == x3
+}
+
+var secp256k1Params = &elliptic.CurveParams{
+	Name:    "secp256k1",
+	BitSize: 256,
+	P:       hexBig("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F"),
+	N:       hexBig("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141"),
+	B:       big.NewInt(7),
+	Gx:      hexBig("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"),
+	Gy:      hexBig("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"),
+}
+
+func hexBig(s string) *big.Int {
+	v, _ := new(big.Int).SetString(s, 16)
+	return v
+}
+
+// secp256k1Curve implements elliptic.Curve. The generic CurveParams methods
+// assume a = -3, so every operation is implemented here.
+type secp256k1Curve struct{}
+
+// Secp256k1 returns the secp256k1 curve used by Bitcoin. It can be used with
+// crypto/ecdsa, which falls back to these methods for curves it does not know.
+func Secp256k1() elliptic.Curve {
+	return secp256k1Curve{}
+}
+
+func (secp256k1Curve) Params() *elliptic.CurveParams {
+	return secp256k1Params
+}
+
+// IsOnCurve reports whether (x, y) is a point of the curve, with both
+// coordinates reduced modulo p. The identity is not on the curve.
+func (secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	fx, okX := feFromBig(x)
+	fy, okY := feFromBig(y)
+	return okX && okY && onCurve(&fx, &fy)
+}
+
+func (c secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p, ok1 := fromAffine(x1, y1)
+	q, ok2 := fromAffine(x2, y2)
+	if !ok1 || !ok2 {
+		panic("crypto/secp256k1: Add called on a point not on the curve")
+	}
+	r := pointAdd(&p, &q)
+	return r.toAffine()
+}
+
+func (c secp256k1Curve) Double(x, y *big.Int) (*big.Int, *big.Int) {
+	return c.Add(x, y, x, y)
+}
+
+func (secp256k1Curve) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	p, ok := fromAffine(x, y)
+	if !ok {
+		panic("crypto/secp256k1: ScalarMult called on a point not on the curve")
+	}
+	r := scalarMult(&p, k)
+	return r.toAffine()
+}
+
+func (c secp256k1Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(secp256k1Params.Gx, secp256k1Params.Gy, k)
+}
@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+// refScalarMult is a plain affine double-and-add with math/big, used to
+// check the constant-time implementation
+func refScalarMult(x, y, k *big.Int) (*big.Int, *big.Int) {
+	p := secp256k1Params.P
+	add := func(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+		if x1 == nil {
+			return x2, y2
+		}
+		var l *big.Int
+		if x1.Cmp(x2) == 0 {
+			if y1.Cmp(y2) != 0 || y1.Sign() == 0 {
+				return nil, nil
+			}
+			// λ = 3x² / 2y
+			l = new(big.Int).Mul(x1, x1)
+			l.Mul(l, big.NewInt(3))
+			l.Mul(l, new(big.Int).ModInverse(new(big.Int).Lsh(y1, 1), p))
+		} else {
+			// λ = (y2 - y1) / (x2 - x1)
+			l = new(big.Int).Sub(y2, y1)
+			l.Mul(l, new(big.Int).ModInverse(new(big.Int).Mod(new(big.Int).Sub(x2, x1), p), p))
+		}
+		l.Mod(l, p)
+		x3 := new(big.Int).Mul(l, l)
+		x3.Sub(x3, x1).Sub(x3, x2).Mod(x3, p)
+		y3 := new(big.Int).Sub(x1, x3)
+		y3.Mul(y3, l).Sub(y3, y1).Mod(y3, p)
+		return x3, y3
+	}
+	var rx, ry *big.Int
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		if rx != nil {
+			rx, ry = add(rx, ry, rx, ry)
+		}
+		if k.Bit(i) == 1 {
+			rx, ry = add(rx, ry, x, y)
+		}
+	}
+	if rx == nil {
+		return new(big.Int), new(big.Int)
+	}
+	return rx, ry
+}
+
+func TestSecp256k1KnownPoints(t *testing.T) {
+	c := Secp256k1()
+	cases := []struct{ k, x, y string }{
+		{"01", "79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", "483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"},
+		{"02", "C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", "1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A"},
+		{"03", "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9", "388F7B0F632DE8140FE337E62A37F3566500A99934C2231B6CB9FD7584B8E672"},
+		{"AA5E28D6A97A2479A65527F7290311A3624D4CC0FA1578598EE3C2613BF99522", "34F9460F0E4F08393D192B3C5133A6BA099AA0AD9FD54EBCCFACDFA239FF49C6", "0B71EA9BD730FD8923F6D25A7A91E7DD7728A960686CB5A901BB419E0F2CA232"},
+	}
+	for _, tc := range cases {
+		x, y := c.ScalarBaseMult(hexBig(tc.k).Bytes())
+		if x.Cmp(hexBig(tc.x)) != 0 || y.Cmp(hexBig(tc.y)) != 0 {
+			t.Errorf("%s·G = (%X, %X)", tc.k, x, y)
+		}
+		if !c.IsOnCurve(x, y) {
+			t.Errorf("%s·G not on the curve", tc.k)
+		}
+	}
+
+	// n·G is the identity, and adding it changes nothing
+	x, y := c.ScalarBaseMult(secp256k1Params.N.Bytes())
+	if x.Sign() != 0 || y.Sign() != 0 {
+		t.Errorf("n·G = (%X, %X), expected the identity", x, y)
+	}
+	gx, gy := secp256k1Params.Gx, secp256k1Params.Gy
+	if x, y := c.Add(gx, gy, new(big.Int), new(big.Int)); x.Cmp(gx) != 0 || y.Cmp(gy) != 0 {
+		t.Errorf("G + O != G")
+	}
+	if x, y := c.Add(gx, gy, gx, new(big.Int).Sub(secp256k1Params.P, gy)); x.Sign() != 0 || y.Sign() != 0 {
+		t.Errorf("G + (-G) is not the identity")
+	}
+}
+
+func TestSecp256k1MatchesReference(t *testing.T) {
+	c := Secp256k1()
+	gx, gy := secp256k1Params.Gx, secp256k1Params.Gy
+	for i := 0; i < 10; i++ {
+		k, err := rand.Int(rand.Reader, secp256k1Params.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := c.ScalarBaseMult(k.Bytes())
+		rx, ry := refScalarMult(gx, gy, k)
+		if x.Cmp(rx) != 0 || y.Cmp(ry) != 0 {
+			t.Fatalf("k = %X: got (%X, %X), expected (%X, %X)", k, x, y, rx, ry)
+		}
+		// Doubling and adding agree with the scalar multiples
+		dx, dy := c.Double(x, y)
+		ex, ey := refScalarMult(gx, gy, new(big.Int).Lsh(k, 1))
+		if dx.Cmp(ex) != 0 || dy.Cmp(ey) != 0 {
+			t.Fatalf("k = %X: doubling does not match 2k·G", k)
+		}
+	}
+}
+
+func TestSecp256k1PointValidation(t *testing.T) {
+	c := Secp256k1()
+	gx, gy := secp256k1Params.Gx, secp256k1Params.Gy
+	if c.IsOnCurve(gx, new(big.Int).Add(gy, big.NewInt(1))) {
+		t.Errorf("point off the curve accepted")
+	}
+	if c.IsOnCurve(gx, new(big.Int).Add(gy, secp256k1Params.P)) {
+		t.Errorf("unreduced coordinate accepted")
+	}
+	if c.IsOnCurve(new(big.Int), new(big.Int)) {
+		t.Errorf("identity accepted as a public key")
+	}
+}
+
+func TestSecp256k1ECDSA(t *testing.T) {
+	// Common RFC 6979 vector for Bitcoin: key 1, SHA-256("Satoshi Nakamoto").
+	// The published s is the low-S form.
+	c := Secp256k1()
+	priv := &ecdsa.PrivateKey{D: big.NewInt(1)}
+	priv.Curve = c
+	priv.X, priv.Y = c.ScalarBaseMult(priv.D.Bytes())
+	digest := sha256.Sum256([]byte("Satoshi Nakamoto"))
+
+	if k := NonceRFC6979(secp256k1Params.N, priv.D, digest[:], sha256.New, nil); k.Cmp(hexBig("8F8A276C19F4149656B280621E358CCE24F5F52542772691EE69063B74F15D15")) != 0 {
+		t.Errorf("k = %X", k)
+	}
+	r, s := SignRFC6979(priv, digest[:], sha256.New, nil)
+	if half := new(big.Int).Rsh(secp256k1Params.N, 1); s.Cmp(half) > 0 {
+		s.Sub(secp256k1Params.N, s)
+	}
+	if r.Cmp(hexBig("934B1EA10A4B3C1757E2B0C017D0B6143CE3C9A7E6A4A49860D7A6AB210EE3D8")) != 0 ||
+		s.Cmp(hexBig("2442CE9D2B916064108014783E923EC36B49743E2FFA1C4496F01A512AAFD9E5")) != 0 {
+		t.Errorf("r = %X, s = %X", r, s)
+	}
+	if !ecdsa.Verify(&priv.PublicKey, digest[:], r, s) {
+		t.Errorf("crypto/ecdsa rejected a secp256k1 signature")
+	}
+	digest[0] ^= 1
+	if ecdsa.Verify(&priv.PublicKey, digest[:], r, s) {
+		t.Errorf("crypto/ecdsa accepted a signature of another message")
+	}
+}
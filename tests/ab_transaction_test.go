@@ -29,10 +29,10 @@ func TestAliceBobTransactionVerification(t *testing.T) {
 
 	// Create transaction input (Alice's previous output that she's spending)
 	txIn1 := core.TxIn{
-		PrevTx:    []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-		PrevIndex: 0,
-		Signature: []byte{}, // Will be filled after signing
-		Net:       "main",
+		PrevTx:          []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		PrevIndex:       0,
+		UnlockingScript: []byte{}, // Will be filled after signing
+		Net:             "main",
 	}
 
 	// Create transaction outputs
@@ -64,18 +64,24 @@ func TestAliceBobTransactionVerification(t *testing.T) {
 		t.Fatalf("Failed to sign transaction: %v", err)
 	}
 
-	signature := append(r.Bytes(), s.Bytes()...)
-	tx1.TxIns[0].Signature = signature
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	tx1.TxIns[0].UnlockingScript = core.NewScriptBuilder().AddData(signature).Script()
 
 	// Create and test the block
 	block := core.Block{
-		Version:      1,
-		PrevBlock:    []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-		Timestamp:    1234567890,
-		Nonce:        0,
-		Bits:         0,
+		BlockHeader: core.BlockHeader{
+			Version:   1,
+			PrevBlock: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			Timestamp: 1234567890,
+			Nonce:     0,
+			Bits:      0,
+		},
 		Transactions: []core.Tx{tx1},
 	}
+	block.MerkleRoot = block.ComputeMerkleRoot()
+	block.WitnessRoot = block.ComputeWitnessRoot()
 
 	publicKeys := []*ecdsa.PublicKey{alicePublicKey}
 
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestAddresses(t *testing.T) {
+	w, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	address := w.GetAddress()
+	if !strings.HasPrefix(address, "blk1") {
+		t.Errorf("mainnet address %s does not start with blk1", address)
+	}
+	a, err := core.DecodeAddress(address)
+	if err != nil {
+		t.Fatalf("DecodeAddress: %v", err)
+	}
+	if a.Net != "mainnet" || a.Version != core.AddressPubKeyHash || hex.EncodeToString(a.Hash) != w.GetAddressHex() {
+		t.Errorf("decoded %+v", a)
+	}
+	if !bytes.Equal(a.LockingScript(), w.GetLockingScript()) {
+		t.Errorf("address does not pay to the wallet's locking script")
+	}
+	if upper, err := core.DecodeAddress(strings.ToUpper(address)); err != nil || upper.String() != address {
+		t.Errorf("upper case address rejected: %v", err)
+	}
+
+	// P2SH addresses pay to the script hash
+	ms, err := core.NewMultiSig(1, [][]byte{w.PublicKey})
+	if err != nil {
+		t.Fatalf("NewMultiSig: %v", err)
+	}
+	scriptAddr := core.NewScriptAddress("mainnet", ms.RedeemScript)
+	if script, err := core.PayToAddressScript(scriptAddr.String()); err != nil || !bytes.Equal(script, ms.LockingScript()) {
+		t.Errorf("P2SH address does not pay to the redeem script: %v", err)
+	}
+	if back, ok := core.ScriptAddress("mainnet", ms.LockingScript()); !ok || back.String() != scriptAddr.String() {
+		t.Errorf("ScriptAddress of the P2SH script: %v", back)
+	}
+
+	server, _ := fundedChain(t, w)
+	utxos := server.GetUTXOSet()
+	typo := []byte(address)
+	if typo[20] == 'q' {
+		typo[20] = 'p'
+	} else {
+		typo[20] = 'q'
+	}
+	bad := map[string]string{
+		"typo":           string(typo),
+		"legacy hex":     w.GetAddressHex(),
+		"truncated":      address[:len(address)-1],
+		"testnet":        core.NewAddress("testnet", w.PublicKey).String(),
+		"unknown prefix": "xyz" + address[3:],
+		"empty":          "",
+	}
+	for what, dest := range bad {
+		if _, _, err := w.BuildTransactionToAddress(dest, 1000, 1, utxos); err == nil {
+			t.Errorf("%s address accepted: %q", what, dest)
+		}
+	}
+	if _, _, err := w.BuildTransactionToAddress(address, 1000, 1, utxos); err != nil {
+		t.Errorf("valid address rejected: %v", err)
+	}
+}
+
+func TestWalletNetwork(t *testing.T) {
+	bad := core.NewAddress("main", make([]byte, 33))
+	if _, err := bad.Encode(); err == nil {
+		t.Errorf("address of an unknown network encoded")
+	}
+	if s := bad.String(); !strings.HasPrefix(s, "<invalid address") {
+		t.Errorf("address of an unknown network printed as %q", s)
+	}
+
+	w, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	w.Net = "testnet"
+	address := w.GetAddress()
+	if !strings.HasPrefix(address, "tblk1") {
+		t.Errorf("testnet address %s does not start with tblk1", address)
+	}
+
+	// The network is saved with the wallet
+	w.WalletFile = filepath.Join(t.TempDir(), "wallet.json")
+	if err := w.SaveToDisk(); err != nil {
+		t.Fatalf("SaveToDisk: %v", err)
+	}
+	loaded, err := core.LoadWallet(w.WalletFile)
+	if err != nil {
+		t.Fatalf("LoadWallet: %v", err)
+	}
+	if loaded.Net != "testnet" || loaded.GetAddress() != address {
+		t.Errorf("loaded wallet is on %s with address %s", loaded.Net, loaded.GetAddress())
+	}
+
+	// A testnet wallet pays testnet addresses only
+	server, _ := fundedChain(t, w)
+	utxos := server.GetUTXOSet()
+	if _, _, err := w.BuildTransactionToAddress(core.NewAddress("mainnet", w.PublicKey).String(), 1000, 1, utxos); err == nil {
+		t.Errorf("testnet wallet paid a mainnet address")
+	}
+	tx, _, err := w.BuildTransactionToAddress(address, 1000, 1, utxos)
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if tx.TxIns[0].Net != "testnet" {
+		t.Errorf("testnet wallet built an input for %s", tx.TxIns[0].Net)
+	}
+	if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("testnet transaction rejected: %s", resp)
+	}
+}
+
+func TestCompressedPublicKeys(t *testing.T) {
+	w, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	if len(w.PublicKey) != 33 {
+		t.Fatalf("new wallets should have compressed keys, got %d bytes", len(w.PublicKey))
+	}
+	compressed, err := core.ParsePubKeySafe(w.PublicKey)
+	if err != nil {
+		t.Fatalf("ParsePubKeySafe: %v", err)
+	}
+	uncompressed := append([]byte{0x04}, compressed.X.FillBytes(make([]byte, 32))...)
+	uncompressed = append(uncompressed, compressed.Y.FillBytes(make([]byte, 32))...)
+	if key, err := core.ParsePubKeySafe(uncompressed); err != nil || key.X.Cmp(compressed.X) != 0 || key.Y.Cmp(compressed.Y) != 0 {
+		t.Errorf("both forms of the key should be the same point: %v", err)
+	}
+	flipped := append([]byte{w.PublicKey[0] ^ 1}, w.PublicKey[1:]...)
+	if key, err := core.ParsePubKeySafe(flipped); err != nil || key.Y.Cmp(compressed.Y) == 0 {
+		t.Errorf("the prefix should select the other y: %v", err)
+	}
+	if _, err := core.ParsePubKeySafe(append([]byte{0x05}, w.PublicKey[1:]...)); err == nil {
+		t.Errorf("invalid prefix accepted")
+	}
+
+	// A wallet saved before compressed keys keeps spending with its 65-byte key
+	legacy := *w
+	legacy.PublicKey = uncompressed
+	legacy.Address = hex.EncodeToString(core.HashSHA3(uncompressed))
+	server, _ := fundedChain(t, &legacy)
+	tx, _, err := legacy.BuildTransactionToAddress(w.GetAddress(), 4000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("spend with an uncompressed key rejected: %s", resp)
+	}
+}
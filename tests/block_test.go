@@ -13,11 +13,13 @@ func TestCreateAndHashEmptyBlock(t *testing.T) {
 	prevBlock := crypto.Sha3_256([]byte("Random string"))
 
 	block := core.Block{
-		Version:      1,
-		PrevBlock:    prevBlock,
-		Timestamp:    0,
-		Nonce:        0,
-		Bits:         0,
+		BlockHeader: core.BlockHeader{
+			Version:   1,
+			PrevBlock: prevBlock,
+			Timestamp: 0,
+			Nonce:     0,
+			Bits:      0,
+		},
 		Transactions: []core.Tx{},
 	}
 
@@ -33,11 +35,13 @@ func TestFindValidBlockHash(t *testing.T) {
 	prevBlock := crypto.Sha3_256([]byte("Random string"))
 
 	block := core.Block{
-		Version:      1,
-		PrevBlock:    prevBlock,
-		Timestamp:    0,
-		Nonce:        0,
-		Bits:         12,
+		BlockHeader: core.BlockHeader{
+			Version:   1,
+			PrevBlock: prevBlock,
+			Timestamp: 0,
+			Nonce:     0,
+			Bits:      12,
+		},
 		Transactions: []core.Tx{},
 	}
 
@@ -59,3 +63,50 @@ func TestFindValidBlockHash(t *testing.T) {
 		t.Error("Failed to find a valid hash for the block")
 	}
 }
+
+func TestHeaderCommitsToTransactions(t *testing.T) {
+	txs := []core.Tx{
+		{Version: 1, TxOuts: []core.TxOut{{Amount: 10, LockingScript: []byte("a")}}},
+		{Version: 1, TxOuts: []core.TxOut{{Amount: 20, LockingScript: []byte("b")}}},
+		{Version: 1, TxOuts: []core.TxOut{{Amount: 30, LockingScript: []byte("c")}}},
+	}
+	block := core.Block{
+		BlockHeader:  core.BlockHeader{Version: 1, PrevBlock: make([]byte, 32), Bits: 4},
+		Transactions: txs,
+	}
+	if !block.CalculateValidHash() {
+		t.Fatal("Failed to find a valid hash for the block")
+	}
+	if !block.HasValidMerkleRoot() {
+		t.Fatal("mined block must commit to its transactions")
+	}
+	if got := len(block.BlockHeader.Serialize()); got != core.BlockHeaderSize {
+		t.Errorf("header encoding has %d bytes, expected %d", got, core.BlockHeaderSize)
+	}
+	var header core.BlockHeader
+	if err := header.UnmarshalBinary(block.BlockHeader.Serialize()); err != nil || header.Nonce != block.Nonce {
+		t.Errorf("header encoding does not round-trip: %+v, %v", header, err)
+	}
+
+	for i := range txs {
+		proof, err := block.TxInclusionProof(i)
+		if err != nil {
+			t.Fatalf("TxInclusionProof(%d): %v", i, err)
+		}
+		leaf, _ := hex.DecodeString(txs[i].ID())
+		if !crypto.VerifyMerkleProof(leaf, proof, block.MerkleRoot) {
+			t.Errorf("inclusion proof for transaction %d rejected", i)
+		}
+	}
+
+	// Tampering with a transaction breaks the commitment but not the header hash
+	hashBefore, _ := block.Hash()
+	block.Transactions[1].TxOuts[0].Amount = 2000
+	hashAfter, _ := block.Hash()
+	if block.HasValidMerkleRoot() {
+		t.Error("tampered transaction not detected by the Merkle root")
+	}
+	if hex.EncodeToString(hashBefore) != hex.EncodeToString(hashAfter) {
+		t.Error("block hash must only cover the header")
+	}
+}
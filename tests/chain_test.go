@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// testParams returns consensus parameters cheap enough to mine in tests
+func testParams() core.ChainParams {
+	params := core.DefaultChainParams
+	params.InitialBits = 1
+	params.RetargetInterval = 1000
+	return params
+}
+
+// mineBlock builds and mines a low difficulty block at height on top of prev.
+// Unless txs already starts with one, a coinbase claiming DustLimit is added.
+func mineBlock(t *testing.T, prev []byte, height, timestamp uint64, txs []core.Tx) core.Block {
+	t.Helper()
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		coinbase := core.NewCoinbaseTx(height, []core.TxOut{{Amount: core.DustLimit, LockingScript: []byte("miner")}})
+		txs = append([]core.Tx{coinbase}, txs...)
+	}
+	block := core.Block{
+		BlockHeader: core.BlockHeader{
+			Version:   1,
+			PrevBlock: prev,
+			Timestamp: timestamp,
+			Bits:      1,
+		},
+		Transactions: txs,
+	}
+	if !block.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	return block
+}
+
+func blockHash(t *testing.T, block core.Block) []byte {
+	t.Helper()
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatalf("Error calculating block hash: %v", err)
+	}
+	return hash
+}
+
+func submitBlock(t *testing.T, server *core.BlockchainServer, block core.Block) string {
+	t.Helper()
+	data, err := json.Marshal(core.BlockMessage{Block: block})
+	if err != nil {
+		t.Fatalf("Error marshaling block: %v", err)
+	}
+	return server.ProcessBlockMessage(string(data))
+}
+
+func TestForkChoiceReorganizesToHeaviestBranch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Two miners find a block at the same height
+	a1 := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{})
+	b1 := mineBlock(t, blockHash(t, genesis), 1, 3, []core.Tx{})
+	if resp := submitBlock(t, server, a1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("a1 rejected: %s", resp)
+	}
+	if resp := submitBlock(t, server, b1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("b1 rejected: %s", resp)
+	}
+	if got := server.BestBlockHash(); got != hex.EncodeToString(blockHash(t, a1)) {
+		t.Fatalf("equal work must keep the first seen tip, got %s", got)
+	}
+
+	// The second branch gets extended and must win
+	b2 := mineBlock(t, blockHash(t, b1), 2, 4, []core.Tx{})
+	if resp := submitBlock(t, server, b2); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("b2 rejected: %s", resp)
+	}
+	if got := server.BestBlockHash(); got != hex.EncodeToString(blockHash(t, b2)) {
+		t.Fatalf("expected reorganization to b2, tip is %s", got)
+	}
+	if got := server.ChainHeight(); got != 3 {
+		t.Fatalf("expected 3 blocks on the active chain, got %d", got)
+	}
+}
+
+func TestOrphanBlocksConnectWhenParentArrives(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	b1 := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{})
+	b2 := mineBlock(t, blockHash(t, b1), 2, 3, []core.Tx{})
+	b3 := mineBlock(t, blockHash(t, b2), 3, 4, []core.Tx{})
+
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Blocks arrive in reverse order
+	for _, b := range []core.Block{b3, b2} {
+		if resp := submitBlock(t, server, b); !strings.HasPrefix(resp, "ORPHAN") {
+			t.Fatalf("expected block to be kept as orphan, got: %s", resp)
+		}
+	}
+	if got := server.ChainHeight(); got != 1 {
+		t.Fatalf("orphans must not be connected yet, height %d", got)
+	}
+
+	if resp := submitBlock(t, server, b1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("b1 rejected: %s", resp)
+	}
+	if got := server.BestBlockHash(); got != hex.EncodeToString(blockHash(t, b3)) {
+		t.Fatalf("orphans were not connected, tip is %s", got)
+	}
+
+	if resp := server.ProcessGetBlockMessage(hex.EncodeToString(blockHash(t, b2))); !strings.HasPrefix(resp, "BLOCK:") {
+		t.Errorf("GETBLOCK failed: %s", resp)
+	}
+}
+
+func TestOrphanBlocksNeedWorkAndKnownPeers(t *testing.T) {
+	t.Chdir(t.TempDir())
+	params := testParams()
+	params.InitialBits = 4
+	server := core.NewBlockchainServerWithParams(params)
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	genesis := core.Block{BlockHeader: core.BlockHeader{Version: 1, PrevBlock: make([]byte, 32), Timestamp: 1, Bits: 4}, Transactions: []core.Tx{genesisTx}}
+	if !genesis.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// A host that is not one of our peers, which must never be dialed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	dialed := make(chan bool, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+			dialed <- true
+		}
+	}()
+
+	// Bits 0 needs no work at all
+	unknownParent := make([]byte, 32)
+	unknownParent[0] = 1
+	coinbase := core.NewCoinbaseTx(2, []core.TxOut{{Amount: core.DustLimit, LockingScript: []byte("miner")}})
+	free := core.Block{BlockHeader: core.BlockHeader{Version: 1, PrevBlock: unknownParent, Timestamp: 3, Bits: 0}, Transactions: []core.Tx{coinbase}}
+	free.CalculateValidHash()
+	data, err := json.Marshal(core.BlockMessage{Block: free, From: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("Error marshaling block: %v", err)
+	}
+	if resp := server.ProcessBlockMessage(string(data)); !strings.Contains(resp, "[high-hash]") {
+		t.Fatalf("orphan without Proof of Work kept: %s", resp)
+	}
+
+	worked := free
+	worked.Bits = 4
+	if !worked.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	data, err = json.Marshal(core.BlockMessage{Block: worked, From: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("Error marshaling block: %v", err)
+	}
+	if resp := server.ProcessBlockMessage(string(data)); !strings.HasPrefix(resp, "ORPHAN") {
+		t.Fatalf("expected block to be kept as orphan, got: %s", resp)
+	}
+	select {
+	case <-dialed:
+		t.Errorf("node dialed the address an orphan claimed to come from")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
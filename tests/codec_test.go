@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func sampleBlock() core.Block {
+	tx := core.Tx{
+		Version: 2,
+		TxIns: []core.TxIn{
+			{PrevTx: bytes.Repeat([]byte{0xab}, 32), PrevIndex: 3, UnlockingScript: []byte("sig"), Net: "mainnet", Sequence: core.SequenceFinal},
+			{PrevTx: bytes.Repeat([]byte{0xcd}, 32), PrevIndex: 300, Net: "mainnet"},
+		},
+		TxOuts: []core.TxOut{
+			{Amount: 1000, LockingScript: []byte("a")},
+			{Amount: core.MaxMoney, LockingScript: []byte("b")},
+		},
+		LockTime: core.LockTimeThreshold + 1,
+	}
+	coinbase := core.NewCoinbaseTx(1, []core.TxOut{{Amount: 5000, LockingScript: []byte("miner")}})
+	block := core.Block{
+		BlockHeader:  core.BlockHeader{Version: 1, PrevBlock: make([]byte, 32), Timestamp: 1_700_000_000, Bits: 4},
+		Transactions: []core.Tx{coinbase, tx},
+	}
+	block.CalculateValidHash()
+	return block
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	block := sampleBlock()
+	data, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var decoded core.Block
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !bytes.Equal(decoded.Serialize(), data) || !bytes.Equal(decoded.BlockHeader.Hash(), block.BlockHeader.Hash()) {
+		t.Errorf("block changed after a round trip:\n%+v\n%+v", decoded, block)
+	}
+	if !decoded.HasValidMerkleRoot() {
+		t.Errorf("decoded block does not match its Merkle root")
+	}
+
+	tx := block.Transactions[1]
+	var decodedTx core.Tx
+	if err := decodedTx.UnmarshalBinary(tx.Serialize()); err != nil {
+		t.Fatalf("Tx.UnmarshalBinary: %v", err)
+	}
+	if decodedTx.ID() != tx.ID() || !reflect.DeepEqual(decodedTx, tx) {
+		t.Errorf("transaction changed after a round trip")
+	}
+
+	// JSON messages carry the same encoding
+	msg, err := json.Marshal(core.BlockMessage{Block: block, From: "peer"})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var blockMsg core.BlockMessage
+	if err := json.Unmarshal(msg, &blockMsg); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !bytes.Equal(blockMsg.Block.Serialize(), data) {
+		t.Errorf("block changed after a JSON round trip")
+	}
+
+	chain, err := core.DecodeBlocks([]byte{core.CodecVersion, 0})
+	if err != nil || len(chain) != 0 {
+		t.Errorf("empty chain: %v, %v", chain, err)
+	}
+}
+
+func TestCodecRejectsNonCanonical(t *testing.T) {
+	block := sampleBlock()
+	data := block.Serialize()
+	tx := block.Transactions[1]
+	txData := tx.Serialize()
+	out := core.TxOut{Amount: 1, LockingScript: []byte("a")}
+
+	cases := []struct {
+		name string
+		data []byte
+		into interface{ UnmarshalBinary([]byte) error }
+	}{
+		{"empty", nil, &core.Tx{}},
+		{"trailing byte", append(append([]byte(nil), data...), 0), &core.Block{}},
+		{"truncated", data[:len(data)-1], &core.Block{}},
+		{"unknown version", append([]byte{core.CodecVersion + 1}, txData[1:]...), &core.Tx{}},
+		// Amount 1 written as 0x81 0x00 instead of 0x01
+		{"non-minimal varint", []byte{core.CodecVersion, 0x81, 0x00, 1, 'a'}, &core.TxOut{}},
+		{"length past the end", []byte{core.CodecVersion, 1, 5, 'a'}, &core.TxOut{}},
+		// PrevIndex of 2^32 in an input with empty fields
+		{"uint32 overflow", []byte{core.CodecVersion, 0, 0x80, 0x80, 0x80, 0x80, 0x10, 0, 0, 0}, &core.TxIn{}},
+	}
+	for _, c := range cases {
+		if err := c.into.UnmarshalBinary(c.data); err == nil {
+			t.Errorf("%s: decoded without error", c.name)
+		}
+	}
+
+	// The valid counterpart of the hand-written encodings
+	var decoded core.TxOut
+	if err := decoded.UnmarshalBinary([]byte{core.CodecVersion, 1, 1, 'a'}); err != nil || !reflect.DeepEqual(decoded, out) {
+		t.Errorf("minimal encoding rejected: %+v, %v", decoded, err)
+	}
+}
+
+func TestIDCoversCanonicalEncoding(t *testing.T) {
+	tx := sampleBlock().Transactions[1]
+	id, witnessHash := tx.ID(), tx.WitnessHash()
+
+	// Every field but the unlocking scripts changes the ID; the witness hash
+	// covers those too
+	tx.TxIns[1].Net = "testnet"
+	if tx.ID() == id || tx.WitnessHash() == witnessHash {
+		t.Errorf("ID or witness hash does not cover the network")
+	}
+}
+
+func TestStateFilesUseCodec(t *testing.T) {
+	server, _ := genesisPaying(t, []byte("a"), []byte("b"))
+
+	data, err := os.ReadFile("utxos.dat")
+	if err != nil {
+		t.Fatalf("reading UTXO file: %v", err)
+	}
+	utxos, tipHash, err := core.DecodeUTXOSet(data)
+	if err != nil {
+		t.Fatalf("DecodeUTXOSet: %v", err)
+	}
+	if tipHash != server.BestBlockHash() || !reflect.DeepEqual(utxos, server.GetUTXOSet()) {
+		t.Errorf("UTXO file does not hold the UTXO set after the tip")
+	}
+
+	// A UTXO set that does not belong to the chain on disk is rebuilt
+	if err := os.Remove("blockchain.dat"); err != nil {
+		t.Fatalf("removing the chain: %v", err)
+	}
+	restarted := core.NewBlockchainServerWithParams(testParams())
+	if n := len(restarted.GetUTXOSet()); n != 0 {
+		t.Errorf("stale UTXO set loaded for an empty chain: %d outputs", n)
+	}
+}
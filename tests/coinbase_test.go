@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestBlockSubsidyHalving(t *testing.T) {
+	params := core.DefaultChainParams
+	params.InitialSubsidy = 100
+	params.HalvingInterval = 10
+
+	cases := map[uint64]uint64{0: 100, 9: 100, 10: 50, 25: 25, 39: 12, 70: 0, 10 * 64: 0}
+	for height, want := range cases {
+		if got := params.BlockSubsidy(height); got != want {
+			t.Errorf("BlockSubsidy(%d) = %d, want %d", height, got, want)
+		}
+	}
+}
+
+func TestCoinbaseRules(t *testing.T) {
+	t.Chdir(t.TempDir())
+	params := testParams()
+	server := core.NewBlockchainServerWithParams(params)
+
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 5000, LockingScript: alice.GetLockingScript()}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Pay 600 to bob and leave a fee of 100 by lowering the change
+	tx, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 600, 0, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("Failed to build transaction: %v", err)
+	}
+	tx.TxOuts[1].Amount -= 100
+	if err := alice.SignTx(&tx, server.GetUTXOSet(), core.SigHashAll); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	loose := core.NewCoinbaseTx(1, nil)
+	if _, err := loose.Validate(core.NewUTXOView(server.GetUTXOSet())); err == nil {
+		t.Errorf("coinbase accepted outside of a block")
+	}
+
+	reward := params.BlockSubsidy(1) + 100
+	miner := bob.GetLockingScript()
+	parent := blockHash(t, genesis)
+
+	rejected := map[string][]core.Tx{
+		"over-claim":      {core.NewCoinbaseTx(1, []core.TxOut{{Amount: reward + 1, LockingScript: miner}}), tx},
+		"wrong height":    {core.NewCoinbaseTx(2, []core.TxOut{{Amount: reward, LockingScript: miner}}), tx},
+		"no coinbase":     {tx},
+		"second coinbase": {core.NewCoinbaseTx(1, nil), tx, core.NewCoinbaseTx(1, nil)},
+		"dust output":     {core.NewCoinbaseTx(1, []core.TxOut{{Amount: reward, LockingScript: miner}, {Amount: core.DustLimit - 1, LockingScript: miner}}), tx},
+		"no outputs":      {core.NewCoinbaseTx(1, nil), tx},
+	}
+	for name, txs := range rejected {
+		block := core.Block{
+			BlockHeader:  core.BlockHeader{Version: 1, PrevBlock: parent, Timestamp: 2, Bits: 1},
+			Transactions: txs,
+		}
+		if !block.CalculateValidHash() {
+			t.Fatalf("failed to mine block")
+		}
+		if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "ERROR") {
+			t.Errorf("%s: block accepted: %s", name, resp)
+		}
+	}
+
+	coinbase := core.NewCoinbaseTx(1, []core.TxOut{{Amount: reward, LockingScript: miner}})
+	block := mineBlock(t, parent, 1, 2, []core.Tx{coinbase, tx})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block claiming subsidy + fees rejected: %s", resp)
+	}
+
+	var balance uint64
+	for _, out := range bob.FilterUTXOs(server.GetUTXOSet()) {
+		balance += out.Amount
+	}
+	if balance != 600+reward {
+		t.Errorf("expected bob to own %d, got %d", 600+reward, balance)
+	}
+}
+
+func TestCoinbaseWithNothingToClaim(t *testing.T) {
+	t.Chdir(t.TempDir())
+	params := testParams()
+	params.InitialSubsidy = 100
+	params.HalvingInterval = 1
+	server := core.NewBlockchainServerWithParams(params)
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// The subsidy is below the dust limit and there are no fees: the
+	// template pays nothing rather than creating dust
+	block := server.BlockTemplate([]byte("miner"))
+	if n := len(block.Transactions[0].TxOuts); n != 0 {
+		t.Fatalf("coinbase has %d outputs with nothing to claim", n)
+	}
+	if !block.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block without coinbase outputs rejected: %s", resp)
+	}
+
+	dust := mineBlock(t, blockHash(t, block), 2, block.Timestamp+1, []core.Tx{core.NewCoinbaseTx(2, []core.TxOut{{Amount: 25, LockingScript: []byte("miner")}})})
+	if resp := submitBlock(t, server, dust); !strings.Contains(resp, "bad-cb") {
+		t.Errorf("coinbase creating dust accepted: %s", resp)
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestDifficultyRetargeting(t *testing.T) {
+	t.Chdir(t.TempDir())
+	params := testParams()
+	params.RetargetInterval = 4
+	params.TargetBlockTime = 10 * time.Second
+	params.MaxBitsAdjustment = 2
+	server := core.NewBlockchainServerWithParams(params)
+
+	// Wrong genesis difficulty is rejected
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	bad := core.Block{BlockHeader: core.BlockHeader{Version: 1, PrevBlock: make([]byte, 32), Timestamp: 100, Bits: 0}, Transactions: []core.Tx{genesisTx}}
+	if resp := submitBlock(t, server, bad); !strings.HasPrefix(resp, "ERROR") {
+		t.Fatalf("block with 0 bits accepted: %s", resp)
+	}
+
+	// Blocks one second apart, ten times faster than the target
+	prev := mineBlock(t, make([]byte, 32), 0, 100, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, prev); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	for ts := uint64(101); ts <= 103; ts++ {
+		block := mineBlock(t, blockHash(t, prev), ts-100, ts, []core.Tx{})
+		if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("block rejected: %s", resp)
+		}
+		prev = block
+	}
+
+	// log2(30s/3s) rounds to 3, clamped to +2 bits
+	if got := server.NextRequiredBits(); got != 3 {
+		t.Fatalf("expected difficulty 3 after retarget, got %d", got)
+	}
+
+	easy := mineBlock(t, blockHash(t, prev), 4, 104, []core.Tx{})
+	if resp := submitBlock(t, server, easy); !strings.HasPrefix(resp, "ERROR") {
+		t.Fatalf("block with stale difficulty accepted: %s", resp)
+	}
+
+	hard := core.Block{BlockHeader: core.BlockHeader{Version: 1, PrevBlock: blockHash(t, prev), Timestamp: 104, Bits: 3}, Transactions: []core.Tx{easy.Transactions[0]}}
+	if !hard.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	if resp := submitBlock(t, server, hard); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block with required difficulty rejected: %s", resp)
+	}
+}
+
+func TestDifficultyRetargetRounding(t *testing.T) {
+	// Three intervals of 10s are expected. log2(expected/actual) is rounded
+	// to the nearest bit, with the boundaries at 30*sqrt(2)=42.4s and
+	// 30*2*sqrt(2)=84.9s.
+	for _, c := range []struct {
+		actual uint64
+		bits   uint64
+	}{{10, 6}, {30, 4}, {42, 4}, {43, 3}, {60, 3}, {84, 3}, {85, 2}, {1000, 2}} {
+		t.Run(fmt.Sprintf("%ds", c.actual), func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			params := testParams()
+			params.InitialBits = 4
+			params.RetargetInterval = 4
+			params.TargetBlockTime = 10 * time.Second
+			params.MaxBitsAdjustment = 2
+			server := core.NewBlockchainServerWithParams(params)
+
+			genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+			timestamps := []uint64{100, 101, 102, 100 + c.actual}
+			prev := make([]byte, 32)
+			for height, ts := range timestamps {
+				txs := []core.Tx{core.NewCoinbaseTx(uint64(height), []core.TxOut{{Amount: core.DustLimit, LockingScript: []byte("miner")}})}
+				if height == 0 {
+					txs = []core.Tx{genesisTx}
+				}
+				block := core.Block{BlockHeader: core.BlockHeader{Version: 1, PrevBlock: prev, Timestamp: ts, Bits: 4}, Transactions: txs}
+				if !block.CalculateValidHash() {
+					t.Fatalf("failed to mine block")
+				}
+				if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+					t.Fatalf("block rejected: %s", resp)
+				}
+				prev = blockHash(t, block)
+			}
+			if got := server.NextRequiredBits(); got != c.bits {
+				t.Errorf("expected difficulty %d after %ds, got %d", c.bits, c.actual, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func submitTx(t *testing.T, server *core.BlockchainServer, tx core.Tx) string {
+	t.Helper()
+	data, err := json.Marshal(core.TransactionMessage{Transaction: tx})
+	if err != nil {
+		t.Fatalf("Error marshaling transaction: %v", err)
+	}
+	return server.ProcessTransactionMessage(string(data))
+}
+
+func TestDoubleSpendsAreRejected(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 10_000, LockingScript: alice.GetLockingScript()}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Two different transactions spending the same output
+	first := spend(t, alice, genesisTx, []uint32{0}, []uint64{9000})
+	second := spend(t, alice, genesisTx, []uint32{0}, []uint64{8000})
+
+	// Same output spent twice by one transaction
+	twice := spend(t, alice, genesisTx, []uint32{0, 0}, []uint64{15_000})
+	if resp := submitTx(t, server, twice); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("transaction spending an output twice accepted: %s", resp)
+	}
+
+	// Twice in the same block
+	both := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{first, second})
+	if resp := submitBlock(t, server, both); !strings.Contains(resp, "[bad-txns]") {
+		t.Errorf("block with an intra-block double spend accepted: %s", resp)
+	}
+
+	// Once in the mempool, a conflicting transaction is refused
+	if resp := submitTx(t, server, first); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+	if resp := submitTx(t, server, second); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("mempool double spend accepted: %s", resp)
+	}
+
+	// Once confirmed, the output cannot be spent again in a later block
+	b1 := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{first})
+	if resp := submitBlock(t, server, b1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block rejected: %s", resp)
+	}
+	b2 := mineBlock(t, blockHash(t, b1), 2, 3, []core.Tx{second})
+	if resp := submitBlock(t, server, b2); !strings.Contains(resp, "[bad-txns]") {
+		t.Errorf("block spending a spent output accepted: %s", resp)
+	}
+}
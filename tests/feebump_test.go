@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestReplaceByFee(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// A replaceable payment paying the minimum fee, and a child paying 400
+	// from its change
+	orig, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 5000, 1, outputOf(genesisTx, 0), core.WithReplaceable())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	origFee := 10000 - orig.TxOuts[0].Amount - orig.TxOuts[1].Amount
+	child := spend(t, alice, orig, []uint32{1}, []uint64{orig.TxOuts[1].Amount - 400})
+	for _, tx := range []core.Tx{orig, child} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+
+	// The replacement must pay for everything it evicts
+	if _, err := alice.BumpFee(orig, 0, outputOf(genesisTx, 0)); err == nil {
+		t.Errorf("BumpFee accepted a fee that is not higher")
+	}
+	cheap, err := alice.BumpFee(orig, origFee+300, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BumpFee: %v", err)
+	}
+	if resp := submitTx(t, server, cheap); !strings.Contains(resp, fmt.Sprintf("not higher than the %d", origFee+400)) {
+		t.Errorf("replacement paying less than the transactions it evicts accepted: %s", resp)
+	}
+	bumped, err := alice.BumpFee(orig, origFee+1000, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BumpFee: %v", err)
+	}
+	if bumped.TxOuts[0].Amount != 5000 || bumped.TxOuts[1].Amount != 4000-origFee || !bumped.SignalsReplacement() {
+		t.Errorf("bumped transaction pays %d and %d", bumped.TxOuts[0].Amount, bumped.TxOuts[1].Amount)
+	}
+	if resp := submitTx(t, server, bumped); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("replacement rejected: %s", resp)
+	}
+	entries := server.MempoolEntries()
+	if len(entries) != 1 || entries[0].ID != bumped.ID() || entries[0].Fee != origFee+1000 {
+		t.Errorf("original and its child not replaced: %d entries", len(entries))
+	}
+
+	// Transactions that did not opt in are never replaced
+	plain, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 5000, 1, outputOf(genesisTx, 1))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, plain); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+	if _, err := alice.BumpFee(plain, 1000, outputOf(genesisTx, 1)); err == nil {
+		t.Errorf("BumpFee of a transaction that did not opt in")
+	}
+	double := spend(t, alice, genesisTx, []uint32{1}, []uint64{5000})
+	if resp := submitTx(t, server, double); !strings.Contains(resp, "not replaceable") {
+		t.Errorf("transaction that did not opt in replaced: %s", resp)
+	}
+}
+
+func TestBumpFeeWithExtraInputs(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// A payment with a high fee-rate whose change is left as fee
+	orig, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 9000, 4, outputOf(genesisTx, 0), core.WithReplaceable())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if len(orig.TxOuts) != 1 {
+		t.Fatalf("payment has %d outputs, expected no change", len(orig.TxOuts))
+	}
+	if resp := submitTx(t, server, orig); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+
+	// One more coin of fee needs another input, which the fee must pay for
+	bumped, err := alice.BumpFee(orig, 1001, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BumpFee: %v", err)
+	}
+	if len(bumped.TxIns) != 2 || bumped.TxOuts[0].Amount != 9000 {
+		t.Fatalf("bumped transaction spends %d outputs and pays %d", len(bumped.TxIns), bumped.TxOuts[0].Amount)
+	}
+	fee := 20000 - bumped.TxOuts[0].Amount - bumped.TxOuts[1].Amount
+	if fee*uint64(len(orig.Serialize())) <= 1000*uint64(len(bumped.Serialize())) {
+		t.Errorf("bumped fee %d for %d bytes does not beat 1000 for %d bytes", fee, len(bumped.Serialize()), len(orig.Serialize()))
+	}
+	if resp := submitTx(t, server, bumped); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("replacement rejected: %s", resp)
+	}
+}
+
+func TestChildPaysForParent(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// The parent pays the minimum fee, another transaction 500 and the
+	// child of the parent 3000, enough for both
+	parent, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 9000, 1, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	parentFee := 10000 - parent.TxOuts[0].Amount - parent.TxOuts[1].Amount
+	other := spend(t, alice, genesisTx, []uint32{1}, []uint64{9500})
+	child := spend(t, alice, parent, []uint32{0}, []uint64{6000})
+	for _, tx := range []core.Tx{parent, other, child} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+
+	block := server.BlockTemplate(script)
+	if len(block.Transactions) != 4 {
+		t.Fatalf("template has %d transactions, expected 4", len(block.Transactions))
+	}
+	for i, tx := range []core.Tx{parent, child, other} {
+		if block.Transactions[i+1].ID() != tx.ID() {
+			t.Errorf("template transaction %d: the parent was not mined for its child's fee", i+1)
+		}
+	}
+	if reward := block.Transactions[0].TxOuts[0].Amount; reward != testParams().InitialSubsidy+parentFee+3500 {
+		t.Errorf("coinbase claims %d", reward)
+	}
+}
+
+func TestTemplateRescoresDescendants(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// A parent paying the minimum fee with two children. Once the first
+	// child has paid for it, the second one is only judged by its own fee
+	// and goes before the unrelated transaction.
+	parent, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 5000, 1, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	first := spend(t, alice, parent, []uint32{0}, []uint64{1000})
+	second := spend(t, alice, parent, []uint32{1}, []uint64{parent.TxOuts[1].Amount - 1500})
+	other := spend(t, alice, genesisTx, []uint32{1}, []uint64{9000})
+	for _, tx := range []core.Tx{parent, first, second, other} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+
+	block := server.BlockTemplate(script)
+	if len(block.Transactions) != 5 {
+		t.Fatalf("template has %d transactions, expected 5", len(block.Transactions))
+	}
+	for i, tx := range []core.Tx{parent, first, second, other} {
+		if block.Transactions[i+1].ID() != tx.ID() {
+			t.Errorf("template transaction %d out of order", i+1)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// fundedChain starts a chain whose genesis pays 10000 to w. The server is
+// created in a fresh directory so it does not load another chain from disk.
+func fundedChain(t *testing.T, w *core.Wallet) (*core.BlockchainServer, core.Block) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 10_000, LockingScript: w.GetLockingScript()}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	return server, genesis
+}
+
+// fundHTLC pays amount from w to the contract and mines it at height 1.
+// It returns the block and the UTXO key of the contract output.
+func fundHTLC(t *testing.T, server *core.BlockchainServer, genesis core.Block, w *core.Wallet, h *core.HTLC, amount uint64) (core.Block, string) {
+	t.Helper()
+	fund, _, err := w.BuildTransaction(h.LockingScript(), amount, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransaction: %v", err)
+	}
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{fund})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("funding block rejected: %s", resp)
+	}
+	return block, fmt.Sprintf("%s:0", fund.ID())
+}
+
+func TestAtomicSwap(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	chainA, genesisA := fundedChain(t, alice)
+	chainB, genesisB := fundedChain(t, bob)
+
+	// Alice initiates on chain A with the longer timeout
+	secret, err := core.NewHTLCSecret()
+	if err != nil {
+		t.Fatalf("NewHTLCSecret: %v", err)
+	}
+	secretHash := core.HashSHA3(secret)
+	contractA, err := core.NewHTLC(secretHash, core.HashSHA3(bob.PublicKey), core.HashSHA3(alice.PublicKey), 20)
+	if err != nil {
+		t.Fatalf("NewHTLC: %v", err)
+	}
+	a1, keyA := fundHTLC(t, chainA, genesisA, alice, contractA, 6000)
+
+	// Bob participates on chain B with the same hash
+	contractB, err := core.NewHTLC(secretHash, core.HashSHA3(alice.PublicKey), core.HashSHA3(bob.PublicKey), 10)
+	if err != nil {
+		t.Fatalf("NewHTLC: %v", err)
+	}
+	b1, keyB := fundHTLC(t, chainB, genesisB, bob, contractB, 4000)
+	outB := chainB.GetUTXOSet()[keyB]
+
+	// Nobody can redeem without the secret or as someone else
+	if _, err := alice.RedeemHTLC(contractB, keyB, outB, make([]byte, 32), 100); err == nil {
+		t.Errorf("redeem with the wrong secret built")
+	}
+	if _, err := bob.RedeemHTLC(contractB, keyB, outB, secret, 100); err == nil {
+		t.Errorf("sender redeemed its own contract")
+	}
+
+	// Alice redeems on chain B, revealing the secret
+	redeemB, err := alice.RedeemHTLC(contractB, keyB, outB, secret, 100)
+	if err != nil {
+		t.Fatalf("RedeemHTLC: %v", err)
+	}
+	if resp := submitBlock(t, chainB, mineBlock(t, blockHash(t, b1), 2, 3, []core.Tx{redeemB})); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("redeem on chain B rejected: %s", resp)
+	}
+
+	// Bob learns the secret from the redeem transaction and claims chain A
+	revealed, err := core.ExtractHTLCSecret(&redeemB, contractB)
+	if err != nil || !bytes.Equal(revealed, secret) {
+		t.Fatalf("ExtractHTLCSecret: %x, %v", revealed, err)
+	}
+	redeemA, err := bob.RedeemHTLC(contractA, keyA, chainA.GetUTXOSet()[keyA], revealed, 100)
+	if err != nil {
+		t.Fatalf("RedeemHTLC: %v", err)
+	}
+	if resp := submitBlock(t, chainA, mineBlock(t, blockHash(t, a1), 2, 3, []core.Tx{redeemA})); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("redeem on chain A rejected: %s", resp)
+	}
+
+	if got := sumUTXOs(bob.FilterUTXOs(chainA.GetUTXOSet())); got != 5900 {
+		t.Errorf("expected bob to own 5900 on chain A, got %d", got)
+	}
+	if got := sumUTXOs(alice.FilterUTXOs(chainB.GetUTXOSet())); got != 3900 {
+		t.Errorf("expected alice to own 3900 on chain B, got %d", got)
+	}
+}
+
+func TestHTLCRefund(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	server, genesis := fundedChain(t, alice)
+
+	contract, err := core.NewHTLC(core.HashSHA3([]byte("never revealed")), core.HashSHA3(bob.PublicKey), core.HashSHA3(alice.PublicKey), 2)
+	if err != nil {
+		t.Fatalf("NewHTLC: %v", err)
+	}
+	b1, key := fundHTLC(t, server, genesis, alice, contract, 6000)
+
+	refund, err := alice.RefundHTLC(contract, key, server.GetUTXOSet()[key], 1000)
+	if err != nil {
+		t.Fatalf("RefundHTLC: %v", err)
+	}
+	if _, err := bob.RefundHTLC(contract, key, server.GetUTXOSet()[key], 1000); err == nil {
+		t.Errorf("receiver built a refund")
+	}
+
+	// The refund carries the contract lock time: not before height 3
+	if resp := submitTx(t, server, refund); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("refund accepted before the timeout: %s", resp)
+	}
+	b2 := mineBlock(t, blockHash(t, b1), 2, 3, nil)
+	if resp := submitBlock(t, server, b2); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("b2 rejected: %s", resp)
+	}
+
+	// Moving the lock time earlier breaks both the signature and the CLTV check
+	early := refund
+	early.LockTime = 1
+	if resp := submitTx(t, server, early); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("refund with an earlier lock time accepted: %s", resp)
+	}
+
+	if resp := submitTx(t, server, refund); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("refund rejected after the timeout: %s", resp)
+	}
+}
+
+func sumUTXOs(utxos map[string]core.TxOut) uint64 {
+	var total uint64
+	for _, out := range utxos {
+		total += out.Amount
+	}
+	return total
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestSecp256k1Wallet(t *testing.T) {
+	w, err := core.NewWalletWithKeyType(core.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	key, err := core.ParsePubKey(w.PublicKey, core.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatalf("public key is not a secp256k1 point: %v", err)
+	}
+	// A compressed key is only an x, which may be on both curves; the full
+	// point is not
+	uncompressed := append([]byte{0x04}, key.X.FillBytes(make([]byte, 32))...)
+	uncompressed = append(uncompressed, key.Y.FillBytes(make([]byte, 32))...)
+	if _, err := core.ParsePubKey(uncompressed, core.KeyTypeP256); err == nil {
+		t.Errorf("secp256k1 public key accepted as a P-256 key")
+	}
+
+	// The key type survives a save and load
+	w.WalletFile = filepath.Join(t.TempDir(), "wallet.json")
+	if err := w.SaveToDisk(); err != nil {
+		t.Fatalf("SaveToDisk: %v", err)
+	}
+	loaded, err := core.LoadWallet(w.WalletFile)
+	if err != nil {
+		t.Fatalf("LoadWallet: %v", err)
+	}
+	if loaded.KeyType != core.KeyTypeSecp256k1 {
+		t.Errorf("loaded wallet has key type %s", loaded.KeyType)
+	}
+	priv, err := loaded.GetECDSAPrivateKey()
+	if err != nil {
+		t.Fatalf("GetECDSAPrivateKey: %v", err)
+	}
+	if pub, _ := core.ParsePubKey(w.PublicKey, core.KeyTypeSecp256k1); priv.X.Cmp(pub.X) != 0 || priv.Y.Cmp(pub.Y) != 0 {
+		t.Errorf("private key does not match the public key")
+	}
+
+	if _, err := core.ParseKeyType("ed25519"); err == nil {
+		t.Errorf("unknown key type name accepted")
+	}
+}
+
+func TestMixedCurveBlock(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWalletWithKeyType(core.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{
+		{Amount: 10_000, LockingScript: alice.GetLockingScript()},
+		{Amount: 10_000, LockingScript: bob.GetLockingScript()},
+	})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	fromAlice := spend(t, alice, genesisTx, []uint32{0}, []uint64{9000})
+	fromBob := spend(t, bob, genesisTx, []uint32{1}, []uint64{9000})
+	if fromBob.TxIns[0].KeyType != core.KeyTypeSecp256k1 {
+		t.Fatalf("secp256k1 wallet signed an input of type %s", fromBob.TxIns[0].KeyType)
+	}
+
+	// The signature hash commits to the key type: retagging the input breaks
+	// the signature even though the tag names the right curve for the key
+	for _, kt := range []core.KeyType{core.KeyTypeP256, 7} {
+		retagged := fromBob
+		retagged.TxIns = append([]core.TxIn(nil), fromBob.TxIns...)
+		retagged.TxIns[0].KeyType = kt
+		if err := core.VerifyScript(&retagged, 0, genesisTx.TxOuts[1]); err == nil {
+			t.Errorf("input retagged as %s verified", kt)
+		}
+	}
+	unknown := fromBob
+	unknown.TxIns = append([]core.TxIn(nil), fromBob.TxIns...)
+	unknown.TxIns[0].KeyType = 7
+	if resp := submitTx(t, server, unknown); strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("input with an unknown key type accepted: %s", resp)
+	}
+
+	var decoded core.Tx
+	if err := decoded.UnmarshalBinary(fromBob.Serialize()); err != nil || decoded.TxIns[0].KeyType != core.KeyTypeSecp256k1 {
+		t.Errorf("key type lost in the codec: %v", err)
+	}
+
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{fromAlice, fromBob})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block spending both curves rejected: %s", resp)
+	}
+	utxos := server.GetUTXOSet()
+	for _, tx := range []core.Tx{fromAlice, fromBob} {
+		if _, ok := utxos[tx.ID()+":0"]; !ok {
+			t.Errorf("output of %s missing from the UTXO set", tx.ID()[:8])
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestAbsoluteLockTime(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 10_000, LockingScript: alice.GetLockingScript()}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Vesting payout: cannot be mined before height 3
+	payout, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 3000, 1, server.GetUTXOSet(), core.WithLockTime(2))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, payout); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("locked transaction entered the mempool: %s", resp)
+	}
+
+	prev := blockHash(t, genesis)
+	for height := uint64(1); height <= 2; height++ {
+		early := mineBlock(t, prev, height, height+1, []core.Tx{payout})
+		if resp := submitBlock(t, server, early); !strings.Contains(resp, "bad-txns") {
+			t.Errorf("locked transaction mined at height %d: %s", height, resp)
+		}
+		empty := mineBlock(t, prev, height, height+1, nil)
+		if resp := submitBlock(t, server, empty); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("block %d rejected: %s", height, resp)
+		}
+		prev = blockHash(t, empty)
+	}
+
+	if resp := submitTx(t, server, payout); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("unlocked transaction rejected by the mempool: %s", resp)
+	}
+	b3 := mineBlock(t, prev, 3, 4, []core.Tx{payout})
+	if resp := submitBlock(t, server, b3); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("unlocked transaction rejected at height 3: %s", resp)
+	}
+
+	// Time locks are compared with the median time past, and final inputs opt out
+	timeLocked := core.Tx{TxIns: []core.TxIn{{}}, LockTime: core.LockTimeThreshold + 100}
+	if timeLocked.IsFinal(1_000_000, uint64(core.LockTimeThreshold)+100) {
+		t.Errorf("time lock released at its own timestamp")
+	}
+	if !timeLocked.IsFinal(0, uint64(core.LockTimeThreshold)+101) {
+		t.Errorf("time lock not released after its timestamp")
+	}
+	timeLocked.TxIns[0].Sequence = core.SequenceFinal
+	if !timeLocked.IsFinal(0, 0) {
+		t.Errorf("lock time enforced with final inputs")
+	}
+}
+
+func TestRelativeLockTime(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{
+		{Amount: 10_000, LockingScript: alice.GetLockingScript()},
+		{Amount: 10_000, LockingScript: alice.GetLockingScript()},
+		{Amount: 10_000, LockingScript: alice.GetLockingScript()},
+	})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1000, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	genesisID, _ := hex.DecodeString(genesisTx.ID())
+	lockedSpend := func(idx uint32, sequence uint32, version uint32) core.Tx {
+		tx := core.Tx{
+			Version: version,
+			TxIns:   []core.TxIn{{PrevTx: genesisID, PrevIndex: idx, Net: "mainnet", Sequence: sequence}},
+			TxOuts:  []core.TxOut{{Amount: 9000, LockingScript: []byte("dest")}},
+		}
+		if err := alice.SignInput(&tx, 0, genesisTx.TxOuts[idx], core.SigHashAll); err != nil {
+			t.Fatalf("SignInput: %v", err)
+		}
+		return tx
+	}
+
+	// Relative locks are ignored before version 2
+	if resp := submitTx(t, server, lockedSpend(2, 2, 1)); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("version 1 transaction with a sequence rejected: %s", resp)
+	}
+
+	// Two blocks after the output was confirmed
+	byHeight := lockedSpend(0, 2, 2)
+	if resp := submitTx(t, server, byHeight); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("transaction entered the mempool before its relative lock: %s", resp)
+	}
+
+	// 1024 seconds after the output was confirmed
+	byTime := lockedSpend(1, core.RelativeLockTime(1000*time.Second), 2)
+	if resp := submitTx(t, server, byTime); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("transaction entered the mempool before its relative time lock: %s", resp)
+	}
+
+	b1 := mineBlock(t, blockHash(t, genesis), 1, 1500, nil)
+	if resp := submitBlock(t, server, b1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("b1 rejected: %s", resp)
+	}
+	// Median time past is now 1500: enough for the time lock, block 2 is also
+	// two blocks after the output
+	b2 := mineBlock(t, blockHash(t, b1), 2, 1600, []core.Tx{byHeight, byTime})
+	if resp := submitBlock(t, server, b2); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("transactions rejected after their relative locks: %s", resp)
+	}
+}
+
+func TestTimelockScripts(t *testing.T) {
+	b := core.NewScriptBuilder
+	cltv := b().AddInt64(100).AddOp(core.OP_CHECKLOCKTIMEVERIFY).AddOp(core.OP_DROP).AddOp(core.OP_1).Script()
+	csv := b().AddInt64(10).AddOp(core.OP_CHECKSEQUENCEVERIFY).AddOp(core.OP_DROP).AddOp(core.OP_1).Script()
+
+	verify := func(locking []byte, version, lockTime, sequence uint32) error {
+		tx := core.Tx{
+			Version:  version,
+			TxIns:    []core.TxIn{{PrevTx: make([]byte, 32), PrevIndex: 1, Net: "mainnet", Sequence: sequence}},
+			TxOuts:   []core.TxOut{{Amount: 1000, LockingScript: []byte("dest")}},
+			LockTime: lockTime,
+		}
+		return core.VerifyScript(&tx, 0, core.TxOut{Amount: 1000, LockingScript: locking})
+	}
+
+	cases := []struct {
+		name     string
+		locking  []byte
+		version  uint32
+		lockTime uint32
+		sequence uint32
+		ok       bool
+	}{
+		{"cltv reached", cltv, 1, 100, 0, true},
+		{"cltv later lock time", cltv, 1, 150, 0, true},
+		{"cltv not reached", cltv, 1, 99, 0, false},
+		{"cltv final input", cltv, 1, 100, core.SequenceFinal, false},
+		{"cltv time instead of height", cltv, 1, core.LockTimeThreshold + 100, 0, false},
+		{"csv reached", csv, 2, 0, 10, true},
+		{"csv not reached", csv, 2, 0, 9, false},
+		{"csv version 1", csv, 1, 0, 10, false},
+		{"csv seconds instead of blocks", csv, 2, 0, core.RelativeLockTime(time.Hour), false},
+		{"csv disabled input", csv, 2, 0, core.SequenceLockTimeDisabled | 10, false},
+	}
+	for _, c := range cases {
+		if err := verify(c.locking, c.version, c.lockTime, c.sequence); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, err)
+		}
+	}
+
+	if err := verify(core.NewScriptBuilder().AddOp(core.OP_CHECKLOCKTIMEVERIFY).Script(), 1, 100, 0); err == nil {
+		t.Errorf("OP_CHECKLOCKTIMEVERIFY on an empty stack accepted")
+	}
+}
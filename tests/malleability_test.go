@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// flipS returns a copy of the P2PKH-signed tx whose first signature is
+// replaced by (r, n-s), the other valid form of the same signature
+func flipS(tx core.Tx, pubKey []byte) core.Tx {
+	sig := append([]byte(nil), tx.TxIns[0].UnlockingScript[1:66]...)
+	s := new(big.Int).SetBytes(sig[32:64])
+	s.Sub(elliptic.P256().Params().N, s)
+	s.FillBytes(sig[32:64])
+
+	flipped := tx
+	flipped.TxIns = append([]core.TxIn(nil), tx.TxIns...)
+	flipped.TxIns[0].UnlockingScript = core.PubKeyHashUnlockingScript(sig, pubKey)
+	return flipped
+}
+
+func TestLowSSignatures(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+	for i := 0; i < 20; i++ {
+		sig, err := alice.SignECDSA(core.HashSHA3([]byte{byte(i)}))
+		if err != nil {
+			t.Fatalf("SignECDSA: %v", err)
+		}
+		if new(big.Int).SetBytes(sig[32:]).Cmp(halfOrder) > 0 {
+			t.Fatalf("SignECDSA returned a high S signature")
+		}
+	}
+
+	server, _ := fundedChain(t, alice)
+	spend, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 3000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+
+	// The flipped signature verifies, but is rejected; it keeps the ID
+	flipped := flipS(spend, alice.PublicKey)
+	if flipped.ID() != spend.ID() || flipped.WitnessHash() == spend.WitnessHash() {
+		t.Errorf("malleated signature must keep the ID and change the witness hash")
+	}
+	if resp := submitTx(t, server, flipped); !strings.Contains(resp, "high S") {
+		t.Errorf("high S signature accepted: %s", resp)
+	}
+	if resp := submitTx(t, server, spend); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("low S signature rejected: %s", resp)
+	}
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	server, genesis := fundedChain(t, alice)
+
+	// Bob spends the parent before it is confirmed
+	parent, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 6000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	parentOuts := map[string]core.TxOut{parent.ID() + ":0": parent.TxOuts[0]}
+	child, _, err := bob.BuildTransactionToAddress(alice.GetAddress(), 2000, 1, parentOuts)
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+
+	// Alice signs the parent again with a fresh nonce: new signature, same
+	// ID, so the child is still valid
+	alice.NonceEntropy = rand.Reader
+	resigned := parent
+	resigned.TxIns = append([]core.TxIn(nil), parent.TxIns...)
+	if err := alice.SignTx(&resigned, server.GetUTXOSet(), core.SigHashAll); err != nil {
+		t.Fatalf("SignTx: %v", err)
+	}
+	if resigned.ID() != parent.ID() || resigned.WitnessHash() == parent.WitnessHash() {
+		t.Fatalf("new signature must keep the ID and change the witness hash")
+	}
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{resigned, child})
+
+	// Swapping in the first signature keeps the Merkle root, not the witness root
+	mutated := block
+	mutated.Transactions = append([]core.Tx(nil), block.Transactions...)
+	mutated.Transactions[1] = parent
+	if !mutated.HasValidMerkleRoot() {
+		t.Fatalf("the Merkle root must not cover signatures")
+	}
+	if resp := submitBlock(t, server, mutated); !strings.Contains(resp, "bad-witness-merkle-match") {
+		t.Errorf("block with other unlocking scripts accepted: %s", resp)
+	}
+
+	// The mutated copy does not get the real block blacklisted
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("block with a spend of a re-signed parent rejected: %s", resp)
+	}
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestMempoolConflictsAndFeeRate(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	low := spend(t, alice, genesisTx, []uint32{0}, []uint64{9700})
+	mid := spend(t, alice, genesisTx, []uint32{1}, []uint64{9000})
+	high := spend(t, alice, genesisTx, []uint32{2}, []uint64{7000})
+	for _, tx := range []core.Tx{low, mid, high} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+	if resp := submitTx(t, server, mid); !strings.Contains(resp, "already in mempool") {
+		t.Errorf("duplicate transaction accepted: %s", resp)
+	}
+	conflict := spend(t, alice, genesisTx, []uint32{0}, []uint64{5000})
+	if resp := submitTx(t, server, conflict); !strings.Contains(resp, "conflicts with mempool transaction "+low.ID()) {
+		t.Errorf("conflicting transaction accepted: %s", resp)
+	}
+
+	entries := server.MempoolEntries()
+	if len(entries) != 3 {
+		t.Fatalf("mempool has %d transactions, expected 3", len(entries))
+	}
+	for i, want := range []struct {
+		id  string
+		fee uint64
+	}{{high.ID(), 3000}, {mid.ID(), 1000}, {low.ID(), 300}} {
+		if entries[i].ID != want.id || entries[i].Fee != want.fee {
+			t.Errorf("entry %d: %s with fee %d, expected %s with fee %d", i, entries[i].ID, entries[i].Fee, want.id, want.fee)
+		}
+		if rate := float64(want.fee) / float64(len(entries[i].Tx.Serialize())); entries[i].FeeRate() != rate {
+			t.Errorf("entry %d: fee-rate %f, expected %f", i, entries[i].FeeRate(), rate)
+		}
+	}
+
+	// The template takes the best paying transactions first and claims their fees
+	block := server.BlockTemplate(script)
+	if len(block.Transactions) != 4 {
+		t.Fatalf("template has %d transactions, expected 4", len(block.Transactions))
+	}
+	for i, tx := range []core.Tx{high, mid, low} {
+		if block.Transactions[i+1].ID() != tx.ID() {
+			t.Errorf("template transaction %d is not in fee-rate order", i+1)
+		}
+	}
+	if reward := block.Transactions[0].TxOuts[0].Amount; reward != testParams().InitialSubsidy+4300 {
+		t.Errorf("coinbase claims %d", reward)
+	}
+
+	if !block.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("template block rejected: %s", resp)
+	}
+	if n := len(server.MempoolEntries()); n != 0 {
+		t.Errorf("%d confirmed transactions left in the mempool", n)
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script, script, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	low := spend(t, alice, genesisTx, []uint32{0}, []uint64{9700})
+	high := spend(t, alice, genesisTx, []uint32{1}, []uint64{7000})
+	mid := spend(t, alice, genesisTx, []uint32{2}, []uint64{9000})
+	lowest := spend(t, alice, genesisTx, []uint32{3}, []uint64{9800})
+
+	// Room for two transactions
+	size := len(low.Serialize())
+	config := core.DefaultMempoolConfig
+	config.MaxSize = 2*size + size/2
+	server.SetMempoolConfig(config)
+	for _, tx := range []core.Tx{low, high, mid} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+	entries := server.MempoolEntries()
+	if len(entries) != 2 || entries[0].ID != high.ID() || entries[1].ID != mid.ID() {
+		t.Errorf("the lowest fee-rate transaction was not evicted: %v", entries)
+	}
+	if resp := submitTx(t, server, lowest); !strings.Contains(resp, "mempool full") {
+		t.Errorf("transaction paying less than the whole mempool accepted: %s", resp)
+	}
+
+	// Old transactions expire
+	config.MaxSize = 1_000_000
+	config.Expiry = 50 * time.Millisecond
+	server.SetMempoolConfig(config)
+	time.Sleep(100 * time.Millisecond)
+	if resp := submitTx(t, server, lowest); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+	if entries := server.MempoolEntries(); len(entries) != 1 || entries[0].ID != lowest.ID() {
+		t.Errorf("expired transactions left in the mempool: %d entries", len(entries))
+	}
+}
+
+func TestMinRelayFeeRate(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	free := spend(t, alice, genesisTx, []uint32{0}, []uint64{10000})
+	if resp := submitTx(t, server, free); !strings.Contains(resp, "below the minimum relay fee") {
+		t.Errorf("transaction without fee accepted: %s", resp)
+	}
+
+	// The wallet pays the fee-rate it is given for the size of the signed
+	// transaction, taking more inputs if needed
+	tx, keys, err := alice.BuildTransactionToAddress(alice.GetAddress(), 9900, 3, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	var totalOut uint64
+	for _, out := range tx.TxOuts {
+		totalOut += out.Amount
+	}
+	if fee := 10000*uint64(len(keys)) - totalOut; len(keys) != 2 || fee < 3*uint64(len(tx.Serialize())) {
+		t.Errorf("transaction spends %d outputs and pays %d for %d bytes", len(keys), fee, len(tx.Serialize()))
+	}
+	for i := 0; i < 10; i++ {
+		if _, again, err := alice.BuildTransactionToAddress(alice.GetAddress(), 9900, 3, server.GetUTXOSet()); err != nil || !slices.Equal(again, keys) {
+			t.Fatalf("coin selection is not deterministic: %v then %v (%v)", keys, again, err)
+		}
+	}
+	if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestMempoolSurvivesRestart(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// A payment, a child spending its change and a payment that a block will
+	// make invalid while the node is down
+	payment, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 3000, 1, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	child, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 2000, 1, outputOf(payment, 1))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	doomed := spend(t, alice, genesisTx, []uint32{1}, []uint64{9000})
+	for _, tx := range []core.Tx{payment, child, doomed} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+	before := server.MempoolEntries()
+	if err := server.SaveMempool(); err != nil {
+		t.Fatalf("SaveMempool: %v", err)
+	}
+	if tmp, _ := filepath.Glob("mempool.dat.tmp*"); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	// A block spends the output of the doomed transaction differently
+	conflict := spend(t, alice, genesisTx, []uint32{1}, []uint64{8000})
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{conflict})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block rejected: %s", resp)
+	}
+
+	// The restarted node loads the chain and the saved mempool, and only
+	// keeps what is still valid
+	restarted := core.NewBlockchainServerWithParams(testParams())
+	if restarted.ChainHeight() != 2 {
+		t.Fatalf("restarted node has %d blocks", restarted.ChainHeight())
+	}
+	after := restarted.MempoolEntries()
+	if len(after) != 2 {
+		t.Fatalf("restarted mempool has %d transactions, expected 2", len(after))
+	}
+	for _, e := range after {
+		if e.ID != payment.ID() && e.ID != child.ID() {
+			t.Errorf("invalid transaction %s reloaded", e.ID)
+		}
+		for _, b := range before {
+			if b.ID == e.ID && (b.Added.Unix() != e.Added.Unix() || b.Fee != e.Fee) {
+				t.Errorf("transaction %s reloaded with other fee or arrival time", e.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// passAlong simulates sending a partially signed transaction to the next signer
+func passAlong(t *testing.T, p *core.PartialTx) *core.PartialTx {
+	t.Helper()
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Error marshaling partial tx: %v", err)
+	}
+	var out core.PartialTx
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Error unmarshaling partial tx: %v", err)
+	}
+	return &out
+}
+
+func TestMultiSigTreasury(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	var signers []*core.Wallet
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		w, err := core.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		signers = append(signers, w)
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	funder, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	treasury, err := signers[0].CreateMultiSig(2, pubKeys)
+	if err != nil {
+		t.Fatalf("CreateMultiSig: %v", err)
+	}
+	if _, err := funder.CreateMultiSig(2, pubKeys); err == nil {
+		t.Errorf("wallet outside the multisig created its redeem data")
+	}
+
+	// The funder only needs the P2SH locking script to pay the treasury
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 10_000, LockingScript: funder.GetLockingScript()}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	fund, _, err := funder.BuildTransaction(treasury.LockingScript(), 8000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransaction: %v", err)
+	}
+	b1 := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{fund})
+	if resp := submitBlock(t, server, b1); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("funding block rejected: %s", resp)
+	}
+
+	// Spend the treasury with signers 0 and 2
+	fundID, _ := hex.DecodeString(fund.ID())
+	spend := core.Tx{
+		Version: 1,
+		TxIns:   []core.TxIn{{PrevTx: fundID, PrevIndex: 0, Net: "mainnet"}},
+		TxOuts:  []core.TxOut{{Amount: 7500, LockingScript: funder.GetLockingScript()}},
+	}
+	partial, err := core.NewPartialTx(spend, []core.TxOut{fund.TxOuts[0]}, [][]byte{treasury.RedeemScript})
+	if err != nil {
+		t.Fatalf("NewPartialTx: %v", err)
+	}
+	if _, err := core.NewPartialTx(spend, []core.TxOut{fund.TxOuts[0]}, [][]byte{{core.OP_1}}); err == nil {
+		t.Errorf("redeem script not matching the output accepted")
+	}
+
+	first := passAlong(t, partial)
+	if n, err := signers[0].SignPartialTx(first, core.SigHashAll); err != nil || n != 1 {
+		t.Fatalf("signer 0 signed %d inputs: %v", n, err)
+	}
+	if n, _ := funder.SignPartialTx(first, core.SigHashAll); n != 0 {
+		t.Errorf("non-member signed the multisig input")
+	}
+	if _, err := first.Finalize(); err == nil {
+		t.Errorf("finalized with 1 of 2 signatures")
+	}
+
+	second := passAlong(t, partial)
+	if _, err := signers[2].SignPartialTx(second, core.SigHashAll); err != nil {
+		t.Fatalf("signer 2: %v", err)
+	}
+	if err := first.Combine(second); err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	signed, err := first.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	b2 := mineBlock(t, blockHash(t, b1), 2, 3, []core.Tx{signed})
+	if resp := submitBlock(t, server, b2); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("multisig spend rejected: %s", resp)
+	}
+	var balance uint64
+	for _, out := range funder.FilterUTXOs(server.GetUTXOSet()) {
+		balance += out.Amount
+	}
+	if balance != fund.TxOuts[1].Amount+7500 {
+		t.Errorf("expected the funder to own change plus 7500, got %d", balance)
+	}
+}
+
+func TestPayToScriptHashRejectsWrongRedeemScript(t *testing.T) {
+	w, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	ms, err := core.NewMultiSig(1, [][]byte{w.PublicKey})
+	if err != nil {
+		t.Fatalf("NewMultiSig: %v", err)
+	}
+	prevOut := core.TxOut{Amount: 1000, LockingScript: ms.LockingScript()}
+	tx := core.Tx{
+		Version: 1,
+		TxIns:   []core.TxIn{{PrevTx: make([]byte, 32), PrevIndex: 3, Net: "mainnet"}},
+		TxOuts:  []core.TxOut{{Amount: 900, LockingScript: []byte("dest")}},
+	}
+
+	// A redeem script that always succeeds does not match the committed hash
+	tx.TxIns[0].UnlockingScript = core.NewScriptBuilder().AddData([]byte{core.OP_1}).Script()
+	if err := core.VerifyScript(&tx, 0, prevOut); err == nil {
+		t.Errorf("redeem script with the wrong hash accepted")
+	}
+
+	// The right redeem script without signatures fails when it runs
+	tx.TxIns[0].UnlockingScript = core.NewScriptBuilder().AddData(ms.RedeemScript).Script()
+	if err := core.VerifyScript(&tx, 0, prevOut); err == nil {
+		t.Errorf("redeem script without signatures accepted")
+	}
+
+	if _, err := core.NewMultiSig(3, [][]byte{w.PublicKey, w.PublicKey}); err == nil {
+		t.Errorf("threshold above the number of keys accepted")
+	}
+	// 16 compressed keys take 16·34 + 3 bytes
+	many := make([][]byte, 16)
+	for i := range many {
+		many[i] = w.PublicKey
+	}
+	if _, err := core.NewMultiSig(2, many); err == nil {
+		t.Errorf("redeem script larger than MaxScriptElementSize accepted")
+	}
+}
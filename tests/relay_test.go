@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// invCounter counts the INV messages a node receives
+type invCounter struct {
+	net.Conn
+	invs *atomic.Int32
+}
+
+func (c invCounter) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.invs.Add(int32(bytes.Count(p[:n], []byte("INV:"))))
+	return n, err
+}
+
+// listen serves the protocol of server on a local port and returns its
+// address and a counter of the INV messages it receives
+func listen(t *testing.T, server *core.BlockchainServer) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	invs := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.HandleConnection(invCounter{Conn: conn, invs: invs})
+		}
+	}()
+	addr := ln.Addr().String()
+	server.SetListenAddress(addr)
+	return addr, invs
+}
+
+func TestInvAsksOnlyForUnknownTransactions(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	known := spend(t, alice, genesisTx, []uint32{0}, []uint64{9000})
+	if resp := submitTx(t, server, known); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+	unknown := spend(t, alice, genesisTx, []uint32{1}, []uint64{9000})
+
+	inv, err := json.Marshal(core.InvMessage{TxIDs: []string{known.ID(), genesisTx.ID(), unknown.ID(), unknown.ID()}})
+	if err != nil {
+		t.Fatalf("Error marshaling inv: %v", err)
+	}
+	resp := server.ProcessMessage("INV:" + string(inv))
+	if !strings.HasPrefix(resp, "GETDATA:") {
+		t.Fatalf("unexpected answer to inv: %s", resp)
+	}
+	var getData core.GetDataMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(resp, "GETDATA:")), &getData); err != nil {
+		t.Fatalf("invalid getdata: %v", err)
+	}
+	if len(getData.TxIDs) != 1 || getData.TxIDs[0] != unknown.ID() {
+		t.Errorf("getdata asks for %v, expected only %s", getData.TxIDs, unknown.ID())
+	}
+}
+
+func TestTransactionsRelayBetweenPeers(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	nodeA, genesis := genesisPaying(t, script)
+	t.Chdir(t.TempDir())
+	nodeB := core.NewBlockchainServerWithParams(testParams())
+	if resp := submitBlock(t, nodeB, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	addrA, invsA := listen(t, nodeA)
+	addrB, _ := listen(t, nodeB)
+	nodeA.AddPeer(addrB)
+	nodeB.AddPeer(addrA)
+
+	// A announces a host name B does not use for it: peers are recognized by
+	// the IP they connect from and their listening port
+	_, portA, _ := net.SplitHostPort(addrA)
+	nodeA.SetListenAddress("node-a.invalid:" + portA)
+
+	// A payment and a child spending its change, both sent to A only
+	payment, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 3000, 1, outputOf(genesis.Transactions[0], 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	child, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 2000, 1, outputOf(payment, 1))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	for _, tx := range []core.Tx{payment, child} {
+		if resp := submitTx(t, nodeA, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(nodeB.MempoolEntries()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer has %d of the 2 relayed transactions", len(nodeB.MempoolEntries()))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, e := range nodeB.MempoolEntries() {
+		if e.ID != payment.ID() && e.ID != child.ID() {
+			t.Errorf("peer received unexpected transaction %s", e.ID)
+		}
+	}
+
+	// B knows A sent them: nothing is announced back
+	time.Sleep(300 * time.Millisecond)
+	if n := invsA.Load(); n != 0 {
+		t.Errorf("transactions echoed back to their sender: %d inv received", n)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+	"github.com/xkal1bur/blockchain/pkg/crypto"
+)
+
+// genesisPaying starts a chain whose genesis coinbase pays 10000 to each
+// locking script
+func genesisPaying(t *testing.T, scripts ...[]byte) (*core.BlockchainServer, core.Block) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+	var outs []core.TxOut
+	for _, script := range scripts {
+		outs = append(outs, core.TxOut{Amount: 10_000, LockingScript: script})
+	}
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{core.NewCoinbaseTx(0, outs)})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	return server, genesis
+}
+
+// withUnlocking returns tx with the P2PKH unlocking script of a SIGHASH_ALL
+// signature by pubKey in its first input
+func withUnlocking(tx core.Tx, sig, pubKey []byte) core.Tx {
+	tx.TxIns = append([]core.TxIn(nil), tx.TxIns...)
+	tx.TxIns[0].UnlockingScript = core.PubKeyHashUnlockingScript(append(sig, byte(core.SigHashAll)), pubKey)
+	return tx
+}
+
+func TestSchnorrInputs(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	alice.SigType = core.SigTypeSchnorr
+	bob, err := core.NewWalletWithKeyType(core.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob.SigType = core.SigTypeSchnorr
+	carol, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	server, genesis := genesisPaying(t, alice.GetLockingScript(), bob.GetLockingScript(), carol.GetLockingScript())
+	coinbase := genesis.Transactions[0]
+	fromAlice := spend(t, alice, coinbase, []uint32{0}, []uint64{9000})
+	fromBob := spend(t, bob, coinbase, []uint32{1}, []uint64{9000})
+	fromCarol := spend(t, carol, coinbase, []uint32{2}, []uint64{9000})
+	if in := fromBob.TxIns[0]; in.SigType != core.SigTypeSchnorr || len(in.UnlockingScript) == 0 {
+		t.Fatalf("Schnorr wallet signed an input of type %s", in.SigType)
+	}
+
+	// A corrupted Schnorr signature is rejected alone and inside a block,
+	// where it is only caught by the batch
+	bad := fromBob
+	bad.TxIns = append([]core.TxIn(nil), fromBob.TxIns...)
+	script := append([]byte(nil), bad.TxIns[0].UnlockingScript...)
+	script[40] ^= 1
+	bad.TxIns[0].UnlockingScript = script
+	if resp := submitTx(t, server, bad); strings.HasPrefix(resp, "SUCCESS") {
+		t.Errorf("corrupted Schnorr signature accepted: %s", resp)
+	}
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{fromAlice, bad, fromCarol})
+	if resp := submitBlock(t, server, block); !strings.Contains(resp, "batch verification") {
+		t.Errorf("block with a corrupted Schnorr signature not rejected by the batch: %s", resp)
+	}
+
+	// The signature type is committed: the same signature as ECDSA fails
+	retagged := fromAlice
+	retagged.TxIns = append([]core.TxIn(nil), fromAlice.TxIns...)
+	retagged.TxIns[0].SigType = core.SigTypeECDSA
+	if err := core.VerifyScript(&retagged, 0, coinbase.TxOuts[0]); err == nil {
+		t.Errorf("Schnorr input retagged as ECDSA verified")
+	}
+
+	block = mineBlock(t, blockHash(t, genesis), 1, 3, []core.Tx{fromAlice, fromBob, fromCarol})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block with Schnorr and ECDSA inputs rejected: %s", resp)
+	}
+	utxos := server.GetUTXOSet()
+	for _, tx := range []core.Tx{fromAlice, fromBob, fromCarol} {
+		if _, ok := utxos[tx.ID()+":0"]; !ok {
+			t.Errorf("output of %s missing from the UTXO set", tx.ID()[:8])
+		}
+	}
+}
+
+func TestKeyAggregation(t *testing.T) {
+	var signers []*core.Wallet
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		w, err := core.NewWalletWithKeyType(core.KeyTypeSecp256k1)
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		signers = append(signers, w)
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	agg, err := core.AggregateKeys(core.KeyTypeSecp256k1, pubKeys)
+	if err != nil {
+		t.Fatalf("AggregateKeys: %v", err)
+	}
+
+	server, genesis := genesisPaying(t, agg.LockingScript())
+	coinbase := genesis.Transactions[0]
+	prevID, _ := hex.DecodeString(coinbase.ID())
+	tx := core.Tx{
+		Version: 1,
+		TxIns: []core.TxIn{{PrevTx: prevID, Net: "mainnet",
+			KeyType: core.KeyTypeSecp256k1, SigType: core.SigTypeSchnorr}},
+		TxOuts: []core.TxOut{{Amount: 9000, LockingScript: signers[0].GetLockingScript()}},
+	}
+	hash, err := tx.SignatureHash(0, coinbase.TxOuts[0], core.SigHashAll)
+	if err != nil {
+		t.Fatalf("SignatureHash: %v", err)
+	}
+
+	// Round 1: every signer shares a nonce; round 2: partial signatures
+	var secrets []*crypto.SchnorrNonce
+	var nonces, partials [][]byte
+	for range signers {
+		nonce, err := agg.NewNonce()
+		if err != nil {
+			t.Fatalf("NewNonce: %v", err)
+		}
+		secrets = append(secrets, nonce)
+		nonces = append(nonces, nonce.Public)
+	}
+	for i, w := range signers {
+		partial, err := agg.PartialSign(w, secrets[i], nonces, hash)
+		if err != nil {
+			t.Fatalf("PartialSign: %v", err)
+		}
+		partials = append(partials, partial)
+	}
+	if _, err := agg.PartialSign(signers[0], secrets[0], nonces, hash); err == nil {
+		t.Errorf("nonce used twice")
+	}
+
+	// Two of the three signers are not enough
+	short, _ := agg.CombineSignatures(nonces[:2], partials[:2])
+	shortTx := withUnlocking(tx, short, agg.PubKey())
+	if err := core.VerifyScript(&shortTx, 0, coinbase.TxOuts[0]); err == nil {
+		t.Errorf("signature of two of the three signers verified")
+	}
+
+	sig, err := agg.CombineSignatures(nonces, partials)
+	if err != nil {
+		t.Fatalf("CombineSignatures: %v", err)
+	}
+	tx = withUnlocking(tx, sig, agg.PubKey())
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{tx})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("spend with the aggregated signature rejected: %s", resp)
+	}
+}
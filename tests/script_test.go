@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// verifyScripts spends an output locked by locking with an input unlocked by
+// unlocking
+func verifyScripts(unlocking, locking []byte) error {
+	tx := core.Tx{
+		Version: 1,
+		TxIns:   []core.TxIn{{PrevTx: make([]byte, 32), PrevIndex: 7, UnlockingScript: unlocking, Net: "mainnet"}},
+		TxOuts:  []core.TxOut{{Amount: 1000, LockingScript: []byte("dest")}},
+	}
+	return core.VerifyScript(&tx, 0, core.TxOut{Amount: 1000, LockingScript: locking})
+}
+
+func script(ops ...byte) []byte {
+	return ops
+}
+
+func TestScriptEngine(t *testing.T) {
+	b := core.NewScriptBuilder
+	secretHash := sha256.Sum256([]byte("secret"))
+
+	valid := map[string][2][]byte{
+		"arithmetic":  {nil, script(core.OP_1+1, core.OP_1+2, core.OP_ADD, core.OP_1+4, core.OP_EQUAL)},
+		"big numbers": {b().AddInt64(1000).Script(), b().AddInt64(-1000).AddOp(core.OP_ADD).AddOp(core.OP_NOT).Script()},
+		"within":      {b().AddInt64(5).Script(), b().AddInt64(0).AddInt64(10).AddOp(core.OP_WITHIN).Script()},
+		"if branch": {script(core.OP_1), script(core.OP_IF, core.OP_1+1, core.OP_ELSE, core.OP_0, core.OP_ENDIF,
+			core.OP_1+1, core.OP_NUMEQUAL)},
+		"else branch": {script(core.OP_0), script(core.OP_IF, core.OP_0, core.OP_ELSE, core.OP_1+2, core.OP_ENDIF,
+			core.OP_1+2, core.OP_NUMEQUAL)},
+		"nested skipped if": {script(core.OP_0), script(core.OP_IF, core.OP_0, core.OP_IF, core.OP_RETURN, core.OP_ENDIF,
+			core.OP_ELSE, core.OP_1, core.OP_ENDIF)},
+		"hash lock": {b().AddData([]byte("secret")).Script(),
+			b().AddOp(core.OP_SHA256).AddData(secretHash[:]).AddOp(core.OP_EQUAL).Script()},
+	}
+	for name, s := range valid {
+		if err := verifyScripts(s[0], s[1]); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	nops := bytes.Repeat([]byte{core.OP_NOP}, core.MaxOpsPerScript+1)
+	pushes := bytes.Repeat([]byte{core.OP_1}, core.MaxStackSize+1)
+	invalid := map[string][2][]byte{
+		"false result":      {nil, script(core.OP_1, core.OP_1+1, core.OP_EQUAL)},
+		"empty stack":       {nil, nil},
+		"op_return":         {nil, script(core.OP_1, core.OP_RETURN)},
+		"unbalanced if":     {script(core.OP_1), script(core.OP_IF, core.OP_1)},
+		"stray else":        {nil, script(core.OP_ELSE, core.OP_1)},
+		"failed verify":     {nil, script(core.OP_0, core.OP_VERIFY, core.OP_1)},
+		"unknown opcode":    {nil, script(core.OP_1, 0xff)},
+		"stack underflow":   {nil, script(core.OP_DUP)},
+		"5-byte operand":    {b().AddInt64(1 << 32).Script(), script(core.OP_1ADD)},
+		"non-minimal num":   {script(core.OP_DATA_1, 0x00), script(core.OP_NOT)},
+		"non-push unlock":   {script(core.OP_1, core.OP_DUP), script(core.OP_EQUAL)},
+		"non-minimal push":  {script(core.OP_PUSHDATA1, 1, 0x42), script(core.OP_DROP, core.OP_1)},
+		"pushdata4 unlock":  {script(0x4e), script(core.OP_DROP, core.OP_1)},
+		"pushdata4":         {nil, script(0x4e, core.OP_DROP, core.OP_1)},
+		"truncated push":    {nil, script(core.OP_DATA_1+4, 1, 2)},
+		"too many ops":      {nil, append(nops, core.OP_1)},
+		"stack too big":     {pushes, script(core.OP_DROP)},
+		"element too big":   {b().AddData(make([]byte, core.MaxScriptElementSize+1)).Script(), script(core.OP_DROP, core.OP_1)},
+		"script too big":    {nil, append(bytes.Repeat([]byte{core.OP_1}, core.MaxScriptSize), core.OP_DROP)},
+		"empty sig p2pkh":   {script(core.OP_0, core.OP_0), core.PayToPubKeyHashScript(core.HashSHA3(nil))},
+		"bad multisig keys": {nil, script(core.OP_0, core.OP_1, core.OP_CHECKMULTISIG)},
+	}
+	for name, s := range invalid {
+		if err := verifyScripts(s[0], s[1]); err == nil {
+			t.Errorf("%s: script accepted", name)
+		}
+	}
+
+	if core.IsPushOnly(script(core.OP_0, 0x4e)) {
+		t.Errorf("OP_PUSHDATA4 counted as a push")
+	}
+	if got := core.DisasmScript(core.PayToPubKeyHashScript(make([]byte, 32))); !strings.HasPrefix(got, "OP_DUP OP_SHA3 0000") {
+		t.Errorf("unexpected disassembly %q", got)
+	}
+}
+
+func TestCheckSigAndMultiSig(t *testing.T) {
+	var wallets []*core.Wallet
+	for i := 0; i < 3; i++ {
+		w, err := core.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		wallets = append(wallets, w)
+	}
+
+	// Bare 2-of-3 multisig: OP_2 <pk1> <pk2> <pk3> OP_3 OP_CHECKMULTISIG
+	lock := core.NewScriptBuilder().AddInt64(2)
+	for _, w := range wallets {
+		lock.AddData(w.PublicKey)
+	}
+	locking := lock.AddInt64(3).AddOp(core.OP_CHECKMULTISIG).Script()
+	prevOut := core.TxOut{Amount: 5000, LockingScript: locking}
+
+	tx := core.Tx{
+		Version: 1,
+		TxIns:   []core.TxIn{{PrevTx: make([]byte, 32), PrevIndex: 1, Net: "mainnet"}},
+		TxOuts:  []core.TxOut{{Amount: 4000, LockingScript: wallets[0].GetLockingScript()}},
+	}
+	sign := func(w *core.Wallet) []byte {
+		hash, err := tx.SignatureHash(0, prevOut, core.SigHashAll)
+		if err != nil {
+			t.Fatalf("SignatureHash: %v", err)
+		}
+		sig, err := w.SignECDSA(hash)
+		if err != nil {
+			t.Fatalf("SignECDSA: %v", err)
+		}
+		return append(sig, byte(core.SigHashAll))
+	}
+	sig0, sig1, sig2 := sign(wallets[0]), sign(wallets[1]), sign(wallets[2])
+
+	cases := []struct {
+		name string
+		sigs [][]byte
+		ok   bool
+	}{
+		{"first and third", [][]byte{sig0, sig2}, true},
+		{"second and third", [][]byte{sig1, sig2}, true},
+		{"wrong order", [][]byte{sig2, sig0}, false},
+		{"same signature twice", [][]byte{sig0, sig0}, false},
+		{"only one", [][]byte{sig1}, false},
+	}
+	for _, c := range cases {
+		unlock := core.NewScriptBuilder()
+		for _, sig := range c.sigs {
+			unlock.AddData(sig)
+		}
+		tx.TxIns[0].UnlockingScript = unlock.Script()
+		if err := core.VerifyScript(&tx, 0, prevOut); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, err)
+		}
+	}
+
+	// P2PKH is just one more template
+	p2pkh := core.TxOut{Amount: 5000, LockingScript: wallets[1].GetLockingScript()}
+	if err := wallets[1].SignInput(&tx, 0, p2pkh, core.SigHashAll); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	if err := core.VerifyScript(&tx, 0, p2pkh); err != nil {
+		t.Errorf("P2PKH spend rejected: %v", err)
+	}
+	if err := core.VerifyScript(&tx, 0, core.TxOut{Amount: 5000, LockingScript: wallets[2].GetLockingScript()}); err == nil {
+		t.Errorf("P2PKH spend with another key accepted")
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestSigHashTypes(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	prev := core.Tx{Version: 1, TxOuts: []core.TxOut{
+		{Amount: 5000, LockingScript: alice.GetLockingScript()},
+		{Amount: 5000, LockingScript: alice.GetLockingScript()},
+		{Amount: 7000, LockingScript: bob.GetLockingScript()},
+	}}
+	utxos := core.NewUTXOView(map[string]core.TxOut{})
+	utxos.ApplyTx(&prev)
+	prevID, _ := hex.DecodeString(prev.ID())
+
+	input := func(idx uint32) core.TxIn {
+		return core.TxIn{PrevTx: prevID, PrevIndex: idx, Net: "mainnet"}
+	}
+	valid := func(tx core.Tx) bool {
+		_, err := tx.Validate(utxos)
+		return err == nil
+	}
+
+	// A signature is bound to its input: copying it to another input fails
+	tx := core.Tx{Version: 1, TxIns: []core.TxIn{input(0), input(1)}, TxOuts: []core.TxOut{{Amount: 9000, LockingScript: []byte("shop")}}}
+	if err := alice.SignInput(&tx, 0, prev.TxOuts[0], core.SigHashAll); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	tx.TxIns[1].UnlockingScript = tx.TxIns[0].UnlockingScript
+	if valid(tx) {
+		t.Errorf("signature reused on another input accepted")
+	}
+	if err := alice.SignInput(&tx, 1, prev.TxOuts[1], core.SigHashAll); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	if !valid(tx) {
+		t.Fatalf("SIGHASH_ALL transaction rejected")
+	}
+	tx.TxOuts[0].Amount = 8000
+	if valid(tx) {
+		t.Errorf("SIGHASH_ALL signature survived an output change")
+	}
+
+	// Signing for the wrong amount fails
+	tx.TxOuts[0].Amount = 9000
+	if err := alice.SignInput(&tx, 1, core.TxOut{Amount: 1, LockingScript: alice.GetLockingScript()}, core.SigHashAll); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	if valid(tx) {
+		t.Errorf("signature over the wrong spent amount accepted")
+	}
+
+	// Crowdfunding: each pledge signs ALL|ANYONECANPAY, more inputs can be added
+	goal := core.TxOut{Amount: 12_000, LockingScript: []byte("project")}
+	fund := core.Tx{Version: 1, TxIns: []core.TxIn{input(0)}, TxOuts: []core.TxOut{goal}}
+	if err := alice.SignInput(&fund, 0, prev.TxOuts[0], core.SigHashAll|core.SigHashAnyoneCanPay); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	fund.TxIns = append(fund.TxIns, input(2))
+	if err := bob.SignInput(&fund, 1, prev.TxOuts[2], core.SigHashAll|core.SigHashAnyoneCanPay); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	if !valid(fund) {
+		t.Errorf("crowdfunding transaction rejected")
+	}
+	fund.TxOuts[0].LockingScript = []byte("thief")
+	if valid(fund) {
+		t.Errorf("ANYONECANPAY signature survived an output change")
+	}
+
+	// SINGLE commits only to the output with the same index
+	single := core.Tx{Version: 1, TxIns: []core.TxIn{input(0)}, TxOuts: []core.TxOut{
+		{Amount: 3000, LockingScript: []byte("alice")},
+		{Amount: 1000, LockingScript: []byte("anyone")},
+	}}
+	if err := alice.SignInput(&single, 0, prev.TxOuts[0], core.SigHashSingle); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	single.TxOuts[1].LockingScript = []byte("someone else")
+	if !valid(single) {
+		t.Errorf("SIGHASH_SINGLE rejected after changing another output")
+	}
+	single.TxOuts[0].Amount = 4000
+	if valid(single) {
+		t.Errorf("SIGHASH_SINGLE survived a change of its output")
+	}
+	if _, err := single.SignatureHash(0, prev.TxOuts[0], core.SigHashSingle); err != nil {
+		t.Errorf("SignatureHash: %v", err)
+	}
+	single.TxOuts = nil
+	if _, err := single.SignatureHash(0, prev.TxOuts[0], core.SigHashSingle); err == nil {
+		t.Errorf("SIGHASH_SINGLE without matching output must fail")
+	}
+
+	// NONE lets anyone choose the outputs
+	none := core.Tx{Version: 1, TxIns: []core.TxIn{input(1)}, TxOuts: []core.TxOut{{Amount: 4000, LockingScript: []byte("a")}}}
+	if err := alice.SignInput(&none, 0, prev.TxOuts[1], core.SigHashNone); err != nil {
+		t.Fatalf("SignInput: %v", err)
+	}
+	none.TxOuts[0].LockingScript = []byte("b")
+	if !valid(none) {
+		t.Errorf("SIGHASH_NONE rejected after changing the outputs")
+	}
+
+	// Unknown sighash types are rejected (the type follows the 65 byte push opcode and r||s)
+	none.TxIns[0].UnlockingScript[65] = 0x04
+	if valid(none) {
+		t.Errorf("unknown sighash type accepted")
+	}
+}
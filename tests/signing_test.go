@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestDeterministicSignatures(t *testing.T) {
+	w, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	msg := core.HashSHA3([]byte("message"))
+
+	sig1, err := w.SignECDSA(msg)
+	if err != nil {
+		t.Fatalf("SignECDSA: %v", err)
+	}
+	sig2, _ := w.SignECDSA(msg)
+	if !bytes.Equal(sig1, sig2) {
+		t.Errorf("same key and message gave different signatures")
+	}
+	other, _ := w.SignECDSA(core.HashSHA3([]byte("other message")))
+	if bytes.Equal(sig1[:32], other[:32]) {
+		t.Errorf("different messages reused the nonce")
+	}
+
+	// A signed transaction is reproducible as a whole
+	prevOut := core.TxOut{Amount: 5000, LockingScript: w.GetLockingScript()}
+	build := func() core.Tx {
+		tx := core.Tx{
+			Version: 1,
+			TxIns:   []core.TxIn{{PrevTx: make([]byte, 32), Net: "mainnet"}},
+			TxOuts:  []core.TxOut{{Amount: 4000, LockingScript: []byte("dest")}},
+		}
+		if err := w.SignInput(&tx, 0, prevOut, core.SigHashAll); err != nil {
+			t.Fatalf("SignInput: %v", err)
+		}
+		return tx
+	}
+	if a, b := build(), build(); a.WitnessHash() != b.WitnessHash() {
+		t.Errorf("signing the same transaction twice gave different unlocking scripts")
+	}
+
+	// Extra entropy changes the nonce, not the validity
+	w.NonceEntropy = rand.Reader
+	hedged, err := w.SignECDSA(msg)
+	if err != nil {
+		t.Fatalf("SignECDSA: %v", err)
+	}
+	if bytes.Equal(hedged, sig1) {
+		t.Errorf("extra entropy did not change the signature")
+	}
+	tx := build()
+	if err := core.VerifyScript(&tx, 0, prevOut); err != nil {
+		t.Errorf("signature with extra entropy rejected: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestBlockTimestampRules(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	prev := mineBlock(t, make([]byte, 32), 0, 1000, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, prev); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	for i, ts := range []uint64{1010, 1020, 1030, 1040} {
+		block := mineBlock(t, blockHash(t, prev), uint64(i+1), ts, []core.Tx{})
+		if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("block rejected: %s", resp)
+		}
+		prev = block
+	}
+
+	// Median of 1000..1040 is 1020
+	if got := server.MedianTimePast(); got != 1020 {
+		t.Fatalf("expected median-time-past 1020, got %d", got)
+	}
+
+	old := mineBlock(t, blockHash(t, prev), 5, 1020, []core.Tx{})
+	if resp := submitBlock(t, server, old); !strings.Contains(resp, "[time-too-old]") {
+		t.Errorf("expected time-too-old rejection, got: %s", resp)
+	}
+
+	future := uint64(time.Now().Add(3 * time.Hour).Unix())
+	tooNew := mineBlock(t, blockHash(t, prev), 5, future, []core.Tx{})
+	if resp := submitBlock(t, server, tooNew); !strings.Contains(resp, "[time-too-new]") {
+		t.Errorf("expected time-too-new rejection, got: %s", resp)
+	}
+
+	// A block just after the median is fine, even if older than its parent
+	ok := mineBlock(t, blockHash(t, prev), 5, 1021, []core.Tx{})
+	if resp := submitBlock(t, server, ok); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block after median-time-past rejected: %s", resp)
+	}
+
+	tips := server.ChainTips()
+	if len(tips) != 1 || !tips[0].Active || tips[0].MedianTimePast != 1021 {
+		t.Errorf("unexpected chain tips: %+v", tips)
+	}
+}
+
+func TestNetworkTimeCountsEachPeerHostOnce(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{{Amount: 50, LockingScript: []byte("genesis")}})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+
+	// Blocks stamped an hour ahead, each claiming to come from another peer
+	ahead := time.Now().Add(time.Hour)
+	send := func(ip string, port int) {
+		data, err := json.Marshal(core.BlockMessage{Block: genesis, From: fmt.Sprintf("peer%d:8081", port), Time: ahead.Unix()})
+		if err != nil {
+			t.Fatalf("Error marshaling block: %v", err)
+		}
+		server.ProcessMessageFrom(&net.TCPAddr{IP: net.ParseIP(ip), Port: port}, "BLOCK:"+string(data))
+	}
+
+	// All from a single host: one sample, the clock is not adjusted
+	for port := 1; port <= 10; port++ {
+		send("10.0.0.1", port)
+	}
+	if ts := int64(server.BlockTemplate([]byte("miner")).Timestamp); ts > time.Now().Add(time.Minute).Unix() {
+		t.Errorf("one host moved network time to %d", ts)
+	}
+
+	// Five different hosts agree: the clock follows them
+	for i := 2; i <= 5; i++ {
+		send(fmt.Sprintf("10.0.0.%d", i), 8081)
+	}
+	if ts := int64(server.BlockTemplate([]byte("miner")).Timestamp); ts < ahead.Add(-time.Minute).Unix() {
+		t.Errorf("network time %d not adjusted to the peers' clocks", ts)
+	}
+}
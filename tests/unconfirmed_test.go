@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// outputOf returns a UTXO set with only output index of tx
+func outputOf(tx core.Tx, index int) map[string]core.TxOut {
+	return map[string]core.TxOut{fmt.Sprintf("%s:%d", tx.ID(), index): tx.TxOuts[index]}
+}
+
+func TestUnconfirmedChains(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	server, _ := fundedChain(t, alice)
+
+	// Alice spends her unconfirmed change
+	first, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 3000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, first); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("transaction rejected: %s", resp)
+	}
+	second, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 2000, 1, server.GetPendingUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress with the pending UTXO set: %v", err)
+	}
+	if resp := submitTx(t, server, second); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("spend of unconfirmed change rejected: %s", resp)
+	}
+
+	// Both go in the same block, parent first
+	block := server.BlockTemplate([]byte("miner"))
+	if len(block.Transactions) != 3 || block.Transactions[1].ID() != first.ID() || block.Transactions[2].ID() != second.ID() {
+		t.Fatalf("template does not hold the chain in order")
+	}
+	if !block.CalculateValidHash() {
+		t.Fatalf("failed to mine block")
+	}
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block rejected: %s", resp)
+	}
+	if got := sumUTXOs(bob.FilterUTXOs(server.GetUTXOSet())); got != 5000 {
+		t.Errorf("bob owns %d, expected 5000", got)
+	}
+}
+
+func TestOrphanTransactions(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	server, genesis := fundedChain(t, alice)
+
+	parent, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 9000, 1, server.GetUTXOSet())
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	child, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 8000, 1, outputOf(parent, 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+
+	// The child arrives first and waits for its parent
+	if resp := submitTx(t, server, child); !strings.HasPrefix(resp, "ORPHAN") {
+		t.Fatalf("child without parent not kept as orphan: %s", resp)
+	}
+	if resp := submitTx(t, server, child); !strings.Contains(resp, "already waiting") {
+		t.Errorf("duplicate orphan: %s", resp)
+	}
+	if n := server.OrphanTxCount(); n != 1 {
+		t.Errorf("%d orphans, expected 1", n)
+	}
+	if resp := submitTx(t, server, parent); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("parent rejected: %s", resp)
+	}
+	if n := server.OrphanTxCount(); n != 0 {
+		t.Errorf("orphan still waiting after its parent arrived")
+	}
+	if n := len(server.MempoolEntries()); n != 2 {
+		t.Errorf("mempool has %d transactions, expected parent and child", n)
+	}
+
+	// An output of a confirmed transaction that is not there was spent or
+	// never existed: that is not an orphan
+	genesisTx := genesis.Transactions[0]
+	missing := map[string]core.TxOut{genesisTx.ID() + ":7": genesisTx.TxOuts[0]}
+	bogus, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 5000, 1, missing)
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, bogus); !strings.HasPrefix(resp, "ERROR") {
+		t.Errorf("transaction spending a missing output of a confirmed transaction: %s", resp)
+	}
+}
+
+func TestUnconfirmedChainLimits(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	server, _ := fundedChain(t, alice)
+	config := core.DefaultMempoolConfig
+	config.MaxAncestors = 3
+	config.MaxDescendants = 3
+	server.SetMempoolConfig(config)
+
+	// A chain of three unconfirmed transactions is the longest allowed
+	utxos := server.GetUTXOSet()
+	amount := uint64(8000)
+	var chain []core.Tx
+	for i := 0; i < 4; i++ {
+		tx, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), amount, 1, utxos)
+		if err != nil {
+			t.Fatalf("BuildTransactionToAddress: %v", err)
+		}
+		resp := submitTx(t, server, tx)
+		if i < 3 && !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction %d rejected: %s", i, resp)
+		}
+		if i == 3 && !strings.Contains(resp, "too many unconfirmed ancestors") {
+			t.Errorf("fourth transaction of the chain accepted: %s", resp)
+		}
+		chain = append(chain, tx)
+		utxos = outputOf(tx, 0)
+		amount -= 1000
+	}
+
+	// The first transaction already has three descendants counting itself
+	sibling, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 600, 1, outputOf(chain[0], 1))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	if resp := submitTx(t, server, sibling); !strings.Contains(resp, "too many unconfirmed descendants") {
+		t.Errorf("transaction over the descendant limit accepted: %s", resp)
+	}
+}
+
+func TestConnectedBlockUpdatesMempool(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	script := alice.GetLockingScript()
+	server, genesis := genesisPaying(t, script, script, script)
+	genesisTx := genesis.Transactions[0]
+
+	// A parent the block confirms and its child, a chain the block double
+	// spends and an unrelated transaction
+	parent, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 5000, 1, outputOf(genesisTx, 0))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	child := spend(t, alice, parent, []uint32{0}, []uint64{4000})
+	doomed, _, err := alice.BuildTransactionToAddress(alice.GetAddress(), 5000, 1, outputOf(genesisTx, 1))
+	if err != nil {
+		t.Fatalf("BuildTransactionToAddress: %v", err)
+	}
+	doomedChild := spend(t, alice, doomed, []uint32{0}, []uint64{4000})
+	unrelated := spend(t, alice, genesisTx, []uint32{2}, []uint64{9500})
+	for _, tx := range []core.Tx{parent, child, doomed, doomedChild, unrelated} {
+		if resp := submitTx(t, server, tx); !strings.HasPrefix(resp, "SUCCESS") {
+			t.Fatalf("transaction rejected: %s", resp)
+		}
+	}
+
+	conflict := spend(t, alice, genesisTx, []uint32{1}, []uint64{8000})
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{parent, conflict})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block rejected: %s", resp)
+	}
+
+	entries := server.MempoolEntries()
+	if len(entries) != 2 {
+		t.Fatalf("mempool has %d transactions, expected 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.ID != child.ID() && e.ID != unrelated.ID() {
+			t.Errorf("transaction %s should have been dropped", e.ID)
+		}
+	}
+	if tmpl := server.BlockTemplate(script); len(tmpl.Transactions) != 3 {
+		t.Errorf("template has %d transactions, expected 3", len(tmpl.Transactions))
+	}
+}
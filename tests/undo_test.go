@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+func TestDisconnectBlockRestoresUTXOSet(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := core.NewBlockchainServerWithParams(testParams())
+
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	genesisTx := core.NewCoinbaseTx(0, []core.TxOut{
+		{Amount: 1000, LockingScript: alice.GetLockingScript()},
+		{Amount: 500, LockingScript: alice.GetLockingScript()},
+	})
+	genesis := mineBlock(t, make([]byte, 32), 0, 1, []core.Tx{genesisTx})
+	if resp := submitBlock(t, server, genesis); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("genesis rejected: %s", resp)
+	}
+	before := server.GetUTXOSet()
+
+	tx, _, err := alice.BuildTransactionToAddress(bob.GetAddress(), 1000, 1, before)
+	if err != nil {
+		t.Fatalf("Failed to build transaction: %v", err)
+	}
+	block := mineBlock(t, blockHash(t, genesis), 1, 2, []core.Tx{tx})
+	if resp := submitBlock(t, server, block); !strings.HasPrefix(resp, "SUCCESS") {
+		t.Fatalf("block rejected: %s", resp)
+	}
+	if reflect.DeepEqual(before, server.GetUTXOSet()) {
+		t.Fatalf("connecting the block did not change the UTXO set")
+	}
+
+	// Undo records must survive a restart
+	server = core.NewBlockchainServerWithParams(testParams())
+
+	disconnected, err := server.DisconnectBlock()
+	if err != nil {
+		t.Fatalf("DisconnectBlock failed: %v", err)
+	}
+	if disconnected.Timestamp != block.Timestamp {
+		t.Errorf("disconnected the wrong block")
+	}
+	if got := server.GetUTXOSet(); !reflect.DeepEqual(before, got) {
+		t.Errorf("UTXO set not restored:\nwant %v\ngot  %v", before, got)
+	}
+	if got := server.ChainHeight(); got != 1 {
+		t.Errorf("expected height 1 after disconnect, got %d", got)
+	}
+}
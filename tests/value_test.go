@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/xkal1bur/blockchain/pkg/core"
+)
+
+// spend builds a transaction spending the given outputs of prev and signs it
+// with w
+func spend(t *testing.T, w *core.Wallet, prev core.Tx, indexes []uint32, amounts []uint64) core.Tx {
+	t.Helper()
+	prevID, _ := hex.DecodeString(prev.ID())
+	tx := core.Tx{Version: 1}
+	for _, idx := range indexes {
+		tx.TxIns = append(tx.TxIns, core.TxIn{PrevTx: prevID, PrevIndex: idx, Net: "mainnet"})
+	}
+	for _, amount := range amounts {
+		tx.TxOuts = append(tx.TxOuts, core.TxOut{Amount: amount, LockingScript: []byte("dest")})
+	}
+	for i, idx := range indexes {
+		if err := w.SignInput(&tx, i, prev.TxOuts[idx], core.SigHashAll); err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+	}
+	return tx
+}
+
+func TestTransactionValueRules(t *testing.T) {
+	alice, err := core.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	prev := core.Tx{Version: 1, TxOuts: []core.TxOut{
+		{Amount: 10_000, LockingScript: alice.GetLockingScript()},
+		{Amount: core.MaxMoney, LockingScript: alice.GetLockingScript()},
+	}}
+	utxos := core.NewUTXOView(map[string]core.TxOut{})
+	utxos.ApplyTx(&prev)
+
+	tx := spend(t, alice, prev, []uint32{0}, []uint64{6000, 3000})
+	fee, err := tx.Validate(utxos)
+	if err != nil {
+		t.Fatalf("valid transaction rejected: %v", err)
+	}
+	if fee != 1000 {
+		t.Errorf("expected fee 1000, got %d", fee)
+	}
+
+	invalid := map[string]core.Tx{
+		"outputs exceed inputs": spend(t, alice, prev, []uint32{0}, []uint64{10_001}),
+		"no outputs":            spend(t, alice, prev, []uint32{0}, nil),
+		"dust output":           spend(t, alice, prev, []uint32{0}, []uint64{9000, core.DustLimit - 1}),
+		"inputs exceed max":     spend(t, alice, prev, []uint32{0, 1}, []uint64{1000}),
+		"outputs exceed max":    spend(t, alice, prev, []uint32{1}, []uint64{core.MaxMoney, core.MaxMoney}),
+		"output sum wraps":      spend(t, alice, prev, []uint32{1}, []uint64{math.MaxUint64, 1000}),
+	}
+	for name, tx := range invalid {
+		if _, err := tx.Validate(utxos); err == nil {
+			t.Errorf("%s: transaction accepted", name)
+		}
+	}
+}